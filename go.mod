@@ -4,15 +4,16 @@ go 1.24.3
 
 require (
 	fyne.io/fyne/v2 v2.7.1
+	fyne.io/systray v1.11.1-0.20250603113521-ca66a66d8b58
 	github.com/sparkoo/acctelemetry-go v0.0.0-20250223130948-b99bf47f9660
 	github.com/sparkoo/racemate-msg v0.0.0-20250222194303-4d0c9129cee9
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/sys v0.33.0
 	google.golang.org/protobuf v1.36.10
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
 
 require (
-	fyne.io/systray v1.11.1-0.20250603113521-ca66a66d8b58 // indirect
 	github.com/BurntSushi/toml v1.5.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fredbi/uri v1.1.1 // indirect
@@ -41,7 +42,6 @@ require (
 	github.com/yuin/goldmark v1.7.11 // indirect
 	golang.org/x/image v0.27.0 // indirect
 	golang.org/x/net v0.40.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
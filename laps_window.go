@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+var lapsColumns = []string{"Recorded", "Track", "Car", "Lap time", "Session", "Status"}
+
+// showLapsWindow opens the window browsing the lap library
+func showLapsWindow(myApp fyne.App, appState *state.AppState) {
+	lapsWindow := myApp.NewWindow("RaceMate Laps")
+
+	var laps []library.Entry
+	table := widget.NewTable(
+		func() (int, int) { return len(laps) + 1, len(lapsColumns) },
+		func() fyne.CanvasObject { return widget.NewLabel("0000-00-00 00:00:00") },
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				label.SetText(lapsColumns[id.Col])
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			label.SetText(lapColumn(laps[id.Row-1], id.Col))
+		})
+	for col, width := range []float32{170, 140, 180, 90, 90, 90} {
+		table.SetColumnWidth(col, width)
+	}
+
+	trackEntry := widget.NewEntry()
+	trackEntry.SetPlaceHolder("Track")
+	carEntry := widget.NewEntry()
+	carEntry.SetPlaceHolder("Car")
+	bestCheck := widget.NewCheck("Best laps only", nil)
+
+	refresh := func() {
+		query := library.Query{Track: trackEntry.Text, CarModel: carEntry.Text}
+		if bestCheck.Checked {
+			laps = appState.Library.BestLaps(query)
+		} else {
+			laps = appState.Library.Find(query)
+		}
+		table.Refresh()
+	}
+	trackEntry.OnChanged = func(string) { refresh() }
+	carEntry.OnChanged = func(string) { refresh() }
+	bestCheck.OnChanged = func(bool) { refresh() }
+	refresh()
+
+	filters := container.NewGridWithColumns(4, trackEntry, carEntry, bestCheck, widget.NewButton("Refresh", refresh))
+	lapsWindow.SetContent(container.NewBorder(filters, nil, nil, nil, table))
+	lapsWindow.Resize(fyne.NewSize(800, 500))
+	lapsWindow.Show()
+}
+
+func lapColumn(lap library.Entry, col int) string {
+	switch col {
+	case 0:
+		return lap.RecordedAt.Local().Format("2006-01-02 15:04:05")
+	case 1:
+		return lap.Track
+	case 2:
+		return lap.CarModel
+	case 3:
+		return library.FormatLapTime(lap.LapTimeMs)
+	case 4:
+		return library.SessionTypeName(lap.SessionType)
+	default:
+		if lap.Uploaded {
+			return "uploaded"
+		}
+		return "pending"
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"runtime"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -14,9 +12,11 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
+	"fyne.io/systray"
 	"github.com/sparkoo/racemate-desktop/pkg/acc"
 	"github.com/sparkoo/racemate-desktop/pkg/auth"
-	"github.com/sparkoo/racemate-desktop/pkg/logger"
+	"github.com/sparkoo/racemate-desktop/pkg/bootstrap"
+	"github.com/sparkoo/racemate-desktop/pkg/cli"
 	"github.com/sparkoo/racemate-desktop/pkg/state"
 	"github.com/sparkoo/racemate-desktop/pkg/upload"
 	"github.com/sparkoo/racemate-desktop/pkg/webserver"
@@ -25,10 +25,20 @@ import (
 const APP_NAME = "RaceMate"
 const ACC_STATUS_LABEL_TEXT = `ACC session info: %s`
 const CONTEXT_TELEMETRY = "telemetry"
-const WEB_SERVER_PORT = 12123
 
 func main() {
-	appState, err := initApp(APP_NAME)
+	// commands like `racemate run --headless` or `racemate upload` run without the window
+	if !cli.IsGUI(os.Args[1:]) {
+		os.Exit(cli.New(APP_NAME, os.Stdout, os.Stderr).Run(os.Args[1:]))
+	}
+
+	global, _, err := cli.ParseGlobalFlags(os.Args[1:])
+	if err != nil {
+		slog.Error("Invalid arguments", "error", err)
+		os.Exit(2)
+	}
+
+	appState, err := bootstrap.InitApp(APP_NAME, bootstrap.Options{DataDir: global.DataDir, Console: os.Stdout})
 	if err != nil {
 		slog.Error("Fatal error during app initialization", "error", err)
 		os.Exit(1)
@@ -40,6 +50,9 @@ func main() {
 	ctx := context.WithValue(context.Background(), state.APP_STATE, appState)
 
 	myApp := app.New()
+	appState.Notifier = func(title, content string) {
+		myApp.SendNotification(fyne.NewNotification(title, content))
+	}
 	myWindow := myApp.NewWindow("RaceMate")
 
 	// Set a fixed window size
@@ -47,7 +60,7 @@ func main() {
 	myWindow.SetFixedSize(true)
 
 	// Create web server
-	webServer := webserver.NewServer(WEB_SERVER_PORT, myApp)
+	webServer := webserver.NewServer(appState.WebServerPort, myApp)
 	// Set auth manager for the web server
 	webServer.SetAuthManager(appState)
 
@@ -67,6 +80,19 @@ func main() {
 	userLabel := widget.NewLabel(userInfo)                      // Label for user login info
 
 	loginButton := widget.NewButton("Login", func() {
+		// OpenID Connect provider set in the settings replaces the Firebase login page
+		if appState.OIDCIssuer != "" {
+			userLabel.SetText("Log in in the browser")
+			go func() {
+				config := auth.OAuthConfig{Issuer: appState.OIDCIssuer, ClientID: appState.OIDCClientID}
+				if _, err := authManager.LoginWithOAuth(ctx, config, myApp.OpenURL); err != nil {
+					appState.Logger.Error("Login failed", "error", err)
+					updateLabel(userLabel, fmt.Sprintf("Login failed: %v", err))
+				}
+			}()
+			return
+		}
+
 		// Start web server and open browser for login
 		if webServer.IsActive() {
 			userLabel.SetText("Login server is already running")
@@ -101,9 +127,22 @@ func main() {
 		authButtons = container.NewVBox(loginButton)
 	}
 
+	deltaLabel := widget.NewLabel("") // Live delta to the best lap
+	deltaLabel.TextStyle = fyne.TextStyle{Bold: true, Monospace: true}
+
 	myWindow.SetContent(container.NewVBox(
 		statusLabel, // ACC status label
+		deltaLabel,
 		authButtons,
+		widget.NewButton("Laps", func() {
+			showLapsWindow(myApp, appState)
+		}),
+		widget.NewButton("Uploads", func() {
+			showUploadWindow(myApp, appState)
+		}),
+		widget.NewButton("Settings", func() {
+			showSettingsWindow(myApp, appState)
+		}),
 		widget.NewButton("Hide to Tray", func() {
 			myWindow.Hide()
 		}),
@@ -124,6 +163,12 @@ func main() {
 	go acc.TelemetryLoop(ctx)
 
 	go upload.UploadJob(ctx)
+	go func() {
+		deltas, _ := appState.SubscribeLiveDelta()
+		for delta := range deltas {
+			updateLabel(deltaLabel, formatLiveDelta(delta))
+		}
+	}()
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
 		for range ticker.C {
@@ -169,93 +214,67 @@ func main() {
 
 	// System Tray Support
 	if deskApp, ok := myApp.(desktop.App); ok {
+		pauseItem := fyne.NewMenuItem("Pause uploads", nil)
 		menu := fyne.NewMenu("MyApp",
 			fyne.NewMenuItem("Show Window", func() {
 				myWindow.Show()
 			}),
+			fyne.NewMenuItem("Laps", func() {
+				showLapsWindow(myApp, appState)
+			}),
+			fyne.NewMenuItem("Uploads", func() {
+				showUploadWindow(myApp, appState)
+			}),
+			fyne.NewMenuItem("Upload now", func() {
+				go uploadNow(appState)
+			}),
+			pauseItem,
+			fyne.NewMenuItem("Settings", func() {
+				showSettingsWindow(myApp, appState)
+			}),
 			fyne.NewMenuItem("Quit", func() {
 				myApp.Quit()
 			}),
 		)
+		pauseItem.Action = func() {
+			toggleUploadPause()
+			updateTrayPauseItem(menu, pauseItem)
+		}
 		deskApp.SetSystemTrayMenu(menu)
-	}
-
-	myWindow.ShowAndRun()
-}
 
-func initApp(appName string) (*state.AppState, error) {
-	appState := &state.AppState{
-		PollRate:  100 * time.Millisecond,
-		UploadURL: "https://lapupload-hwppiybqxq-ey.a.run.app",
-	}
-
-	if err := initDataDirs(appName, appState); err != nil {
-		return nil, fmt.Errorf("failed to init data dirs: %w", err)
+		// the tooltip shows how many laps wait for the upload
+		go func() {
+			ticker := time.NewTicker(5 * time.Second)
+			tooltip := ""
+			for range ticker.C {
+				status, err := upload.QueueStatus(appState)
+				if err != nil {
+					continue
+				}
+				if summary := status.Summary(); summary != tooltip {
+					tooltip = summary
+					systray.SetTooltip(APP_NAME + ": " + tooltip)
+				}
+				fyne.Do(func() {
+					updateTrayPauseItem(menu, pauseItem)
+				})
+			}
+		}()
 	}
 
-	initLogger(appState)
-
-	return appState, nil
-}
-
-func initLogger(appState *state.AppState) {
-	// Initialize logger with default configuration
-	config := logger.DefaultConfig(appState.LogsDir)
-	appState.Logger = logger.Initialize(config)
+	myWindow.ShowAndRun()
 }
 
-func initDataDirs(appName string, appState *state.AppState) error {
-	var appDataDir string
-
-	switch runtime.GOOS {
-	case "windows":
-		appDataDir = os.Getenv("AppData")
-		if appDataDir == "" {
-			appDataDir = filepath.Join(os.Getenv("LOCALAPPDATA"), appName)
-		}
-	default:
-		return fmt.Errorf("We can do only Windows: %s", appName)
+// updateTrayPauseItem labels the tray item by whether the uploads are paused
+func updateTrayPauseItem(menu *fyne.Menu, item *fyne.MenuItem) {
+	label := "Pause uploads"
+	if upload.Paused() {
+		label = "Resume uploads"
 	}
-
-	appDir := filepath.Join(appDataDir, appName)
-	if err := createFullDir(appDir); err != nil {
-		return fmt.Errorf("failed to create an app dir '%s': %w", appDir, err)
-	} else {
-		appState.DataDir = appDir
+	if item.Label != label {
+		item.Label = label
+		menu.Refresh()
 	}
-
-	uploadDir := filepath.Join(appDataDir, appName, "upload")
-	if err := createFullDir(uploadDir); err != nil {
-		return fmt.Errorf("failed to create an upload dir '%s': %w", uploadDir, err)
-	} else {
-		appState.UploadDir = uploadDir
-	}
-
-	uploadedDir := filepath.Join(appDataDir, appName, "uploaded")
-	if err := createFullDir(uploadedDir); err != nil {
-		return fmt.Errorf("failed to create an uploaded dir '%s': %w", uploadedDir, err)
-	} else {
-		appState.UploadedDir = uploadedDir
-	}
-
-	logsDir := filepath.Join(appDataDir, appName, "logs")
-	if err := createFullDir(logsDir); err != nil {
-		return fmt.Errorf("failed to create a logs dir '%s': %w", logsDir, err)
-	} else {
-		appState.LogsDir = logsDir
-	}
-
-	return nil
-}
-
-func createFullDir(dirPath string) error {
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		err := os.MkdirAll(dirPath, 0755)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
 }
 
 func updateLabel(label *widget.Label, text string) {
@@ -265,6 +284,20 @@ func updateLabel(label *widget.Label, text string) {
 	})
 }
 
+// formatLiveDelta formats the delta to the best lap as seconds, e.g. +0.312
+func formatLiveDelta(delta state.LiveDelta) string {
+	if !delta.Valid {
+		return ""
+	}
+	sign := "+"
+	deltaMs := delta.DeltaMs
+	if deltaMs < 0 {
+		sign = "-"
+		deltaMs = -deltaMs
+	}
+	return fmt.Sprintf("Delta to best: %s%d.%03d", sign, deltaMs/1000, deltaMs%1000)
+}
+
 func convertToString(chars []uint16) string {
 	var str string
 	for _, val := range chars {
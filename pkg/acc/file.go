@@ -1,108 +1,47 @@
 package acc
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
 	"github.com/sparkoo/racemate-desktop/pkg/state"
 	message "github.com/sparkoo/racemate-msg/dist"
 	"google.golang.org/protobuf/proto"
 )
 
-func saveToFile(ctx context.Context, filename string, data *message.Lap) error {
+// saveToFile saves the lap into the upload directory and returns the path of the saved file
+func saveToFile(ctx context.Context, filename string, data *message.Lap) (string, error) {
 	log := state.GetLogger(ctx)
 	log.Info("Saving lap to file")
-	protobufMessage, protoErr := proto.Marshal(data)
-	if protoErr != nil {
-		return fmt.Errorf("failed to marshal lap message with protobuf: %w", protoErr)
-	}
 
 	appState, err := state.GetAppState(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get app state to save to the file: %w", err)
+		return "", fmt.Errorf("failed to get app state to save to the file: %w", err)
 	}
 	filePath := filepath.Join(appState.UploadDir, filename)
 
-	return saveCompressed(filePath, protobufMessage)
+	return filePath, lapfile.Save(filePath, data)
 }
 
 func loadFromFile(filename string) (*message.Lap, error) {
-	data, _ := os.ReadFile(filename)
-
-	lap := &message.Lap{}
-	if err := proto.Unmarshal(data, lap); err != nil {
-		return nil, fmt.Errorf("failed to Unmarshal the data: %w", err)
-	}
-
-	return lap, nil
-}
-
-func loadFromFileCompressed(filename string) (*message.Lap, error) {
-	// 1. Open the compressed file
-	f, err := os.Open(filename) // Replace with your file name
-	if err != nil {
-		slog.Error("Error opening file", "error", err)
-		return nil, fmt.Errorf("failed to open the file to read: %w", err)
-	}
-	defer f.Close() // Important: Close the file when done
-
-	// 2. Create a gzip reader
-	gr, err := gzip.NewReader(f)
-	if err != nil {
-		return nil, fmt.Errorf("Error creating gzip reader: %w", err)
-	}
-	defer gr.Close() // Important: Close the gzip reader
-
-	// 3. Read and uncompress the data
-	var data bytes.Buffer       // Or []byte if you know the size beforehand
-	_, err = io.Copy(&data, gr) // Efficiently copies from reader to buffer
+	data, err := os.ReadFile(filename)
 	if err != nil {
-
-		return nil, fmt.Errorf("Error uncompressing data: %w", err)
+		return nil, fmt.Errorf("failed to read the file: %w", err)
 	}
 
-	// If you need the data as a byte slice:
-	uncompressedData := data.Bytes()
-
 	lap := &message.Lap{}
-	if err := proto.Unmarshal(uncompressedData, lap); err != nil {
+	if err := proto.Unmarshal(data, lap); err != nil {
 		return nil, fmt.Errorf("failed to Unmarshal the data: %w", err)
 	}
 
 	return lap, nil
 }
 
-func saveCompressed(filename string, data []byte) error {
-	compressedFilename := filename + ".gzip"
-	f, err := os.Create(compressedFilename)
-	if err != nil {
-		return fmt.Errorf("failed to create compressed file: %w", err)
-	}
-	defer f.Close()
-
-	w := gzip.NewWriter(f)
-	defer w.Close()
-
-	_, err = w.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to write compressed data: %w", err)
-	}
-
-	err = w.Flush()
-	if err != nil {
-		return fmt.Errorf("failed to flush compressed data: %w", err)
-	}
-
-	return nil
-}
-
 func saveToJson(filename string, lap *message.Lap) error {
 	slog.Info("Saving lap to JSON", "filename", filename)
 	jsonData, err := json.MarshalIndent(lap, "", "  ") // Use MarshalIndent for pretty printing
@@ -0,0 +1,71 @@
+package acc
+
+import (
+	"context"
+
+	"github.com/sparkoo/racemate-desktop/pkg/analysis"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// liveDelta computes the delta of the lap being driven to the best lap of the track and car in the library
+type liveDelta struct {
+	track     string
+	carModel  string
+	file      string
+	reference *analysis.Trace
+}
+
+// newLap prepares the delta for just started lap. The best lap is loaded again only when it changed.
+func (d *liveDelta) newLap(ctx context.Context, lap *message.Lap) {
+	appState, err := state.GetAppState(ctx)
+	if err != nil || appState.Library == nil {
+		d.reference = nil
+		return
+	}
+
+	best, found := appState.Library.BestLap(lap.Track, lap.CarModel)
+	if !found {
+		d.track, d.carModel, d.file, d.reference = lap.Track, lap.CarModel, "", nil
+		return
+	}
+	if d.reference != nil && d.track == lap.Track && d.carModel == lap.CarModel && d.file == best.File {
+		return
+	}
+
+	d.track, d.carModel, d.file, d.reference = lap.Track, lap.CarModel, best.File, nil
+	bestLap, err := appState.Library.Load(best)
+	if err != nil {
+		appState.Logger.Warn("Failed to load the best lap for live delta", "file", best.File, "error", err)
+		return
+	}
+	reference, err := analysis.NewTrace(bestLap, analysis.ByPosition)
+	if err != nil {
+		appState.Logger.Warn("Best lap can't be used for live delta", "file", best.File, "error", err)
+		return
+	}
+	d.reference = reference
+}
+
+// update publishes the delta at the frame just added to the lap
+func (d *liveDelta) update(ctx context.Context, lap *message.Lap, frame *message.Frame) {
+	appState, err := state.GetAppState(ctx)
+	if err != nil {
+		return
+	}
+	delta := state.LiveDelta{Track: lap.Track, CarModel: lap.CarModel, Position: frame.NormalizedCarPosition}
+	// current time is the lap time from the game, so the delta is right even when we joined in the middle of the lap
+	if d.reference != nil && float64(frame.NormalizedCarPosition) >= d.reference.Start() {
+		delta.Valid = true
+		delta.ReferenceLapTimeMs = d.reference.LapTimeMs
+		delta.DeltaMs = frame.CurrentTime - int32(d.reference.At(float64(frame.NormalizedCarPosition)).TimeMs+0.5)
+	}
+	appState.SetLiveDelta(delta)
+}
+
+// clear publishes there is no delta as nothing is driven
+func (d *liveDelta) clear(ctx context.Context) {
+	if appState, err := state.GetAppState(ctx); err == nil {
+		appState.SetLiveDelta(state.LiveDelta{})
+	}
+}
@@ -0,0 +1,60 @@
+package acc
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveDelta(t *testing.T) {
+	ctx, appState := setupTestContext(t)
+	appState.Library = library.Open(t.TempDir(), appState.UploadDir, t.TempDir(), appState.Logger)
+
+	best := syntheticLap()
+	best.LapTimeMs = 100000
+	best.Frames = syntheticLapFrames(100, 100000)
+	bestFile := filepath.Join(appState.UploadDir, "1740000000_monza_ferrari_296_gt3"+lapfile.SUFFIX)
+	assert.NoError(t, lapfile.Save(bestFile, best))
+	_, err := appState.Library.Add(bestFile, best)
+	assert.NoError(t, err)
+
+	deltas, cancel := appState.SubscribeLiveDelta()
+	defer cancel()
+
+	source := NewScriptedSource(syntheticLap(), nil)
+	scraper := &Scraper{currentLap: source.NewLap()}
+	scraper.delta.newLap(ctx, scraper.currentLap)
+
+	// the lap is 1% slower all the way
+	frames := syntheticLapFrames(100, 101000)
+	for _, frame := range frames[:50] {
+		scraper.processFrame(ctx, frame, source)
+		scraper.lastFrame = frame
+	}
+
+	delta := <-deltas
+	assert.True(t, delta.Valid)
+	assert.Equal(t, "monza", delta.Track)
+	assert.Equal(t, int32(100000), delta.ReferenceLapTimeMs)
+	assert.InDelta(t, 495, delta.DeltaMs, 1)
+
+	scraper.stop(ctx)
+	assert.False(t, appState.LiveDelta().Valid)
+}
+
+func TestLiveDeltaWithoutBestLap(t *testing.T) {
+	ctx, appState := setupTestContext(t)
+	appState.Library = library.Open(t.TempDir(), appState.UploadDir, t.TempDir(), appState.Logger)
+
+	source := NewScriptedSource(syntheticLap(), nil)
+	scraper := &Scraper{currentLap: source.NewLap()}
+	scraper.delta.newLap(ctx, scraper.currentLap)
+	scraper.processFrame(ctx, syntheticLapFrames(100, 101000)[0], source)
+
+	delta := appState.LiveDelta()
+	assert.False(t, delta.Valid)
+	assert.Equal(t, "monza", delta.Track)
+}
@@ -0,0 +1,455 @@
+package acc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"google.golang.org/protobuf/proto"
+)
+
+const recordingVersion = 1
+
+// RecordingSuffix is the file suffix of the recorded telemetry sessions
+const RecordingSuffix = ".rec.gzip"
+
+const (
+	eventHeader    = "header"
+	eventLap       = "lap"
+	eventFrame     = "frame"
+	eventPlayerCar = "playerCar"
+	eventCarUpdate = "carUpdate"
+)
+
+// recordedEvent is a single line of the recording. At is the time since the start of the recording.
+type recordedEvent struct {
+	At        time.Duration  `json:"at"`
+	Kind      string         `json:"kind"`
+	Header    *recordHeader  `json:"header,omitempty"`
+	Lap       *message.Lap   `json:"lap,omitempty"`
+	Frame     *message.Frame `json:"frame,omitempty"`
+	PlayerCar *PlayerCar     `json:"playerCar,omitempty"`
+	CarUpdate *CarUpdate     `json:"carUpdate,omitempty"`
+}
+
+type recordHeader struct {
+	Version   int           `json:"version"`
+	StartedAt time.Time     `json:"startedAt"`
+	PollRate  time.Duration `json:"pollRate"`
+}
+
+// RecordingSource records everything the scraper reads from the wrapped source
+// into a gzipped JSON lines file, one file per connected session.
+type RecordingSource struct {
+	source   TelemetrySource
+	dir      string
+	pollRate func() time.Duration
+	logger   *slog.Logger
+	enabled  func() bool
+
+	mu            sync.Mutex
+	file          *os.File
+	gzipWriter    *gzip.Writer
+	encoder       *json.Encoder
+	started       time.Time
+	lastPlayerCar *PlayerCar
+	lastCarUpdate *CarUpdate
+}
+
+// NewRecordingSource wraps the source and records the session into given directory.
+// The recording is on while enabled returns true, nil enabled records always. The poll rate of the new session is
+// given by pollRate.
+func NewRecordingSource(source TelemetrySource, dir string, pollRate func() time.Duration, logger *slog.Logger, enabled func() bool) *RecordingSource {
+	return &RecordingSource{source: source, dir: dir, pollRate: pollRate, logger: logger, enabled: enabled}
+}
+
+func (r *RecordingSource) Connect() error {
+	return r.source.Connect()
+}
+
+// Close closes the wrapped source and finishes the current recording
+func (r *RecordingSource) Close() {
+	r.source.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.closeFile(); err != nil {
+		r.logger.Error("Failed to close telemetry recording", "error", err)
+	}
+}
+
+func (r *RecordingSource) Live() bool {
+	return r.source.Live()
+}
+
+func (r *RecordingSource) NewLap() *message.Lap {
+	lap := r.source.NewLap()
+	r.record(&recordedEvent{Kind: eventLap, Lap: lap})
+	return lap
+}
+
+func (r *RecordingSource) Frame() *message.Frame {
+	frame := r.source.Frame()
+	if frame != nil {
+		r.record(&recordedEvent{Kind: eventFrame, Frame: frame})
+	}
+	return frame
+}
+
+// PlayerCar returns the player's car state and records it when it has changed
+func (r *RecordingSource) PlayerCar() PlayerCar {
+	player := r.source.PlayerCar()
+	r.mu.Lock()
+	changed := r.lastPlayerCar == nil || *r.lastPlayerCar != player
+	r.lastPlayerCar = &player
+	r.mu.Unlock()
+	if changed {
+		r.record(&recordedEvent{Kind: eventPlayerCar, PlayerCar: &player})
+	}
+	return player
+}
+
+// CarUpdate returns the UDP car update and records it when it has changed
+func (r *RecordingSource) CarUpdate() *CarUpdate {
+	update := r.source.CarUpdate()
+	if update == nil {
+		return nil
+	}
+	r.mu.Lock()
+	changed := r.lastCarUpdate == nil || *r.lastCarUpdate != *update
+	updateCopy := *update
+	r.lastCarUpdate = &updateCopy
+	r.mu.Unlock()
+	if changed {
+		r.record(&recordedEvent{Kind: eventCarUpdate, CarUpdate: &updateCopy})
+	}
+	return update
+}
+
+func (r *RecordingSource) record(event *recordedEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.enabled != nil && !r.enabled() {
+		if err := r.closeFile(); err != nil {
+			r.logger.Error("Failed to close telemetry recording", "error", err)
+		}
+		return
+	}
+
+	if r.encoder == nil {
+		if err := r.openFile(); err != nil {
+			r.logger.Error("Failed to start telemetry recording", "error", err)
+			return
+		}
+	}
+
+	event.At = time.Since(r.started)
+	if err := r.encoder.Encode(event); err != nil {
+		r.logger.Error("Failed to record telemetry", "kind", event.Kind, "error", err)
+	}
+
+	// flush with every lap so we keep most of the session if the app crashes
+	if event.Kind == eventLap {
+		if err := r.gzipWriter.Flush(); err != nil {
+			r.logger.Error("Failed to flush telemetry recording", "error", err)
+		}
+	}
+}
+
+func (r *RecordingSource) openFile() error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create recordings directory: %w", err)
+	}
+
+	r.started = time.Now()
+	filename := filepath.Join(r.dir, strconv.FormatInt(r.started.Unix(), 10)+RecordingSuffix)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create recording file: %w", err)
+	}
+
+	r.file = f
+	r.gzipWriter = gzip.NewWriter(f)
+	r.encoder = json.NewEncoder(r.gzipWriter)
+	r.lastPlayerCar = nil
+	r.lastCarUpdate = nil
+	r.logger.Info("Recording telemetry session", "filename", filename)
+
+	return r.encoder.Encode(&recordedEvent{Kind: eventHeader, Header: &recordHeader{
+		Version:   recordingVersion,
+		StartedAt: r.started,
+		PollRate:  r.pollRate(),
+	}})
+}
+
+func (r *RecordingSource) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	defer func() {
+		r.file = nil
+		r.gzipWriter = nil
+		r.encoder = nil
+	}()
+
+	if err := r.gzipWriter.Close(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("failed to finish compressed recording: %w", err)
+	}
+	return r.file.Close()
+}
+
+// ReplaySource serves a recorded session. Frames and laps are returned in the recorded
+// order, player's car and UDP updates are the latest recorded at the time of the last frame
+// or later, when the clock was advanced past it.
+type ReplaySource struct {
+	mu sync.Mutex
+
+	header     recordHeader
+	laps       []*message.Lap
+	frames     []*recordedEvent
+	players    []*recordedEvent
+	carUpdates []*recordedEvent
+
+	nextLap   int
+	nextFrame int
+	clock     time.Duration
+	connected bool
+}
+
+// LoadRecording reads the recorded session from the file
+func LoadRecording(filename string) (*ReplaySource, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the recording: %w", err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gr.Close()
+
+	return readRecording(gr)
+}
+
+func readRecording(r io.Reader) (*ReplaySource, error) {
+	replay := &ReplaySource{}
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		event := &recordedEvent{}
+		if err := decoder.Decode(event); err != nil {
+			if err == io.EOF {
+				break
+			}
+			// recording of crashed session is cut in the middle, keep what we have
+			if err == io.ErrUnexpectedEOF && len(replay.frames) > 0 {
+				break
+			}
+			return nil, fmt.Errorf("failed to read the recording: %w", err)
+		}
+
+		switch event.Kind {
+		case eventHeader:
+			if event.Header == nil || event.Header.Version > recordingVersion {
+				return nil, fmt.Errorf("unsupported recording version")
+			}
+			if event.Header.PollRate <= 0 {
+				return nil, fmt.Errorf("recording has no poll rate")
+			}
+			replay.header = *event.Header
+		case eventLap:
+			replay.laps = append(replay.laps, event.Lap)
+		case eventFrame:
+			replay.frames = append(replay.frames, event)
+		case eventPlayerCar:
+			replay.players = append(replay.players, event)
+		case eventCarUpdate:
+			replay.carUpdates = append(replay.carUpdates, event)
+		}
+	}
+
+	if replay.header.Version == 0 {
+		return nil, fmt.Errorf("recording has no header")
+	}
+	return replay, nil
+}
+
+// PollRate returns the poll rate the session was recorded with
+func (r *ReplaySource) PollRate() time.Duration {
+	return r.header.PollRate
+}
+
+func (r *ReplaySource) Connect() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = true
+	return nil
+}
+
+func (r *ReplaySource) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = false
+}
+
+// Live reports true while connected and there are frames left to replay
+func (r *ReplaySource) Live() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected && r.nextFrame < len(r.frames)
+}
+
+func (r *ReplaySource) NewLap() *message.Lap {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.laps) == 0 {
+		return &message.Lap{Frames: make([]*message.Frame, 0)}
+	}
+	lap := r.laps[len(r.laps)-1]
+	if r.nextLap < len(r.laps) {
+		lap = r.laps[r.nextLap]
+		r.nextLap++
+	}
+	lap = proto.Clone(lap).(*message.Lap)
+	lap.Frames = make([]*message.Frame, 0)
+	return lap
+}
+
+func (r *ReplaySource) Frame() *message.Frame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nextFrame >= len(r.frames) {
+		return nil
+	}
+	event := r.frames[r.nextFrame]
+	r.nextFrame++
+	r.clock = event.At
+	return event.Frame
+}
+
+// advance moves the clock of the replay forward, the time goes on after the last frame
+func (r *ReplaySource) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clock += d
+}
+
+func (r *ReplaySource) PlayerCar() PlayerCar {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if event := latestEvent(r.players, r.clock); event != nil {
+		return *event.PlayerCar
+	}
+	return PlayerCar{}
+}
+
+func (r *ReplaySource) CarUpdate() *CarUpdate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if event := latestEvent(r.carUpdates, r.clock); event != nil {
+		update := *event.CarUpdate
+		return &update
+	}
+	return nil
+}
+
+// latestEvent returns the last event recorded at or before given time
+func latestEvent(events []*recordedEvent, at time.Duration) *recordedEvent {
+	var latest *recordedEvent
+	for _, event := range events {
+		if event.At > at {
+			break
+		}
+		latest = event
+	}
+	return latest
+}
+
+// Replay feeds the recorded session through the scraper at given speed, 1 is the original speed.
+// Confirmed laps are saved into outDir, they are not uploaded nor added to the library and personal bests.
+// It returns once the whole recording was replayed and all finished laps were finalized.
+func Replay(ctx context.Context, filename, outDir string, speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("replay speed must be positive, got %v", speed)
+	}
+	if outDir == "" {
+		return fmt.Errorf("replay output directory is not set")
+	}
+	// the laps in the upload directory would be uploaded as the user's driving
+	if appState, err := state.GetAppState(ctx); err == nil && sameDir(outDir, appState.UploadDir) {
+		return fmt.Errorf("replay output directory '%s' is the upload directory", outDir)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create replay output directory: %w", err)
+	}
+
+	source, err := LoadRecording(filename)
+	if err != nil {
+		return err
+	}
+
+	log := state.GetLogger(ctx)
+	log.Info("Replaying telemetry session", "filename", filename, "frames", len(source.frames), "speed", speed)
+
+	scraper := &Scraper{speed: speed, replayDir: outDir}
+	if err := source.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to the recording: %w", err)
+	}
+	defer source.Close()
+
+	// the frames are read at the rate they were recorded, so none is skipped or repeated
+	scraper.scrape(ctx, source, source.PollRate())
+	for source.Live() {
+		select {
+		case <-ctx.Done():
+			scraper.stop(ctx)
+			return ctx.Err()
+		case <-time.After(scraper.scaled(source.PollRate())):
+		}
+	}
+	scraper.stop(ctx)
+
+	// the UDP confirmation of the last lap comes after the last frame, so the clock keeps going until the lap is finalized
+	finalized := make(chan struct{})
+	go func() {
+		scraper.finalizing.Wait()
+		close(finalized)
+	}()
+	ticker := time.NewTicker(scraper.scaled(source.PollRate()))
+	defer ticker.Stop()
+	for finishing := true; finishing; {
+		select {
+		case <-finalized:
+			finishing = false
+		case <-ticker.C:
+			source.advance(source.PollRate())
+		}
+	}
+
+	log.Info("Replay finished", "filename", filename)
+	return nil
+}
+
+// sameDir says whether both paths point to the same directory
+func sameDir(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
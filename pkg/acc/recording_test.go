@@ -0,0 +1,172 @@
+package acc
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to record a synthetic session with one finished lap into the directory
+func recordSyntheticSession(t *testing.T, dir string) string {
+	frames := append(syntheticLapFrames(100, 105000), syntheticLapFrames(50, 105000)...)
+	scripted := NewScriptedSource(syntheticLap(), frames)
+	recorder := NewRecordingSource(scripted, dir, func() time.Duration { return 10 * time.Millisecond }, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+
+	assert.NoError(t, recorder.Connect())
+	recorder.NewLap()
+	for i := 0; i < 100; i++ {
+		recorder.Frame()
+	}
+	recorder.NewLap()
+	// nothing on UDP yet
+	recorder.CarUpdate()
+	recorder.PlayerCar()
+	for i := 0; i < 10; i++ {
+		recorder.Frame()
+	}
+	scripted.SetPlayerCar(PlayerCar{CarID: 7, CompletedLaps: 1, LastLapTimeMs: 105000})
+	scripted.SetCarUpdate(&CarUpdate{CarIndex: 7, Laps: 1, LastLapTimeMs: 105000, LastLapValidForBest: true})
+	recorder.PlayerCar()
+	recorder.CarUpdate()
+	for recorder.Frame() != nil {
+	}
+	recorder.Close()
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	return filepath.Join(dir, entries[0].Name())
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	filename := recordSyntheticSession(t, t.TempDir())
+
+	replay, err := LoadRecording(filename)
+	assert.NoError(t, err)
+	assert.Len(t, replay.frames, 150)
+	assert.Len(t, replay.laps, 2)
+	// unchanged values are recorded only once
+	assert.Len(t, replay.players, 2)
+	assert.Len(t, replay.carUpdates, 1)
+
+	assert.NoError(t, replay.Connect())
+	assert.Equal(t, "monza", replay.NewLap().Track)
+	assert.Nil(t, replay.CarUpdate())
+
+	for replay.Live() {
+		replay.Frame()
+	}
+	assert.Equal(t, int32(105000), replay.CarUpdate().LastLapTimeMs)
+	assert.Equal(t, int32(7), replay.PlayerCar().CarID)
+}
+
+func TestRecordingDisabled(t *testing.T) {
+	dir := t.TempDir()
+	scripted := NewScriptedSource(syntheticLap(), syntheticLapFrames(10, 105000))
+	recorder := NewRecordingSource(scripted, dir, func() time.Duration { return 10 * time.Millisecond }, slog.New(slog.NewTextHandler(io.Discard, nil)), func() bool {
+		return false
+	})
+
+	assert.NoError(t, recorder.Connect())
+	recorder.NewLap()
+	for recorder.Frame() != nil {
+	}
+	recorder.Close()
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestReplaySavesLap(t *testing.T) {
+	ctx, appState := setupTestContext(t)
+	appState.Library = library.Open(t.TempDir(), appState.UploadDir, t.TempDir(), appState.Logger)
+	var notifications []string
+	appState.Notifier = func(title, content string) {
+		notifications = append(notifications, title)
+	}
+	filename := recordSyntheticSession(t, t.TempDir())
+
+	out := t.TempDir()
+	assert.NoError(t, Replay(ctx, filename, out, 100))
+
+	// the replayed lap is not the user's driving
+	_, found := appState.Library.BestLap("monza", "ferrari_296_gt3")
+	assert.False(t, found)
+	assert.Empty(t, appState.Library.PersonalBests())
+	assert.Empty(t, notifications)
+	assert.Empty(t, savedLaps(t, appState.UploadDir))
+
+	laps := savedLaps(t, out)
+	assert.Len(t, laps, 1)
+	lap, err := lapfile.Load(filepath.Join(out, laps[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(105000), lap.LapTimeMs)
+	assert.Len(t, lap.Frames, 100)
+}
+
+func TestReplayConfirmsLapAfterLastFrame(t *testing.T) {
+	fastConfirm(t)
+	ctx, appState := setupTestContext(t)
+	dir := t.TempDir()
+	scripted := NewScriptedSource(syntheticLap(), append(syntheticLapFrames(100, 105000), syntheticLapFrames(50, 105000)[:5]...))
+	recorder := NewRecordingSource(scripted, dir, func() time.Duration { return 10 * time.Millisecond }, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+
+	assert.NoError(t, recorder.Connect())
+	recorder.NewLap()
+	for recorder.Frame() != nil {
+	}
+	recorder.PlayerCar()
+	// the session ended right after the lap, UDP confirms it later
+	time.Sleep(5 * time.Millisecond)
+	scripted.SetPlayerCar(PlayerCar{CarID: 7, CompletedLaps: 1, LastLapTimeMs: 105000})
+	scripted.SetCarUpdate(&CarUpdate{CarIndex: 7, Laps: 1, LastLapTimeMs: 105000, LastLapValidForBest: true})
+	recorder.PlayerCar()
+	recorder.CarUpdate()
+	recorder.Close()
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+
+	out := t.TempDir()
+	assert.NoError(t, Replay(ctx, filepath.Join(dir, entries[0].Name()), out, 1))
+	assert.Len(t, savedLaps(t, out), 1)
+	assert.Empty(t, savedLaps(t, appState.UploadDir))
+}
+
+func TestReplayUsesRecordedPollRate(t *testing.T) {
+	ctx, appState := setupTestContext(t)
+	// the settings poll much slower than the session was recorded
+	appState.PollRate = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	filename := recordSyntheticSession(t, t.TempDir())
+
+	out := t.TempDir()
+	assert.NoError(t, Replay(ctx, filename, out, 100))
+	assert.Len(t, savedLaps(t, out), 1)
+}
+
+func TestReplayRefusesUploadDir(t *testing.T) {
+	ctx, appState := setupTestContext(t)
+	filename := recordSyntheticSession(t, t.TempDir())
+
+	assert.Error(t, Replay(ctx, filename, appState.UploadDir, 100))
+	assert.Error(t, Replay(ctx, filename, "", 100))
+	assert.Empty(t, savedLaps(t, appState.UploadDir))
+}
+
+func TestLoadRecordingInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "broken"+RecordingSuffix)
+	assert.NoError(t, os.WriteFile(filename, []byte("not a recording"), 0644))
+
+	_, err := LoadRecording(filename)
+	assert.Error(t, err)
+}
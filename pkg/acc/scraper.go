@@ -5,54 +5,82 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/sparkoo/acctelemetry-go"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
 	"github.com/sparkoo/racemate-desktop/pkg/state"
 	message "github.com/sparkoo/racemate-msg/dist"
 )
 
+// UDP is delayed behind the shared memory, these control how long we wait for
+// the car update confirming just finished lap
+var (
+	udpDelay          = 5 * time.Second
+	udpConfirmTimeout = 10 * time.Second
+)
+
 type Scraper struct {
 	currentLap *message.Lap
 	lastFrame  *message.Frame
 
-	scraping bool
+	// stopPolling stops the goroutine reading the frames, nil while not scraping
+	stopPolling context.CancelFunc
+
+	// speed scales polling and UDP confirmation timing, 0 means real time. Used by the replay.
+	speed float64
+	// replayDir is where the replayed laps are saved, empty for the live session. The replayed session is not
+	// the user's driving, its laps are kept out of the upload, the library and personal bests.
+	replayDir string
+	// polling tracks the goroutine reading the frames
+	polling sync.WaitGroup
+	// finalizing tracks laps waiting for the UDP confirmation
+	finalizing sync.WaitGroup
+	// delta compares the current lap with the best lap
+	delta liveDelta
 }
 
-func (s *Scraper) scrape(ctx context.Context, telemetry *acctelemetry.AccTelemetry) {
-	appState, err := state.GetAppState(ctx)
-	var pollRate time.Duration
-	if err != nil {
-		slog.Error("Failed to get app state to save to the file", "error", err)
-		pollRate = 1 * time.Second
-	} else {
-		pollRate = appState.PollRate
+// scaled returns given duration adjusted to the scraper speed
+func (s *Scraper) scaled(d time.Duration) time.Duration {
+	if s.speed > 0 {
+		return time.Duration(float64(d) / s.speed)
 	}
+	return d
+}
 
-	if !s.scraping {
+// scrape starts reading the frames from the source every poll rate
+func (s *Scraper) scrape(ctx context.Context, source TelemetrySource, pollRate time.Duration) {
+	if s.stopPolling == nil {
 		log := state.GetLogger(ctx)
 		log.Info("Starting scraping the telemetry")
-		s.scraping = true
-		go func(telemetry *acctelemetry.AccTelemetry) {
-			ticker := time.NewTicker(pollRate) // main ticker for polling the telemetry data
-			s.currentLap = startNewLap(telemetry)
-			for _ = range ticker.C {
-				if !s.scraping {
-					ticker.Stop()
+		pollingCtx, stopPolling := context.WithCancel(ctx)
+		s.stopPolling = stopPolling
+		s.polling.Add(1)
+		go func(source TelemetrySource) {
+			defer s.polling.Done()
+			ticker := time.NewTicker(s.scaled(pollRate)) // main ticker for polling the telemetry data
+			defer ticker.Stop()
+			s.currentLap = source.NewLap()
+			s.delta.newLap(ctx, s.currentLap)
+			for {
+				select {
+				case <-pollingCtx.Done():
+					return
+				case <-ticker.C:
 				}
-				frame := copyToFrame(telemetry)
+				frame := source.Frame()
 				if frame != nil {
-					s.processFrame(ctx, frame, telemetry)
+					s.processFrame(ctx, frame, source)
 					s.lastFrame = frame
 				}
 			}
-		}(telemetry)
+		}(source)
 	}
 }
 
-func (s *Scraper) processFrame(ctx context.Context, frame *message.Frame, telemetry *acctelemetry.AccTelemetry) {
+func (s *Scraper) processFrame(ctx context.Context, frame *message.Frame, source TelemetrySource) {
 	// check if we're in new lap
 	if len(s.currentLap.Frames) > 0 && s.lastFrame != nil && frame.NormalizedCarPosition-s.lastFrame.NormalizedCarPosition < 0 {
 		// we care only if it is valid lap
@@ -61,7 +89,11 @@ func (s *Scraper) processFrame(ctx context.Context, frame *message.Frame, teleme
 		if s.lastFrame.IsValidLap == 1 && firstFrame.NormalizedCarPosition < 0.05 && lastFrame.NormalizedCarPosition > 0.95 {
 			justFinishedLap := s.currentLap
 			justFinishedLap.Timestamp = uint64(time.Now().Unix())
-			go s.finalizeLap(ctx, justFinishedLap, telemetry)
+			s.finalizing.Add(1)
+			go func() {
+				defer s.finalizing.Done()
+				s.finalizeLap(ctx, justFinishedLap, source)
+			}()
 		} else {
 			log := state.GetLogger(ctx)
 			log.Debug("Lap is not valid", 
@@ -69,121 +101,93 @@ func (s *Scraper) processFrame(ctx context.Context, frame *message.Frame, teleme
 				"startPosition", s.currentLap.Frames[0].NormalizedCarPosition)
 		}
 
-		s.currentLap = startNewLap(telemetry)
+		s.currentLap = source.NewLap()
+		s.delta.newLap(ctx, s.currentLap)
 	}
 	s.currentLap.Frames = append(s.currentLap.Frames, frame)
+	s.delta.update(ctx, s.currentLap, frame)
 }
 
-func (s *Scraper) finalizeLap(ctx context.Context, lap *message.Lap, telemetry *acctelemetry.AccTelemetry) {
+func (s *Scraper) finalizeLap(ctx context.Context, lap *message.Lap, source TelemetrySource) {
 	log := state.GetLogger(ctx)
 	// UDP is delayed, let's wait couple of seconds
-	time.Sleep(5 * time.Second)
+	time.Sleep(s.scaled(udpDelay))
 
-	// find car update from UDP, let's try for 5s
+	// find car update from UDP, let's try for a while
 	start := time.Now()
-	for time.Since(start) < 10*time.Second {
-		carUpdateMessage := telemetry.RealtimeCarUpdate()
+	for time.Since(start) < s.scaled(udpConfirmTimeout) {
+		carUpdateMessage := source.CarUpdate()
+		player := source.PlayerCar()
 
 		if carUpdateMessage != nil &&
-			telemetry.GraphicsPointer().PlayerCarID == int32(carUpdateMessage.CarIndex) && // we receive random cars from UDP, this checks it is our car
-			telemetry.GraphicsPointer().CompletedLaps == int32(carUpdateMessage.Laps) && // UDP is delayed by couple of seconds, we check that we're in same lap
-			telemetry.GraphicsPointer().ILastTime == carUpdateMessage.LastLap.LaptimeMs { // and we confirm that laptime matches so we're sure it's really correct lap
+			player.CarID == carUpdateMessage.CarIndex && // we receive random cars from UDP, this checks it is our car
+			player.CompletedLaps == carUpdateMessage.Laps && // UDP is delayed by couple of seconds, we check that we're in same lap
+			player.LastLapTimeMs == carUpdateMessage.LastLapTimeMs { // and we confirm that laptime matches so we're sure it's really correct lap
 
-			lap.LapTimeMs = telemetry.GraphicsPointer().ILastTime
+			lap.LapTimeMs = player.LastLapTimeMs
 			if lap.LapTimeMs < math.MaxInt32 &&
-				carUpdateMessage.LastLap.InValidForBest > 0 {
-				saveToFile(ctx, fmt.Sprintf("%s_%s_%s.%s", strconv.FormatInt(time.Now().Unix(), 10), lap.Track, lap.CarModel, "lap"), lap)
+				carUpdateMessage.LastLapValidForBest {
+				s.saveLap(ctx, lap)
 			} else {
 				log.Debug("Not valid lap", slog.String("track", lap.Track), slog.Uint64("timestamp", lap.Timestamp), slog.Int("laptime", int(lap.LapTimeMs)))
 			}
 			return
 		}
 
-		time.Sleep(50 * time.Millisecond)
+		time.Sleep(s.scaled(50 * time.Millisecond))
 	}
 	log.Debug("Could not confirm", slog.String("track", lap.Track), slog.Uint64("timestamp", lap.Timestamp), slog.Int("laptime", int(lap.LapTimeMs)))
 
 }
 
-func (s *Scraper) stop() {
-	log := state.GetLogger(context.Background())
-	log.Info("Stopping telemetry scraping")
-	s.scraping = false
-}
-
-func startNewLap(telemetry *acctelemetry.AccTelemetry) *message.Lap {
-	static := telemetry.StaticPointer()
-	physics := telemetry.PhysicsPointer()
-	graphics := telemetry.GraphicsPointer()
-	return &message.Lap{
-		SmVersion:       uint16SliceToString(static.SmVersion[:]),
-		AcVersion:       uint16SliceToString(static.AcVersion[:]),
-		CarModel:        uint16SliceToString(static.CarModel[:]),
-		Track:           uint16SliceToString(static.Track[:]),
-		PlayerName:      uint16SliceToString(static.PlayerName[:]),
-		PlayerNick:      uint16SliceToString(static.PlayerNick[:]),
-		PlayerSurname:   uint16SliceToString(static.PlayerSurname[:]),
-		AirTemp:         physics.AirTemp,
-		RoadTemp:        physics.RoadTemp,
-		SessionType:     graphics.ACSessionType,
-		RainTyres:       graphics.RainTyres,
-		IsValidLap:      graphics.IsValidLap,
-		TrackGripStatus: graphics.TrackGripStatus,
-		RainIntensity:   graphics.RainIntensity,
-		LapTimeMs:       0,
-		Frames:          make([]*message.Frame, 0),
-		LapNumber:       graphics.CompletedLaps,
-	}
-}
-
-func uint16SliceToString(arr []uint16) string {
-	str := ""
-	for _, val := range arr {
-		if val > 0 {
-			str += string(rune(val))
+// saveLap saves confirmed lap and adds it to the lap library, the replayed lap only to the replay directory
+func (s *Scraper) saveLap(ctx context.Context, lap *message.Lap) {
+	log := state.GetLogger(ctx)
+	if s.replayDir != "" {
+		if err := lapfile.Save(filepath.Join(s.replayDir, lapfile.Name(lap)), lap); err != nil {
+			log.Error("Failed to save the replayed lap", "error", err)
 		}
+		return
+	}
+	filePath, err := saveToFile(ctx, lapfile.Name(lap), lap)
+	if err != nil {
+		log.Error("Failed to save the lap", "error", err)
+		return
 	}
-	return strings.TrimSpace(str)
-}
 
-func copyToFrame(telemetry *acctelemetry.AccTelemetry) *message.Frame {
-	// static := telemetry.StaticPointer()
-	physics := telemetry.PhysicsPointer()
-	graphics := telemetry.GraphicsPointer()
-	if graphics == nil || physics == nil {
-		return nil
+	appState, err := state.GetAppState(ctx)
+	if err != nil || appState.Library == nil {
+		return
+	}
+	entry, err := appState.Library.Add(filePath, lap)
+	if err != nil {
+		log.Warn("Failed to add the lap to the library", "error", err)
 	}
 
-	// find out on what array ID is my car
-	// we have to do this for every frame as as players disconnects, my index might change
-	// it may be an issue if someone disconnects after we get the index and when we actually read the data, then we might get wrong coordinates
-	// but it may be so rare, that it will never happen
-	// let's fix once it is real issue
-	carIndex := 0
-	for _, carId := range graphics.CarID {
-		if carId == graphics.PlayerCarID {
-			break
-		}
-		carIndex++
+	previous, isPB, err := appState.Library.UpdatePersonalBest(entry, appState.Settings().PBByConditions)
+	if err != nil {
+		log.Warn("Failed to record personal best", "error", err)
+	}
+	if !isPB {
+		return
+	}
+	log.Info("New personal best", "track", lap.Track, "car", lap.CarModel, "laptime", lap.LapTimeMs)
+	// the first lap of the combination is the personal best too, but that's no news worth the notification
+	if previous != nil {
+		appState.Notify("New personal best",
+			fmt.Sprintf("%s at %s with %s (%s)", library.FormatLapTime(lap.LapTimeMs), lap.Track, lap.CarModel,
+				library.FormatLapTime(lap.LapTimeMs-previous.LapTimeMs)))
 	}
+}
 
-	return &message.Frame{
-		GraphicPacket: graphics.PacketID,
-		PhysicsPacket: physics.PacketID,
-		IsValidLap:    graphics.IsValidLap,
-		PenaltyType:   graphics.Penalty,
-
-		Gas:        physics.Gas,
-		Brake:      physics.Brake,
-		Gear:       physics.Gear,
-		Rpm:        physics.RPMs,
-		SteerAngle: physics.SteerAngle,
-		SpeedKmh:   physics.SpeedKmh,
-
-		CurrentTime:           graphics.ICurrentTime,
-		NormalizedCarPosition: graphics.NormalizedCarPosition,
-		CarCoordinateX:        graphics.CarCoordinates[carIndex][0],
-		CarCoordinateY:        graphics.CarCoordinates[carIndex][1],
-		CarCoordinateZ:        graphics.CarCoordinates[carIndex][2],
+func (s *Scraper) stop(ctx context.Context) {
+	log := state.GetLogger(ctx)
+	log.Info("Stopping telemetry scraping")
+	if s.stopPolling != nil {
+		s.stopPolling()
+		s.stopPolling = nil
 	}
+	// the frame being processed finishes before the delta is cleared
+	s.polling.Wait()
+	s.delta.clear(ctx)
 }
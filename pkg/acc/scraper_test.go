@@ -0,0 +1,203 @@
+package acc
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a context with app state writing laps to a temporary directory
+func setupTestContext(t *testing.T) (context.Context, *state.AppState) {
+	appState := &state.AppState{
+		UploadDir:    t.TempDir(),
+		UserSettings: state.UserSettings{PollRate: 10 * time.Millisecond},
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})),
+	}
+	return context.WithValue(context.Background(), state.APP_STATE, appState), appState
+}
+
+// Helper to make UDP confirmation instant
+func fastConfirm(t *testing.T) {
+	originalDelay, originalTimeout := udpDelay, udpConfirmTimeout
+	udpDelay, udpConfirmTimeout = 0, 200*time.Millisecond
+	t.Cleanup(func() {
+		udpDelay, udpConfirmTimeout = originalDelay, originalTimeout
+	})
+}
+
+// Helper to create frames of a single synthetic lap
+func syntheticLapFrames(count int, lapTimeMs int32) []*message.Frame {
+	frames := make([]*message.Frame, 0, count)
+	for i := 0; i < count; i++ {
+		position := (float32(i) + 0.5) / float32(count)
+		frames = append(frames, &message.Frame{
+			IsValidLap:            1,
+			Gear:                  3,
+			SpeedKmh:              150,
+			CurrentTime:           int32(float32(lapTimeMs) * position),
+			NormalizedCarPosition: position,
+		})
+	}
+	return frames
+}
+
+func syntheticLap() *message.Lap {
+	return &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3"}
+}
+
+func savedLaps(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	var laps []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".lap.gzip") {
+			laps = append(laps, entry.Name())
+		}
+	}
+	return laps
+}
+
+func TestProcessFrameFinalizesValidLap(t *testing.T) {
+	fastConfirm(t)
+	ctx, appState := setupTestContext(t)
+
+	source := NewScriptedSource(syntheticLap(), nil)
+	source.SetPlayerCar(PlayerCar{CarID: 7, CompletedLaps: 1, LastLapTimeMs: 105000})
+	source.SetCarUpdate(&CarUpdate{CarIndex: 7, Laps: 1, LastLapTimeMs: 105000, LastLapValidForBest: true})
+
+	appState.Library = library.Open(t.TempDir(), appState.UploadDir, t.TempDir(), appState.Logger)
+
+	scraper := &Scraper{currentLap: source.NewLap()}
+	frames := append(syntheticLapFrames(100, 105000), syntheticLapFrames(2, 105000)[0])
+	for _, frame := range frames {
+		scraper.processFrame(ctx, frame, source)
+		scraper.lastFrame = frame
+	}
+
+	// the new lap has started with the last frame
+	assert.Len(t, scraper.currentLap.Frames, 1)
+
+	assert.Eventually(t, func() bool {
+		return len(savedLaps(t, appState.UploadDir)) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	lapFile := savedLaps(t, appState.UploadDir)[0]
+	assert.Contains(t, lapFile, "_monza_ferrari_296_gt3.lap.gzip")
+
+	lap, err := lapfile.Load(filepath.Join(appState.UploadDir, lapFile))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(105000), lap.LapTimeMs)
+	assert.Len(t, lap.Frames, 100)
+
+	best, found := appState.Library.BestLap("monza", "ferrari_296_gt3")
+	assert.True(t, found)
+	assert.Equal(t, lapFile, best.File)
+	assert.Equal(t, int32(105000), best.LapTimeMs)
+}
+
+func TestSaveLapNotifiesPersonalBest(t *testing.T) {
+	ctx, appState := setupTestContext(t)
+	uploadedDir := t.TempDir()
+	appState.Library = library.Open(t.TempDir(), appState.UploadDir, uploadedDir, appState.Logger)
+	var notifications []string
+	appState.Notifier = func(title, content string) {
+		notifications = append(notifications, title+": "+content)
+	}
+
+	previousFile := filepath.Join(uploadedDir, "1740000000_monza_ferrari_296_gt3"+lapfile.SUFFIX)
+	previous := &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 105312}
+	assert.NoError(t, lapfile.Save(previousFile, previous))
+	entry, err := appState.Library.Add(previousFile, previous)
+	assert.NoError(t, err)
+	_, _, err = appState.Library.UpdatePersonalBest(entry, false)
+	assert.NoError(t, err)
+
+	scraper := &Scraper{}
+	scraper.saveLap(ctx, &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 105000})
+
+	assert.Equal(t, []string{"New personal best: 1:45.000 at monza with ferrari_296_gt3 (-0:00.312)"}, notifications)
+	pbs := appState.Library.PersonalBests()
+	assert.Len(t, pbs, 1)
+	assert.Equal(t, int32(105000), pbs[0].LapTimeMs)
+}
+
+func TestProcessFrameSkipsIncompleteLap(t *testing.T) {
+	fastConfirm(t)
+	ctx, appState := setupTestContext(t)
+
+	source := NewScriptedSource(syntheticLap(), nil)
+	scraper := &Scraper{currentLap: source.NewLap()}
+
+	// join the session in the middle of the lap
+	frames := syntheticLapFrames(100, 105000)[50:]
+	frames = append(frames, syntheticLapFrames(2, 105000)[0])
+	for _, frame := range frames {
+		scraper.processFrame(ctx, frame, source)
+		scraper.lastFrame = frame
+	}
+
+	assert.Len(t, scraper.currentLap.Frames, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, savedLaps(t, appState.UploadDir))
+}
+
+func TestFinalizeLapNotConfirmed(t *testing.T) {
+	fastConfirm(t)
+	ctx, appState := setupTestContext(t)
+
+	source := NewScriptedSource(syntheticLap(), nil)
+	source.SetPlayerCar(PlayerCar{CarID: 7, CompletedLaps: 1, LastLapTimeMs: 105000})
+	// update of some other car
+	source.SetCarUpdate(&CarUpdate{CarIndex: 3, Laps: 1, LastLapTimeMs: 105000, LastLapValidForBest: true})
+
+	lap := source.NewLap()
+	lap.Frames = syntheticLapFrames(100, 105000)
+	(&Scraper{}).finalizeLap(ctx, lap, source)
+
+	assert.Empty(t, savedLaps(t, appState.UploadDir))
+}
+
+func TestFinalizeLapInvalidForBest(t *testing.T) {
+	fastConfirm(t)
+	ctx, appState := setupTestContext(t)
+
+	source := NewScriptedSource(syntheticLap(), nil)
+	source.SetPlayerCar(PlayerCar{CarID: 7, CompletedLaps: 1, LastLapTimeMs: 105000})
+	source.SetCarUpdate(&CarUpdate{CarIndex: 7, Laps: 1, LastLapTimeMs: 105000, LastLapValidForBest: false})
+
+	lap := source.NewLap()
+	lap.Frames = syntheticLapFrames(100, 105000)
+	(&Scraper{}).finalizeLap(ctx, lap, source)
+
+	assert.Empty(t, savedLaps(t, appState.UploadDir))
+}
+
+func TestScriptedSource(t *testing.T) {
+	frames := syntheticLapFrames(3, 90000)
+	source := NewScriptedSource(syntheticLap(), frames)
+
+	assert.False(t, source.Live())
+	assert.NoError(t, source.Connect())
+	assert.True(t, source.Live())
+
+	for _, frame := range frames {
+		assert.Equal(t, frame, source.Frame())
+	}
+	assert.Nil(t, source.Frame())
+	assert.False(t, source.Live())
+
+	lap := source.NewLap()
+	assert.Equal(t, "monza", lap.Track)
+	assert.Empty(t, lap.Frames)
+}
@@ -0,0 +1,40 @@
+package acc
+
+import (
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// TelemetrySource is where the Scraper reads the telemetry from.
+// The live backend reads ACC shared memory and UDP broadcasting, other backends
+// may serve recorded or simulated data so the lap logic can run without the game.
+type TelemetrySource interface {
+	// Connect opens the source. It is called repeatedly until it succeeds.
+	Connect() error
+	// Close releases the source.
+	Close()
+	// Live reports whether there is a running session we should scrape.
+	Live() bool
+	// NewLap returns lap metadata (track, car, conditions) for a lap starting now.
+	NewLap() *message.Lap
+	// Frame returns the current frame or nil when there are no data available.
+	Frame() *message.Frame
+	// PlayerCar returns the player's car state as seen by the shared memory.
+	PlayerCar() PlayerCar
+	// CarUpdate returns the last realtime car update received over UDP or nil.
+	CarUpdate() *CarUpdate
+}
+
+// PlayerCar is the player's car state used to confirm just finished lap
+type PlayerCar struct {
+	CarID         int32
+	CompletedLaps int32
+	LastLapTimeMs int32
+}
+
+// CarUpdate is a realtime car update received from the UDP broadcasting API
+type CarUpdate struct {
+	CarIndex            int32
+	Laps                int32
+	LastLapTimeMs       int32
+	LastLapValidForBest bool
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package acc
+
+import (
+	"fmt"
+	"runtime"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// unsupportedSource is used where ACC shared memory is not available
+type unsupportedSource struct{}
+
+// NewAccSource creates a new ACC shared memory telemetry source. ACC runs only
+// on Windows, so here it never connects.
+func NewAccSource() TelemetrySource {
+	return &unsupportedSource{}
+}
+
+func (u *unsupportedSource) Connect() error {
+	return fmt.Errorf("ACC shared memory is not available on %s", runtime.GOOS)
+}
+
+func (u *unsupportedSource) Close() {}
+
+func (u *unsupportedSource) Live() bool { return false }
+
+func (u *unsupportedSource) NewLap() *message.Lap { return &message.Lap{} }
+
+func (u *unsupportedSource) Frame() *message.Frame { return nil }
+
+func (u *unsupportedSource) PlayerCar() PlayerCar { return PlayerCar{} }
+
+func (u *unsupportedSource) CarUpdate() *CarUpdate { return nil }
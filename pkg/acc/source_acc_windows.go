@@ -0,0 +1,130 @@
+package acc
+
+import (
+	"strings"
+
+	"github.com/sparkoo/acctelemetry-go"
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// AccSource reads the telemetry from running ACC via shared memory and UDP
+type AccSource struct {
+	telemetry *acctelemetry.AccTelemetry
+}
+
+// NewAccSource creates a new ACC shared memory telemetry source
+func NewAccSource() TelemetrySource {
+	return &AccSource{telemetry: acctelemetry.New(acctelemetry.DefaultUdpConfig())}
+}
+
+func (a *AccSource) Connect() error {
+	return a.telemetry.Connect()
+}
+
+func (a *AccSource) Close() {
+	a.telemetry.Close()
+}
+
+func (a *AccSource) Live() bool {
+	graphics := a.telemetry.GraphicsPointer()
+	return graphics != nil && graphics.ACStatus == 2
+}
+
+func (a *AccSource) NewLap() *message.Lap {
+	static := a.telemetry.StaticPointer()
+	physics := a.telemetry.PhysicsPointer()
+	graphics := a.telemetry.GraphicsPointer()
+	return &message.Lap{
+		SmVersion:       uint16SliceToString(static.SmVersion[:]),
+		AcVersion:       uint16SliceToString(static.AcVersion[:]),
+		CarModel:        uint16SliceToString(static.CarModel[:]),
+		Track:           uint16SliceToString(static.Track[:]),
+		PlayerName:      uint16SliceToString(static.PlayerName[:]),
+		PlayerNick:      uint16SliceToString(static.PlayerNick[:]),
+		PlayerSurname:   uint16SliceToString(static.PlayerSurname[:]),
+		AirTemp:         physics.AirTemp,
+		RoadTemp:        physics.RoadTemp,
+		SessionType:     graphics.ACSessionType,
+		RainTyres:       graphics.RainTyres,
+		IsValidLap:      graphics.IsValidLap,
+		TrackGripStatus: graphics.TrackGripStatus,
+		RainIntensity:   graphics.RainIntensity,
+		LapTimeMs:       0,
+		Frames:          make([]*message.Frame, 0),
+		LapNumber:       graphics.CompletedLaps,
+	}
+}
+
+func (a *AccSource) Frame() *message.Frame {
+	// static := telemetry.StaticPointer()
+	physics := a.telemetry.PhysicsPointer()
+	graphics := a.telemetry.GraphicsPointer()
+	if graphics == nil || physics == nil {
+		return nil
+	}
+
+	// find out on what array ID is my car
+	// we have to do this for every frame as as players disconnects, my index might change
+	// it may be an issue if someone disconnects after we get the index and when we actually read the data, then we might get wrong coordinates
+	// but it may be so rare, that it will never happen
+	// let's fix once it is real issue
+	carIndex := 0
+	for _, carId := range graphics.CarID {
+		if carId == graphics.PlayerCarID {
+			break
+		}
+		carIndex++
+	}
+
+	return &message.Frame{
+		GraphicPacket: graphics.PacketID,
+		PhysicsPacket: physics.PacketID,
+		IsValidLap:    graphics.IsValidLap,
+		PenaltyType:   graphics.Penalty,
+
+		Gas:        physics.Gas,
+		Brake:      physics.Brake,
+		Gear:       physics.Gear,
+		Rpm:        physics.RPMs,
+		SteerAngle: physics.SteerAngle,
+		SpeedKmh:   physics.SpeedKmh,
+
+		CurrentTime:           graphics.ICurrentTime,
+		NormalizedCarPosition: graphics.NormalizedCarPosition,
+		CarCoordinateX:        graphics.CarCoordinates[carIndex][0],
+		CarCoordinateY:        graphics.CarCoordinates[carIndex][1],
+		CarCoordinateZ:        graphics.CarCoordinates[carIndex][2],
+	}
+}
+
+func (a *AccSource) PlayerCar() PlayerCar {
+	graphics := a.telemetry.GraphicsPointer()
+	return PlayerCar{
+		CarID:         graphics.PlayerCarID,
+		CompletedLaps: graphics.CompletedLaps,
+		LastLapTimeMs: graphics.ILastTime,
+	}
+}
+
+func (a *AccSource) CarUpdate() *CarUpdate {
+	carUpdateMessage := a.telemetry.RealtimeCarUpdate()
+	if carUpdateMessage == nil {
+		return nil
+	}
+	return &CarUpdate{
+		CarIndex:            int32(carUpdateMessage.CarIndex),
+		Laps:                int32(carUpdateMessage.Laps),
+		LastLapTimeMs:       carUpdateMessage.LastLap.LaptimeMs,
+		LastLapValidForBest: carUpdateMessage.LastLap.InValidForBest > 0,
+	}
+}
+
+func uint16SliceToString(arr []uint16) string {
+	str := ""
+	for _, val := range arr {
+		if val > 0 {
+			str += string(rune(val))
+		}
+	}
+	return strings.TrimSpace(str)
+}
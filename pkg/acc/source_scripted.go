@@ -0,0 +1,96 @@
+package acc
+
+import (
+	"sync"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"google.golang.org/protobuf/proto"
+)
+
+// ScriptedSource is an in-memory telemetry source replaying prepared frames.
+// It is used to drive the scraper with synthetic laps, e.g. in tests.
+type ScriptedSource struct {
+	mu sync.Mutex
+
+	lap       *message.Lap
+	frames    []*message.Frame
+	next      int
+	player    PlayerCar
+	carUpdate *CarUpdate
+	connected bool
+}
+
+// NewScriptedSource creates a source that returns given frames one by one.
+// The lap is used as a template for every NewLap call.
+func NewScriptedSource(lap *message.Lap, frames []*message.Frame) *ScriptedSource {
+	return &ScriptedSource{lap: lap, frames: frames}
+}
+
+func (s *ScriptedSource) Connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	return nil
+}
+
+func (s *ScriptedSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+}
+
+// Live reports true while connected and there are frames left to replay
+func (s *ScriptedSource) Live() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected && s.next < len(s.frames)
+}
+
+func (s *ScriptedSource) NewLap() *message.Lap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lap := &message.Lap{}
+	if s.lap != nil {
+		lap = proto.Clone(s.lap).(*message.Lap)
+	}
+	lap.Frames = make([]*message.Frame, 0)
+	return lap
+}
+
+// Frame returns the next scripted frame or nil once all frames were served
+func (s *ScriptedSource) Frame() *message.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next >= len(s.frames) {
+		return nil
+	}
+	frame := s.frames[s.next]
+	s.next++
+	return frame
+}
+
+func (s *ScriptedSource) PlayerCar() PlayerCar {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.player
+}
+
+func (s *ScriptedSource) CarUpdate() *CarUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.carUpdate
+}
+
+// SetPlayerCar sets the player's car state returned by PlayerCar
+func (s *ScriptedSource) SetPlayerCar(player PlayerCar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.player = player
+}
+
+// SetCarUpdate sets the UDP car update returned by CarUpdate
+func (s *ScriptedSource) SetCarUpdate(update *CarUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.carUpdate = update
+}
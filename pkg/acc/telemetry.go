@@ -5,17 +5,26 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/sparkoo/acctelemetry-go"
 	"github.com/sparkoo/racemate-desktop/pkg/state"
 )
 
-type TelemetryState struct {
-	telemetry *acctelemetry.AccTelemetry
+// TelemetryLoop watches for running ACC session and scrapes it
+func TelemetryLoop(ctx context.Context) {
+	source := NewAccSource()
+	if appState, err := state.GetAppState(ctx); err == nil {
+		pollRate := func() time.Duration {
+			return appState.Settings().PollRate
+		}
+		source = NewRecordingSource(source, appState.RecordingsDir, pollRate, appState.Logger, func() bool {
+			return appState.Settings().RecordTelemetry
+		})
+	}
+	SourceLoop(ctx, source)
 }
 
-func TelemetryLoop(ctx context.Context) {
+// SourceLoop watches given telemetry source for a live session and scrapes it
+func SourceLoop(ctx context.Context, source TelemetrySource) {
 	log := state.GetLogger(ctx)
-	telemetry := &TelemetryState{telemetry: acctelemetry.New(acctelemetry.DefaultUdpConfig())}
 	scraper := &Scraper{}
 	appState, err := state.GetAppState(ctx)
 	if err != nil {
@@ -23,19 +32,32 @@ func TelemetryLoop(ctx context.Context) {
 	}
 
 	// this loop is checking whether we have running ACC session
-	for range time.NewTicker(10 * time.Second).C {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// let just finished lap to be saved
+			scraper.stop(ctx)
+			scraper.finalizing.Wait()
+			source.Close()
+			return
+		case <-ticker.C:
+		}
+
 		if appState.TelemetryOnline {
-			if telemetry.telemetry.GraphicsPointer() != nil && telemetry.telemetry.GraphicsPointer().ACStatus != 2 {
+			if !source.Live() {
 				appState.TelemetryOnline = false
 			}
 		} else {
-			if connectionErr := telemetry.telemetry.Connect(); connectionErr == nil {
-				if telemetry.telemetry.GraphicsPointer().ACStatus == 2 {
+			if connectionErr := source.Connect(); connectionErr == nil {
+				if source.Live() {
 					appState.TelemetryOnline = true
-					scraper.scrape(ctx, telemetry.telemetry)
+					// the poll rate changed by the user applies from the next session
+					scraper.scrape(ctx, source, appState.Settings().PollRate)
 				} else {
-					telemetry.telemetry.Close()
-					scraper.stop()
+					source.Close()
+					scraper.stop(ctx)
 				}
 			} else {
 				log.Error("failed to connect, trying again...", slog.Any("err", connectionErr))
@@ -0,0 +1,223 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// Alignment says how the frames of two laps are matched to each other
+type Alignment int
+
+const (
+	// ByPosition aligns the frames by the normalized car position reported by the game
+	ByPosition Alignment = iota
+	// ByDistance aligns the frames by the distance driven, computed from the car coordinates and normalized
+	// by the lap length so the laps driven on different lines still match at the line
+	ByDistance
+)
+
+// Sample holds the channels of the lap at some point, interpolated between the frames
+type Sample struct {
+	TimeMs     float64
+	SpeedKmh   float32
+	Gas        float32
+	Brake      float32
+	SteerAngle float32
+	Gear       int32
+}
+
+// Trace is the lap prepared for looking up the channels at any point of the lap
+type Trace struct {
+	LapTimeMs int32
+	// progress is the position or distance fraction of every frame, always increasing
+	progress []float64
+	frames   []*message.Frame
+}
+
+// NewTrace prepares the lap for the lookups. Frames before the start line and frames after the car was
+// reset back are skipped.
+func NewTrace(lap *message.Lap, alignment Alignment) (*Trace, error) {
+	frames := forwardFrames(lap.Frames)
+	if len(frames) < 2 {
+		return nil, fmt.Errorf("lap has not enough frames")
+	}
+
+	var progress []float64
+	switch alignment {
+	case ByPosition:
+		progress = make([]float64, 0, len(frames))
+		for _, frame := range frames {
+			progress = append(progress, float64(frame.NormalizedCarPosition))
+		}
+	case ByDistance:
+		progress = distanceFractions(frames)
+		if progress == nil {
+			return nil, fmt.Errorf("lap has no car coordinates")
+		}
+	default:
+		return nil, fmt.Errorf("unknown alignment %d", alignment)
+	}
+
+	trace := &Trace{LapTimeMs: lap.LapTimeMs}
+	// distance can stay the same when the car is stopped, only the first frame at the point is kept
+	for i, p := range progress {
+		if len(trace.progress) > 0 && p <= trace.progress[len(trace.progress)-1] {
+			continue
+		}
+		trace.progress = append(trace.progress, p)
+		trace.frames = append(trace.frames, frames[i])
+	}
+	return trace, nil
+}
+
+// Start returns the progress of the first frame
+func (t *Trace) Start() float64 {
+	return t.progress[0]
+}
+
+// End returns the progress of the last frame
+func (t *Trace) End() float64 {
+	return t.progress[len(t.progress)-1]
+}
+
+// At returns the channels at the progress, interpolated between the frames around it.
+// Progress outside of the trace gives the first or the last frame.
+func (t *Trace) At(progress float64) Sample {
+	i := sort.SearchFloat64s(t.progress, progress)
+	if i == 0 {
+		return sampleOf(t.frames[0])
+	}
+	if i == len(t.progress) {
+		return sampleOf(t.frames[len(t.frames)-1])
+	}
+
+	from, to := t.frames[i-1], t.frames[i]
+	ratio := (progress - t.progress[i-1]) / (t.progress[i] - t.progress[i-1])
+	return Sample{
+		TimeMs:     lerp(float64(from.CurrentTime), float64(to.CurrentTime), ratio),
+		SpeedKmh:   float32(lerp(float64(from.SpeedKmh), float64(to.SpeedKmh), ratio)),
+		Gas:        float32(lerp(float64(from.Gas), float64(to.Gas), ratio)),
+		Brake:      float32(lerp(float64(from.Brake), float64(to.Brake), ratio)),
+		SteerAngle: float32(lerp(float64(from.SteerAngle), float64(to.SteerAngle), ratio)),
+		// gear can't be interpolated, it is the gear engaged before the point
+		Gear: from.Gear,
+	}
+}
+
+// DeltaPoint compares the laps at one point of the lap. Differences are lap minus reference, so positive
+// Delta means the lap is slower than the reference there.
+type DeltaPoint struct {
+	Progress  float64
+	Lap       Sample
+	Reference Sample
+	DeltaMs   int32
+	Speed     float32
+	Gas       float32
+	Brake     float32
+	Gear      int32
+	Steering  float32
+}
+
+// Delta is the comparison of the lap with the reference lap at every frame of the lap
+type Delta struct {
+	Points []DeltaPoint
+	// FinalDeltaMs is the difference of the lap times
+	FinalDeltaMs int32
+}
+
+// ComputeDelta compares the lap with the reference lap, e.g. the current lap with the personal best
+func ComputeDelta(lap, reference *message.Lap, alignment Alignment) (*Delta, error) {
+	lapTrace, err := NewTrace(lap, alignment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the lap: %w", err)
+	}
+	referenceTrace, err := NewTrace(reference, alignment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the reference lap: %w", err)
+	}
+
+	delta := &Delta{
+		Points:       make([]DeltaPoint, 0, len(lapTrace.progress)),
+		FinalDeltaMs: lap.LapTimeMs - reference.LapTimeMs,
+	}
+	for i, progress := range lapTrace.progress {
+		// the reference has no frames to compare with outside of its range
+		if progress < referenceTrace.Start() || progress > referenceTrace.End() {
+			continue
+		}
+		lapSample := sampleOf(lapTrace.frames[i])
+		referenceSample := referenceTrace.At(progress)
+		delta.Points = append(delta.Points, DeltaPoint{
+			Progress:  progress,
+			Lap:       lapSample,
+			Reference: referenceSample,
+			DeltaMs:   int32(math.Round(lapSample.TimeMs - referenceSample.TimeMs)),
+			Speed:     lapSample.SpeedKmh - referenceSample.SpeedKmh,
+			Gas:       lapSample.Gas - referenceSample.Gas,
+			Brake:     lapSample.Brake - referenceSample.Brake,
+			Gear:      lapSample.Gear - referenceSample.Gear,
+			Steering:  lapSample.SteerAngle - referenceSample.SteerAngle,
+		})
+	}
+	if len(delta.Points) == 0 {
+		return nil, fmt.Errorf("laps don't overlap")
+	}
+	return delta, nil
+}
+
+// forwardFrames returns the frames where the car moves forward from the start line
+func forwardFrames(frames []*message.Frame) []*message.Frame {
+	forward := make([]*message.Frame, 0, len(frames))
+	for _, frame := range frames {
+		if len(forward) > 0 && frame.NormalizedCarPosition <= forward[len(forward)-1].NormalizedCarPosition {
+			continue
+		}
+		// the car can be still before the line for the first frames
+		if len(forward) == 0 && frame.NormalizedCarPosition > 0.5 {
+			continue
+		}
+		forward = append(forward, frame)
+	}
+	return forward
+}
+
+// distanceFractions returns the distance driven at every frame as the fraction of the whole distance
+func distanceFractions(frames []*message.Frame) []float64 {
+	distances := make([]float64, len(frames))
+	for i := 1; i < len(frames); i++ {
+		dx := float64(frames[i].CarCoordinateX - frames[i-1].CarCoordinateX)
+		dy := float64(frames[i].CarCoordinateY - frames[i-1].CarCoordinateY)
+		dz := float64(frames[i].CarCoordinateZ - frames[i-1].CarCoordinateZ)
+		distances[i] = distances[i-1] + math.Sqrt(dx*dx+dy*dy+dz*dz)
+	}
+	total := distances[len(distances)-1]
+	if total == 0 {
+		return nil
+	}
+
+	// the first frame is not exactly at the line, the fractions are shifted by its position
+	start := float64(frames[0].NormalizedCarPosition)
+	end := float64(frames[len(frames)-1].NormalizedCarPosition)
+	for i := range distances {
+		distances[i] = start + distances[i]/total*(end-start)
+	}
+	return distances
+}
+
+func sampleOf(frame *message.Frame) Sample {
+	return Sample{
+		TimeMs:     float64(frame.CurrentTime),
+		SpeedKmh:   frame.SpeedKmh,
+		Gas:        frame.Gas,
+		Brake:      frame.Brake,
+		SteerAngle: frame.SteerAngle,
+		Gear:       frame.Gear,
+	}
+}
+
+func lerp(from, to, ratio float64) float64 {
+	return from + (to-from)*ratio
+}
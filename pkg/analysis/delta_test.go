@@ -0,0 +1,123 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a lap around the circle of 1km at constant speed. Slow part of the lap up to slowUntil
+// is driven at the half speed.
+func circleLap(count int, speedKmh float32, slowUntil float32) *message.Lap {
+	const radius = 1000 / (2 * math.Pi)
+	lap := &message.Lap{}
+	currentTime := float64(0)
+	step := 1000.0 / float64(count) // meters
+	for i := 0; i <= count; i++ {
+		position := float32(i) / float32(count)
+		speed := speedKmh
+		if position < slowUntil {
+			speed /= 2
+		}
+		angle := 2 * math.Pi * float64(position)
+		lap.Frames = append(lap.Frames, &message.Frame{
+			NormalizedCarPosition: position,
+			CurrentTime:           int32(math.Round(currentTime)),
+			SpeedKmh:              speed,
+			Gas:                   1,
+			Gear:                  4,
+			CarCoordinateX:        float32(radius * math.Cos(angle)),
+			CarCoordinateZ:        float32(radius * math.Sin(angle)),
+		})
+		currentTime += step / float64(speed/3.6) * 1000
+	}
+	lap.LapTimeMs = lap.Frames[len(lap.Frames)-1].CurrentTime
+	return lap
+}
+
+func TestComputeDeltaSameLap(t *testing.T) {
+	lap := circleLap(100, 100, 0)
+
+	for _, alignment := range []Alignment{ByPosition, ByDistance} {
+		delta, err := ComputeDelta(lap, lap, alignment)
+		assert.NoError(t, err)
+		assert.Len(t, delta.Points, 101)
+		assert.Zero(t, delta.FinalDeltaMs)
+		for _, point := range delta.Points {
+			assert.Zero(t, point.DeltaMs)
+			assert.Zero(t, point.Speed)
+		}
+	}
+}
+
+func TestComputeDeltaSlowerStart(t *testing.T) {
+	// 100m driven at the half speed takes 3.6s instead of 1.8s
+	lap := circleLap(100, 200, 0.1)
+	reference := circleLap(100, 200, 0)
+
+	for _, alignment := range []Alignment{ByPosition, ByDistance} {
+		delta, err := ComputeDelta(lap, reference, alignment)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1800), delta.FinalDeltaMs)
+
+		halfway := delta.Points[5]
+		assert.InDelta(t, 0.05, halfway.Progress, 0.001)
+		assert.InDelta(t, 900, halfway.DeltaMs, 2)
+		assert.Equal(t, float32(-100), halfway.Speed)
+		assert.Zero(t, halfway.Gear)
+
+		last := delta.Points[len(delta.Points)-1]
+		assert.InDelta(t, 1800, last.DeltaMs, 2)
+		assert.Zero(t, last.Speed)
+	}
+}
+
+func TestComputeDeltaInterpolatesReference(t *testing.T) {
+	lap := circleLap(100, 100, 0)
+	// reference with fewer frames is interpolated at the frames of the lap
+	reference := circleLap(10, 100, 0)
+
+	delta, err := ComputeDelta(lap, reference, ByPosition)
+	assert.NoError(t, err)
+	assert.Len(t, delta.Points, 101)
+	for _, point := range delta.Points {
+		assert.InDelta(t, 0, point.DeltaMs, 1)
+	}
+}
+
+func TestComputeDeltaSkipsFramesBeforeLine(t *testing.T) {
+	lap := circleLap(100, 100, 0)
+	lap.Frames = append([]*message.Frame{{NormalizedCarPosition: 0.999, CurrentTime: 0}}, lap.Frames...)
+
+	delta, err := ComputeDelta(lap, circleLap(100, 100, 0), ByPosition)
+	assert.NoError(t, err)
+	assert.Len(t, delta.Points, 101)
+}
+
+func TestComputeDeltaInvalidLaps(t *testing.T) {
+	_, err := ComputeDelta(&message.Lap{}, circleLap(10, 100, 0), ByPosition)
+	assert.Error(t, err)
+
+	// no coordinates
+	lap := constantSpeedLap(10, 90000)
+	_, err = ComputeDelta(lap, lap, ByDistance)
+	assert.Error(t, err)
+}
+
+func TestTraceAt(t *testing.T) {
+	trace, err := NewTrace(&message.Lap{Frames: []*message.Frame{
+		{NormalizedCarPosition: 0.1, CurrentTime: 1000, SpeedKmh: 100, Gear: 3},
+		{NormalizedCarPosition: 0.2, CurrentTime: 2000, SpeedKmh: 200, Gear: 4},
+	}}, ByPosition)
+	assert.NoError(t, err)
+
+	sample := trace.At(0.125)
+	assert.InDelta(t, 1250, sample.TimeMs, 0.001)
+	assert.InDelta(t, 125, sample.SpeedKmh, 0.001)
+	assert.Equal(t, int32(3), sample.Gear)
+
+	assert.Equal(t, float64(1000), trace.At(0).TimeMs)
+	assert.Equal(t, float64(2000), trace.At(1).TimeMs)
+}
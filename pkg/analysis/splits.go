@@ -0,0 +1,162 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// DEFAULT_MINI_SECTORS is the number of mini-sectors when the definitions don't say otherwise
+const DEFAULT_MINI_SECTORS = 10
+
+// SectorDefinitions holds the sector boundaries of the tracks as normalized car positions.
+// Track with 3 sectors has 2 boundaries, the start/finish line is not listed.
+type SectorDefinitions struct {
+	MiniSectors int                  `json:"miniSectors"`
+	Tracks      map[string][]float32 `json:"tracks"`
+}
+
+// DefaultSectorDefinitions are used when there is no definitions file. No track is defined so all the tracks
+// are split into equal thirds.
+func DefaultSectorDefinitions() *SectorDefinitions {
+	return &SectorDefinitions{MiniSectors: DEFAULT_MINI_SECTORS, Tracks: map[string][]float32{}}
+}
+
+// LoadSectorDefinitions reads the definitions from the JSON file over the default ones. Missing file gives
+// the default definitions.
+func LoadSectorDefinitions(filename string) (*SectorDefinitions, error) {
+	definitions := DefaultSectorDefinitions()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return definitions, nil
+		}
+		return nil, fmt.Errorf("failed to read sector definitions: %w", err)
+	}
+	file := SectorDefinitions{MiniSectors: definitions.MiniSectors}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse sector definitions: %w", err)
+	}
+
+	if file.MiniSectors < 1 {
+		return nil, fmt.Errorf("number of mini-sectors must be positive, got %d", file.MiniSectors)
+	}
+	definitions.MiniSectors = file.MiniSectors
+	for track, boundaries := range file.Tracks {
+		if err := validateBoundaries(boundaries); err != nil {
+			return nil, fmt.Errorf("invalid sectors of track '%s': %w", track, err)
+		}
+		definitions.Tracks[strings.ToLower(track)] = boundaries
+	}
+	return definitions, nil
+}
+
+// Sectors returns the sector boundaries of the track, tracks without definition are split into equal thirds
+func (d *SectorDefinitions) Sectors(track string) []float32 {
+	if boundaries, ok := d.Tracks[strings.ToLower(track)]; ok {
+		return boundaries
+	}
+	return EqualSectors(3)
+}
+
+// Fingerprint identifies the definitions so that splits computed with different definitions are not mixed
+func (d *SectorDefinitions) Fingerprint() string {
+	data, _ := json.Marshal(d)
+	return string(data)
+}
+
+// EqualSectors returns the boundaries splitting the lap into count sectors of the same length
+func EqualSectors(count int) []float32 {
+	boundaries := make([]float32, 0, count-1)
+	for i := 1; i < count; i++ {
+		boundaries = append(boundaries, float32(i)/float32(count))
+	}
+	return boundaries
+}
+
+func validateBoundaries(boundaries []float32) error {
+	previous := float32(0)
+	for _, boundary := range boundaries {
+		if boundary <= previous || boundary >= 1 {
+			return fmt.Errorf("boundaries must be increasing between 0 and 1, got %v", boundaries)
+		}
+		previous = boundary
+	}
+	return nil
+}
+
+// Splits computes the time spent in every sector of the lap in ms. Sectors are given by the boundaries,
+// the crossing time of each boundary is interpolated between the frames around it. The last sector ends
+// with the lap time.
+func Splits(lap *message.Lap, boundaries []float32) ([]int32, error) {
+	if err := validateBoundaries(boundaries); err != nil {
+		return nil, err
+	}
+	if lap.LapTimeMs <= 0 {
+		return nil, fmt.Errorf("lap has no lap time")
+	}
+
+	crossings := make([]float64, 0, len(boundaries)+2)
+	crossings = append(crossings, 0)
+	next := 0
+	var previous *message.Frame
+	for _, frame := range lap.Frames {
+		// the car can be still before the line for the first frames, and positions jump when the car is reset,
+		// only the frames moving forward are used
+		if previous != nil && frame.NormalizedCarPosition > previous.NormalizedCarPosition {
+			for next < len(boundaries) && frame.NormalizedCarPosition >= boundaries[next] {
+				if previous.NormalizedCarPosition > boundaries[next] {
+					// the boundary was skipped by position jump, can't tell when it was crossed
+					return nil, fmt.Errorf("no frames around the sector boundary %.3f", boundaries[next])
+				}
+				crossings = append(crossings, interpolate(previous, frame, boundaries[next]))
+				next++
+			}
+		}
+		previous = frame
+	}
+	if next < len(boundaries) {
+		return nil, fmt.Errorf("lap doesn't reach the sector boundary %.3f", boundaries[next])
+	}
+	crossings = append(crossings, float64(lap.LapTimeMs))
+
+	splits := make([]int32, 0, len(boundaries)+1)
+	for i := 1; i < len(crossings); i++ {
+		splits = append(splits, int32(crossings[i]+0.5)-int32(crossings[i-1]+0.5))
+	}
+	return splits, nil
+}
+
+// interpolate returns the time when the car was at the position between the two frames
+func interpolate(from, to *message.Frame, position float32) float64 {
+	ratio := float64(position-from.NormalizedCarPosition) / float64(to.NormalizedCarPosition-from.NormalizedCarPosition)
+	return float64(from.CurrentTime) + ratio*float64(to.CurrentTime-from.CurrentTime)
+}
+
+// TheoreticalBest sums the best time of every sector. Splits with different number of sectors than the first
+// are ignored.
+func TheoreticalBest(splits [][]int32) (int32, []int32) {
+	if len(splits) == 0 {
+		return 0, nil
+	}
+	best := append([]int32{}, splits[0]...)
+	for _, lapSplits := range splits[1:] {
+		if len(lapSplits) != len(best) {
+			continue
+		}
+		for i, split := range lapSplits {
+			if split < best[i] {
+				best[i] = split
+			}
+		}
+	}
+
+	total := int32(0)
+	for _, split := range best {
+		total += split
+	}
+	return total, best
+}
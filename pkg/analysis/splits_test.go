@@ -0,0 +1,138 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a lap driven at constant speed with frames every 1/count of the lap
+func constantSpeedLap(count int, lapTimeMs int32) *message.Lap {
+	lap := &message.Lap{LapTimeMs: lapTimeMs}
+	for i := 0; i < count; i++ {
+		position := float32(i) / float32(count)
+		lap.Frames = append(lap.Frames, &message.Frame{
+			NormalizedCarPosition: position,
+			CurrentTime:           int32(float32(lapTimeMs) * position),
+		})
+	}
+	return lap
+}
+
+func TestSplitsInterpolatesCrossing(t *testing.T) {
+	lap := &message.Lap{
+		LapTimeMs: 100000,
+		Frames: []*message.Frame{
+			{NormalizedCarPosition: 0.99, CurrentTime: 0}, // still before the line
+			{NormalizedCarPosition: 0.01, CurrentTime: 1000},
+			{NormalizedCarPosition: 0.40, CurrentTime: 40000},
+			{NormalizedCarPosition: 0.60, CurrentTime: 50000}, // boundary 0.5 crossed at 45000
+			{NormalizedCarPosition: 0.99, CurrentTime: 99000},
+		},
+	}
+
+	splits, err := Splits(lap, []float32{0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{45000, 55000}, splits)
+}
+
+func TestSplitsEqualSectors(t *testing.T) {
+	lap := constantSpeedLap(100, 90000)
+
+	splits, err := Splits(lap, EqualSectors(3))
+	assert.NoError(t, err)
+	assert.Len(t, splits, 3)
+	assert.InDelta(t, 30000, splits[0], 1)
+	assert.InDelta(t, 30000, splits[1], 1)
+	// splits always add up to the lap time
+	assert.Equal(t, int32(90000), splits[0]+splits[1]+splits[2])
+
+	miniSectors, err := Splits(lap, EqualSectors(10))
+	assert.NoError(t, err)
+	assert.Len(t, miniSectors, 10)
+}
+
+func TestSplitsIncompleteLap(t *testing.T) {
+	lap := constantSpeedLap(100, 90000)
+	lap.Frames = lap.Frames[:50]
+
+	_, err := Splits(lap, EqualSectors(3))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "0.667")
+}
+
+func TestSplitsPositionJump(t *testing.T) {
+	lap := &message.Lap{
+		LapTimeMs: 100000,
+		Frames: []*message.Frame{
+			{NormalizedCarPosition: 0.01, CurrentTime: 1000},
+			{NormalizedCarPosition: 0.2, CurrentTime: 20000},
+			{NormalizedCarPosition: 0.1, CurrentTime: 21000}, // car was reset back
+			{NormalizedCarPosition: 0.9, CurrentTime: 90000},
+		},
+	}
+
+	_, err := Splits(lap, []float32{0.5})
+	assert.NoError(t, err)
+
+	_, err = Splits(lap, []float32{0.05, 0.95})
+	assert.Error(t, err)
+}
+
+func TestSplitsInvalidInput(t *testing.T) {
+	_, err := Splits(&message.Lap{}, EqualSectors(3))
+	assert.Error(t, err)
+
+	_, err = Splits(constantSpeedLap(10, 90000), []float32{0.6, 0.3})
+	assert.Error(t, err)
+}
+
+func TestTheoreticalBest(t *testing.T) {
+	total, best := TheoreticalBest([][]int32{
+		{30000, 31000, 29000},
+		{29500, 31500, 29200},
+		{30000, 30500}, // different definitions, ignored
+	})
+	assert.Equal(t, []int32{29500, 31000, 29000}, best)
+	assert.Equal(t, int32(89500), total)
+
+	total, best = TheoreticalBest(nil)
+	assert.Zero(t, total)
+	assert.Nil(t, best)
+}
+
+func TestLoadSectorDefinitions(t *testing.T) {
+	dir := t.TempDir()
+
+	definitions, err := LoadSectorDefinitions(filepath.Join(dir, "missing.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_MINI_SECTORS, definitions.MiniSectors)
+	assert.Equal(t, DefaultSectorDefinitions(), definitions)
+
+	file := filepath.Join(dir, "sectors.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"miniSectors": 20, "tracks": {"monza": [0.25, 0.7]}}`), 0644))
+	definitions, err = LoadSectorDefinitions(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, definitions.MiniSectors)
+	assert.Equal(t, []float32{0.25, 0.7}, definitions.Sectors("Monza"))
+	assert.Equal(t, EqualSectors(3), definitions.Sectors("spa"))
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"tracks": {"Monza": [0.25, 0.7]}}`), 0644))
+	definitions, err = LoadSectorDefinitions(file)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_MINI_SECTORS, definitions.MiniSectors)
+	assert.Equal(t, []float32{0.25, 0.7}, definitions.Sectors("monza"))
+
+	splits, err := Splits(constantSpeedLap(1000, 100000), definitions.Sectors("monza"))
+	assert.NoError(t, err)
+	assert.Len(t, splits, 3)
+	assert.InDelta(t, 25000, splits[0], 1)
+	assert.InDelta(t, 45000, splits[1], 1)
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"tracks": {"monza": [0.7, 0.25]}}`), 0644))
+	_, err = LoadSectorDefinitions(file)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,10 +21,20 @@ type UserData struct {
 	Email         string    `json:"email"`
 	DisplayName   string    `json:"displayName"`
 	PhotoURL      string    `json:"photoURL"`
-	IDToken       string    `json:"idToken"`
-	RefreshToken  string    `json:"refreshToken"`
+	IDToken       string    `json:"idToken,omitempty"`
+	RefreshToken  string    `json:"refreshToken,omitempty"`
 	ExpiresAt     time.Time `json:"expiresAt"`
 	LastLoginTime time.Time `json:"lastLoginTime"`
+	// Issuer and ClientID are set for the users logged in with OpenID Connect provider instead of Firebase
+	Issuer   string `json:"issuer,omitempty"`
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// userFile is the content of the user data file. The tokens are kept in the secret store named by Secrets,
+// the files of the older versions have them right in the file.
+type userFile struct {
+	UserData
+	Secrets string `json:"secrets,omitempty"`
 }
 
 // AuthManager handles authentication state persistence
@@ -39,7 +50,7 @@ func NewAuthManager(appState *state.AppState) *AuthManager {
 	}
 }
 
-// SaveUserData persists user authentication data to disk
+// SaveUserData persists user authentication data to disk, the tokens go into the secret store
 func (am *AuthManager) SaveUserData(userData *UserData) error {
 	// Set last login time
 	userData.LastLoginTime = time.Now()
@@ -47,28 +58,41 @@ func (am *AuthManager) SaveUserData(userData *UserData) error {
 	// Store user data in memory
 	am.userData = userData
 
+	return am.writeUserData(userData)
+}
+
+// writeUserData puts the tokens into the secret store and writes the rest of the user data to the file
+func (am *AuthManager) writeUserData(userData *UserData) error {
 	// Create auth directory if it doesn't exist
 	authDir := filepath.Join(am.appState.DataDir, "auth")
 	if err := os.MkdirAll(authDir, 0700); err != nil {
 		return fmt.Errorf("failed to create auth directory: %w", err)
 	}
 
+	store, err := am.saveTokens(userData)
+	if err != nil {
+		return err
+	}
+
 	// Write user data to file
-	userDataFile := filepath.Join(authDir, "user.json")
-	data, err := json.MarshalIndent(userData, "", "  ")
+	content := userFile{UserData: *userData, Secrets: store.Name()}
+	content.IDToken = ""
+	content.RefreshToken = ""
+	data, err := json.MarshalIndent(content, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal user data: %w", err)
 	}
 
 	// Write with restrictive permissions (only user can read/write)
-	if err := os.WriteFile(userDataFile, data, 0600); err != nil {
+	if err := os.WriteFile(am.userDataFile(), data, 0600); err != nil {
 		return fmt.Errorf("failed to write user data file: %w", err)
 	}
 
 	return nil
 }
 
-// LoadUserData loads user authentication data from disk
+// LoadUserData loads user authentication data from disk. The tokens found in the file written by the older
+// version are moved into the secret store.
 func (am *AuthManager) LoadUserData() (*UserData, error) {
 	// If already loaded in memory, return it
 	if am.userData != nil {
@@ -76,8 +100,42 @@ func (am *AuthManager) LoadUserData() (*UserData, error) {
 	}
 
 	// Try to load from file
-	userDataFile := filepath.Join(am.appState.DataDir, "auth", "user.json")
-	data, err := os.ReadFile(userDataFile)
+	content, err := am.readUserFile()
+	if err != nil || content == nil {
+		return nil, err
+	}
+	userData := &content.UserData
+
+	switch {
+	case userData.IDToken != "" || userData.RefreshToken != "":
+		if err := am.writeUserData(userData); err != nil {
+			am.appState.Logger.Warn("Failed to move the credentials out of the user data file", "error", err)
+		} else {
+			am.appState.Logger.Info("Moved the credentials out of the user data file", "uid", userData.UID)
+		}
+	case content.Secrets != "":
+		store, err := secretStoreByName(am.appState, content.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		if err := loadTokens(store, userData); err != nil {
+			if errors.Is(err, ErrSecretNotFound) {
+				// the credentials were removed from the store, the user logs in again
+				am.appState.Logger.Warn("Credentials not found in the store", "store", content.Secrets)
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to load credentials: %w", err)
+		}
+	}
+
+	// Store in memory for future use
+	am.userData = userData
+	return userData, nil
+}
+
+// readUserFile reads the user data file, nil when there's none
+func (am *AuthManager) readUserFile() (*userFile, error) {
+	data, err := os.ReadFile(am.userDataFile())
 	if err != nil {
 		if os.IsNotExist(err) {
 			// No user data file exists (not an error, just not logged in)
@@ -87,14 +145,15 @@ func (am *AuthManager) LoadUserData() (*UserData, error) {
 	}
 
 	// Parse the user data
-	userData := &UserData{}
-	if err := json.Unmarshal(data, userData); err != nil {
+	content := &userFile{}
+	if err := json.Unmarshal(data, content); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal user data: %w", err)
 	}
+	return content, nil
+}
 
-	// Store in memory for future use
-	am.userData = userData
-	return userData, nil
+func (am *AuthManager) userDataFile() string {
+	return filepath.Join(am.appState.DataDir, "auth", "user.json")
 }
 
 // IsLoggedIn checks if the user is logged in with valid credentials
@@ -130,9 +189,17 @@ func (am *AuthManager) Logout() error {
 	// Clear memory
 	am.userData = nil
 
+	// Remove the credentials, the user is logged out even when the store fails
+	if content, err := am.readUserFile(); err == nil && content != nil && content.Secrets != "" {
+		if store, err := secretStoreByName(am.appState, content.Secrets); err == nil {
+			if err := deleteTokens(store); err != nil {
+				am.appState.Logger.Warn("Failed to remove the credentials", "error", err)
+			}
+		}
+	}
+
 	// Remove file
-	userDataFile := filepath.Join(am.appState.DataDir, "auth", "user.json")
-	if err := os.Remove(userDataFile); err != nil {
+	if err := os.Remove(am.userDataFile()); err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("failed to remove user data file: %w", err)
 		}
@@ -155,6 +222,9 @@ func (am *AuthManager) RefreshIDToken() error {
 	if err != nil || userData == nil {
 		return fmt.Errorf("no user data available to refresh token")
 	}
+	if userData.Issuer != "" {
+		return am.refreshOAuthToken(userData)
+	}
 
 	// Get Firebase API key from environment
 	apiKey := os.Getenv("FIREBASE_API_KEY")
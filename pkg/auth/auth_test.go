@@ -39,6 +39,8 @@ func setupTestAuthManager(t *testing.T) (*AuthManager, string, func()) {
 		DataDir: tempDir,
 		// Initialize a test logger that won't output anything
 		Logger: slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})),
+		// Keep the tokens away from the keyring of the machine running the tests
+		UserSettings: state.UserSettings{CredentialStore: SECRET_STORE_FILE},
 	}
 
 	// Create auth manager
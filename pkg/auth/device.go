@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DEVICE_CODE_GRANT_TYPE is the grant type of the device authorization grant (RFC 8628)
+const DEVICE_CODE_GRANT_TYPE = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DEFAULT_DEVICE_POLL_INTERVAL is used when the provider doesn't say how often to poll, in seconds
+const DEFAULT_DEVICE_POLL_INTERVAL = 5
+
+// devicePollUnit is the unit of the poll intervals, the seconds of the RFC
+var devicePollUnit = time.Second
+
+// DeviceCode is what the user needs to log in on another device
+type DeviceCode struct {
+	UserCode        string
+	VerificationURI string
+	// VerificationURIComplete has the user code in it, e.g. for the QR code. Can be empty.
+	VerificationURIComplete string
+	ExpiresAt               time.Time
+}
+
+// deviceAuthorizationResponse is the response of the device authorization endpoint
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// LoginWithDeviceCode logs the user in with the device authorization grant (RFC 8628), for the machines where
+// the browser is not at hand. The code is passed to show, the user enters it on another device, meanwhile the
+// token endpoint is polled. The user is saved on success.
+func (am *AuthManager) LoginWithDeviceCode(ctx context.Context, config OAuthConfig, show func(DeviceCode)) (*UserData, error) {
+	metadata, err := discover(config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("login provider doesn't support the device login")
+	}
+
+	authorization, err := requestDeviceCode(metadata.DeviceAuthorizationEndpoint, config)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+	show(DeviceCode{
+		UserCode:                authorization.UserCode,
+		VerificationURI:         authorization.VerificationURI,
+		VerificationURIComplete: authorization.VerificationURIComplete,
+		ExpiresAt:               expiresAt,
+	})
+
+	interval := authorization.Interval
+	if interval <= 0 {
+		interval = DEFAULT_DEVICE_POLL_INTERVAL
+	}
+	ctx, cancel := context.WithDeadline(ctx, expiresAt)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("login was not completed: %w", ctx.Err())
+		case <-time.After(time.Duration(interval) * devicePollUnit):
+		}
+
+		tokens, err := requestTokens(metadata.TokenEndpoint, url.Values{
+			"grant_type":  {DEVICE_CODE_GRANT_TYPE},
+			"device_code": {authorization.DeviceCode},
+			"client_id":   {config.ClientID},
+		})
+		var oauthErr *OAuthError
+		switch {
+		case err == nil:
+			return am.saveOAuthUser(metadata, config.ClientID, tokens, "")
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+			continue
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			// the provider asks to wait 5 seconds longer between the polls from now on
+			interval += 5
+			am.appState.Logger.Debug("Login provider asked to slow down", "interval", interval)
+		case errors.As(err, &oauthErr) && oauthErr.Code == "access_denied":
+			return nil, fmt.Errorf("login was refused")
+		case errors.As(err, &oauthErr) && oauthErr.Code == "expired_token":
+			return nil, fmt.Errorf("login code expired, log in again")
+		default:
+			return nil, err
+		}
+	}
+}
+
+// requestDeviceCode starts the device login
+func requestDeviceCode(endpoint string, config OAuthConfig) (*deviceAuthorizationResponse, error) {
+	form := url.Values{"client_id": {config.ClientID}, "scope": {strings.Join(scopes(config), " ")}}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device authorization request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send device authorization request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, tokenError(resp)
+	}
+
+	authorization := &deviceAuthorizationResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(authorization); err != nil {
+		return nil, fmt.Errorf("failed to decode device authorization response: %w", err)
+	}
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization response is incomplete")
+	}
+	if authorization.ExpiresIn <= 0 {
+		authorization.ExpiresIn = int(OAUTH_LOGIN_TIMEOUT / time.Second)
+	}
+	return authorization, nil
+}
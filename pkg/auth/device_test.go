@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to poll every millisecond instead of every second
+func setDevicePollUnit(t *testing.T) {
+	original := devicePollUnit
+	devicePollUnit = time.Millisecond
+	t.Cleanup(func() { devicePollUnit = original })
+}
+
+func TestLoginWithDeviceCode(t *testing.T) {
+	setDevicePollUnit(t)
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	server.pending = 2
+
+	var shown DeviceCode
+	user, err := authManager.LoginWithDeviceCode(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID},
+		func(code DeviceCode) { shown = code })
+	assert.NoError(t, err)
+	assert.Equal(t, "WDJB-MJHT", shown.UserCode)
+	assert.Equal(t, server.URL+"/activate", shown.VerificationURI)
+	assert.Equal(t, server.URL+"/activate?user_code=WDJB-MJHT", shown.VerificationURIComplete)
+	assert.Len(t, server.polls, 3)
+
+	assert.Equal(t, "oidc-user-1", user.UID)
+	saved, err := NewAuthManager(authManager.appState).GetCurrentUser()
+	assert.NoError(t, err)
+	assert.Equal(t, user.IDToken, saved.IDToken)
+	assert.Equal(t, server.URL, saved.Issuer)
+}
+
+func TestLoginWithDeviceCodeSlowDown(t *testing.T) {
+	setDevicePollUnit(t)
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	server.slowDowns = 2
+
+	_, err := authManager.LoginWithDeviceCode(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID},
+		func(DeviceCode) {})
+	assert.NoError(t, err)
+	assert.Len(t, server.polls, 3)
+	// the interval of 1 grows by 5 with every slow_down
+	assert.GreaterOrEqual(t, server.polls[1].Sub(server.polls[0]), 6*time.Millisecond)
+	assert.GreaterOrEqual(t, server.polls[2].Sub(server.polls[1]), 11*time.Millisecond)
+}
+
+func TestLoginWithDeviceCodeDenied(t *testing.T) {
+	setDevicePollUnit(t)
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	server.pending = 1
+	server.denied = true
+
+	_, err := authManager.LoginWithDeviceCode(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID},
+		func(DeviceCode) {})
+	assert.ErrorContains(t, err, "refused")
+	assert.False(t, authManager.IsLoggedIn())
+}
+
+func TestLoginWithDeviceCodeNotCompleted(t *testing.T) {
+	setDevicePollUnit(t)
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	server.pending = 1 << 20
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := authManager.LoginWithDeviceCode(ctx, OAuthConfig{Issuer: server.URL, ClientID: testClientID}, func(DeviceCode) {})
+	assert.ErrorContains(t, err, "not completed")
+	assert.False(t, authManager.IsLoggedIn())
+}
+
+func TestLoginWithDeviceCodeNotSupported(t *testing.T) {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer := "http://" + r.Host
+		json.NewEncoder(w).Encode(providerMetadata{Issuer: issuer, AuthorizationEndpoint: issuer + "/authorize", TokenEndpoint: issuer + "/token"})
+	}))
+	defer server.Close()
+
+	_, err := authManager.LoginWithDeviceCode(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID}, func(DeviceCode) {
+		t.Error("no code without the device login")
+	})
+	assert.ErrorContains(t, err, "doesn't support the device login")
+}
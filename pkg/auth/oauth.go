@@ -0,0 +1,368 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAUTH_LOGIN_TIMEOUT is how long the login waits for the user in the browser
+const OAUTH_LOGIN_TIMEOUT = 5 * time.Minute
+
+// OAUTH_CALLBACK_PATH is the path of the loopback redirect URI
+const OAUTH_CALLBACK_PATH = "/callback"
+
+// DEFAULT_OAUTH_SCOPES are requested when the config doesn't set any
+var DEFAULT_OAUTH_SCOPES = []string{"openid", "email", "profile", "offline_access"}
+
+// OAuthConfig is the OpenID Connect provider the user logs in with
+type OAuthConfig struct {
+	// Issuer is the URL of the provider, its metadata are at Issuer/.well-known/openid-configuration
+	Issuer   string
+	ClientID string
+	Scopes   []string
+}
+
+// providerMetadata are the endpoints of the provider
+type providerMetadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// tokenResponse is the response of the token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// oidcClaims are the claims of the ID token the user data are taken from
+type oidcClaims struct {
+	Issuer   string          `json:"iss"`
+	Audience json.RawMessage `json:"aud"`
+	Subject  string          `json:"sub"`
+	Expires  int64           `json:"exp"`
+	Nonce    string          `json:"nonce"`
+	Email    string          `json:"email"`
+	Name     string          `json:"name"`
+	Picture  string          `json:"picture"`
+}
+
+// LoginWithOAuth logs the user in with the OAuth 2.0 authorization code flow with PKCE (RFC 7636). The browser
+// is redirected back to the loopback server on a random free port (RFC 8252). The user is saved on success.
+func (am *AuthManager) LoginWithOAuth(ctx context.Context, config OAuthConfig, openURL func(*url.URL) error) (*UserData, error) {
+	metadata, err := discover(config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// only this computer can deliver the code
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start login callback server: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s%s", listener.Addr().String(), OAUTH_CALLBACK_PATH)
+
+	codes := make(chan callbackResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(OAUTH_CALLBACK_PATH, func(w http.ResponseWriter, r *http.Request) {
+		// the requests not answering this login can't cancel it
+		if r.URL.Query().Get("state") != state {
+			http.Error(w, "Unknown login", http.StatusBadRequest)
+			return
+		}
+		result := parseCallback(r.URL.Query(), state)
+		if result.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<html><body><h1>Login failed</h1><p>%s</p></body></html>", html.EscapeString(result.err.Error()))
+		} else {
+			fmt.Fprint(w, "<html><body><h1>Login successful</h1><p>You can close this window.</p></body></html>")
+		}
+		select {
+		case codes <- result:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", config.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(scopes(config), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	if err := openURL(authURL); err != nil {
+		am.appState.Logger.Warn("Failed to open the browser", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, OAUTH_LOGIN_TIMEOUT)
+	defer cancel()
+	var result callbackResult
+	select {
+	case result = <-codes:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("login was not completed: %w", ctx.Err())
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	tokens, err := requestTokens(metadata.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {config.ClientID},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return am.saveOAuthUser(metadata, config.ClientID, tokens, nonce)
+}
+
+// callbackResult is the code the provider redirected the browser with
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// parseCallback checks the redirect is the answer to this login
+func parseCallback(query url.Values, state string) callbackResult {
+	if query.Get("state") != state {
+		return callbackResult{err: fmt.Errorf("login response doesn't match the login request")}
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		return callbackResult{err: fmt.Errorf("login was refused: %s %s", errorCode, query.Get("error_description"))}
+	}
+	if query.Get("code") == "" {
+		return callbackResult{err: fmt.Errorf("login response has no code")}
+	}
+	return callbackResult{code: query.Get("code")}
+}
+
+// saveOAuthUser checks the ID token issued by the token endpoint and saves the user. The token came right from
+// the provider over TLS, so its claims are trusted without checking the signature (OpenID Connect Core 3.1.3.7).
+func (am *AuthManager) saveOAuthUser(metadata *providerMetadata, clientID string, tokens *tokenResponse, nonce string) (*UserData, error) {
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no ID token, is openid scope allowed?")
+	}
+	parts := strings.Split(tokens.IDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	claims := &oidcClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("%w: broken claims: %v", ErrInvalidToken, err)
+	}
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return nil, fmt.Errorf("%w: issued by '%s'", ErrInvalidToken, claims.Issuer)
+	case !audienceContains(claims.Audience, clientID):
+		return nil, fmt.Errorf("%w: issued for another client", ErrInvalidToken)
+	case nonce != "" && claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce doesn't match", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	expiresAt := time.Unix(claims.Expires, 0)
+	if tokens.ExpiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	}
+	displayName := claims.Name
+	if displayName == "" {
+		displayName = claims.Email
+	}
+	userData := &UserData{
+		UID:          claims.Subject,
+		Email:        claims.Email,
+		DisplayName:  displayName,
+		PhotoURL:     claims.Picture,
+		IDToken:      tokens.IDToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    expiresAt,
+		Issuer:       metadata.Issuer,
+		ClientID:     clientID,
+	}
+	if err := am.SaveUserData(userData); err != nil {
+		return nil, err
+	}
+	am.appState.Logger.Info("User logged in", "uid", userData.UID, "issuer", userData.Issuer)
+	return userData, nil
+}
+
+// refreshOAuthToken refreshes the ID token at the token endpoint of the provider the user logged in with
+func (am *AuthManager) refreshOAuthToken(userData *UserData) error {
+	if userData.RefreshToken == "" {
+		return fmt.Errorf("no refresh token, log in again")
+	}
+	metadata, err := discover(userData.Issuer)
+	if err != nil {
+		return err
+	}
+	tokens, err := requestTokens(metadata.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {userData.RefreshToken},
+		"client_id":     {userData.ClientID},
+	})
+	if err != nil {
+		return err
+	}
+	if tokens.IDToken == "" {
+		return fmt.Errorf("refresh response has no ID token")
+	}
+
+	userData.IDToken = tokens.IDToken
+	if tokens.RefreshToken != "" {
+		userData.RefreshToken = tokens.RefreshToken
+	}
+	expiresIn := tokens.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 3600
+	}
+	userData.ExpiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	if err := am.SaveUserData(userData); err != nil {
+		return fmt.Errorf("failed to save refreshed user data: %w", err)
+	}
+	am.appState.Logger.Info("Token successfully refreshed", "uid", userData.UID)
+	return nil
+}
+
+// discover reads the metadata of the provider
+func discover(issuer string) (*providerMetadata, error) {
+	if issuer == "" {
+		return nil, fmt.Errorf("OpenID Connect issuer is not configured")
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the login provider: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("discovery request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	metadata := &providerMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode provider metadata: %w", err)
+	}
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("provider metadata are of the issuer '%s', expected '%s'", metadata.Issuer, issuer)
+	}
+	return metadata, nil
+}
+
+// requestTokens posts the grant to the token endpoint
+func requestTokens(tokenEndpoint string, form url.Values) (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send token request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, tokenError(resp)
+	}
+
+	tokens := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	return tokens, nil
+}
+
+// OAuthError is the error response of the token endpoint (RFC 6749 5.2)
+type OAuthError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("token request failed with status %d: %s %s", e.StatusCode, e.Code, e.Description)
+}
+
+func tokenError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	oauthErr := &OAuthError{StatusCode: resp.StatusCode}
+	if err := json.Unmarshal(body, oauthErr); err != nil || oauthErr.Code == "" {
+		oauthErr.Description = string(body)
+	}
+	return oauthErr
+}
+
+// audienceContains checks the aud claim, it is either the string or the array
+func audienceContains(audience json.RawMessage, clientID string) bool {
+	var single string
+	if json.Unmarshal(audience, &single) == nil {
+		return single == clientID
+	}
+	var multiple []string
+	return json.Unmarshal(audience, &multiple) == nil && slices.Contains(multiple, clientID)
+}
+
+func scopes(config OAuthConfig) []string {
+	if len(config.Scopes) > 0 {
+		return config.Scopes
+	}
+	return DEFAULT_OAUTH_SCOPES
+}
+
+// randomToken generates the PKCE verifier, state and nonce, 43 characters of base64url
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testClientID = "racemate-desktop"
+
+// oidcServer is the stand-in for the authorization server of the OpenID Connect provider
+type oidcServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// denied makes the user refuse the login
+	denied bool
+	// nonce replaces the nonce of the login in the ID token
+	nonce     string
+	codes     map[string]authorization
+	refreshes int
+	// pending is how many more polls of the device login get authorization_pending, slowDowns get slow_down first
+	pending   int
+	slowDowns int
+	polls     []time.Time
+}
+
+// authorization is the code issued for the login
+type authorization struct {
+	challenge   string
+	redirectURI string
+	nonce       string
+}
+
+// Helper to create the provider stand-in
+func setupOIDCServer(t *testing.T) *oidcServer {
+	server := &oidcServer{codes: map[string]authorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerMetadata{
+			Issuer:                server.URL,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+
+			DeviceAuthorizationEndpoint: server.URL + "/device",
+		})
+	})
+	mux.HandleFunc("/authorize", server.authorize)
+	mux.HandleFunc("/token", server.token)
+	mux.HandleFunc("/device", server.deviceAuthorization)
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// authorize logs the user in right away and redirects back with the code
+func (s *oidcServer) authorize(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	answer := url.Values{"state": {query.Get("state")}}
+	switch {
+	case s.denied:
+		answer.Set("error", "access_denied")
+	case query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" || redirect.Hostname() != "127.0.0.1":
+		answer.Set("error", "invalid_request")
+	default:
+		code := "code-" + query.Get("state")[:8]
+		s.codes[code] = authorization{challenge: query.Get("code_challenge"), redirectURI: query.Get("redirect_uri"), nonce: query.Get("nonce")}
+		answer.Set("code", code)
+	}
+	redirect.RawQuery = answer.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *oidcServer) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ParseForm()
+	nonce := ""
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		login, ok := s.codes[r.Form.Get("code")]
+		delete(s.codes, r.Form.Get("code"))
+		verifierHash := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || login.redirectURI != r.Form.Get("redirect_uri") || login.challenge != base64.RawURLEncoding.EncodeToString(verifierHash[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(OAuthError{Code: "invalid_grant", Description: "code or verifier doesn't match"})
+			return
+		}
+		nonce = login.nonce
+		if s.nonce != "" {
+			nonce = s.nonce
+		}
+	case "refresh_token":
+		if r.Form.Get("refresh_token") != "refresh-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(OAuthError{Code: "invalid_grant"})
+			return
+		}
+		s.refreshes++
+	case DEVICE_CODE_GRANT_TYPE:
+		s.polls = append(s.polls, time.Now())
+		code := ""
+		switch {
+		case r.Form.Get("device_code") != "device-code" || r.Form.Get("client_id") != testClientID:
+			code = "invalid_grant"
+		case s.slowDowns > 0:
+			s.slowDowns--
+			code = "slow_down"
+		case s.pending > 0:
+			s.pending--
+			code = "authorization_pending"
+		case s.denied:
+			code = "access_denied"
+		}
+		if code != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(OAuthError{Code: code})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(OAuthError{Code: "unsupported_grant_type"})
+		return
+	}
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  "access-token",
+		IDToken:      s.idToken(nonce),
+		RefreshToken: "refresh-token",
+		ExpiresIn:    3600,
+		TokenType:    "Bearer",
+	})
+}
+
+// deviceAuthorization starts the device login, polling every 1 unit
+func (s *oidcServer) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("client_id") != testClientID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(OAuthError{Code: "invalid_client"})
+		return
+	}
+	json.NewEncoder(w).Encode(deviceAuthorizationResponse{
+		DeviceCode:              "device-code",
+		UserCode:                "WDJB-MJHT",
+		VerificationURI:         s.URL + "/activate",
+		VerificationURIComplete: s.URL + "/activate?user_code=WDJB-MJHT",
+		ExpiresIn:               60,
+		Interval:                1,
+	})
+}
+
+func (s *oidcServer) idToken(nonce string) string {
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   s.URL,
+		"aud":   []string{testClientID},
+		"sub":   "oidc-user-1",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+		"email": "driver@example.com",
+		"name":  "Test Driver",
+	})
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(claims) + ".c2lnbmF0dXJl"
+}
+
+// Helper to act as the browser following the redirects
+func openInBrowser(u *url.URL) error {
+	go func() {
+		if resp, err := http.Get(u.String()); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	return nil
+}
+
+func TestLoginWithOAuth(t *testing.T) {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+
+	user, err := authManager.LoginWithOAuth(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID}, openInBrowser)
+	assert.NoError(t, err)
+	assert.Equal(t, "oidc-user-1", user.UID)
+	assert.Equal(t, "Test Driver", user.DisplayName)
+	assert.Equal(t, "driver@example.com", user.Email)
+	assert.Equal(t, server.URL, user.Issuer)
+	assert.Equal(t, "refresh-token", user.RefreshToken)
+
+	// the user is saved
+	saved, err := NewAuthManager(authManager.appState).GetCurrentUser()
+	assert.NoError(t, err)
+	assert.Equal(t, user.IDToken, saved.IDToken)
+	assert.Equal(t, testClientID, saved.ClientID)
+}
+
+func TestLoginWithOAuthDenied(t *testing.T) {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	server.denied = true
+
+	_, err := authManager.LoginWithOAuth(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID}, openInBrowser)
+	assert.ErrorContains(t, err, "access_denied")
+	assert.False(t, authManager.IsLoggedIn())
+}
+
+func TestLoginWithOAuthWrongNonce(t *testing.T) {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	server.nonce = "replayed"
+
+	_, err := authManager.LoginWithOAuth(context.Background(), OAuthConfig{Issuer: server.URL, ClientID: testClientID}, openInBrowser)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.False(t, authManager.IsLoggedIn())
+}
+
+func TestLoginWithOAuthNotCompleted(t *testing.T) {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var loginURL *url.URL
+	_, err := authManager.LoginWithOAuth(ctx, OAuthConfig{Issuer: server.URL, ClientID: testClientID}, func(u *url.URL) error {
+		loginURL = u
+		return nil
+	})
+	assert.ErrorContains(t, err, "not completed")
+	assert.True(t, strings.HasPrefix(loginURL.String(), server.URL+"/authorize?"))
+	assert.Equal(t, strings.Join(DEFAULT_OAUTH_SCOPES, " "), loginURL.Query().Get("scope"))
+}
+
+func TestRefreshOAuthToken(t *testing.T) {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	server := setupOIDCServer(t)
+	assert.NoError(t, authManager.SaveUserData(&UserData{
+		UID:          "oidc-user-1",
+		IDToken:      "expired",
+		RefreshToken: "refresh-token",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+		Issuer:       server.URL,
+		ClientID:     testClientID,
+	}))
+
+	assert.True(t, authManager.IsLoggedIn())
+	assert.Equal(t, 1, server.refreshes)
+	user, err := authManager.GetCurrentUser()
+	assert.NoError(t, err)
+	assert.NotEqual(t, "expired", user.IDToken)
+	assert.True(t, user.ExpiresAt.After(time.Now()))
+}
+
+func TestParseCallback(t *testing.T) {
+	assert.Equal(t, "abc", parseCallback(url.Values{"state": {"s"}, "code": {"abc"}}, "s").code)
+	assert.ErrorContains(t, parseCallback(url.Values{"state": {"other"}, "code": {"abc"}}, "s").err, "doesn't match")
+	assert.ErrorContains(t, parseCallback(url.Values{"state": {"s"}, "error": {"access_denied"}}, "s").err, "access_denied")
+	assert.ErrorContains(t, parseCallback(url.Values{"state": {"s"}}, "s").err, "no code")
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// SECRET_STORE_KEYRING keeps the tokens in the keyring of the OS, Windows Credential Manager, macOS Keychain
+// or the Secret Service (secret-tool) elsewhere
+const SECRET_STORE_KEYRING = "keyring"
+
+// SECRET_STORE_FILE keeps the tokens in the file encrypted with the key bound to this machine
+const SECRET_STORE_FILE = "file"
+
+// SECRETS_FILE is the name of the encrypted file in the auth directory
+const SECRETS_FILE = "secrets.enc"
+
+// KEYRING_SERVICE is the service name of the keyring entries
+const KEYRING_SERVICE = "RaceMate Desktop"
+
+// names of the secrets in the store
+const (
+	secretIDToken      = "idToken"
+	secretRefreshToken = "refreshToken"
+)
+
+// ErrSecretNotFound is returned for the secret that is not in the store
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore keeps the tokens of the user out of the plain user data file
+type SecretStore interface {
+	// Name is stored in the user data file, so the tokens are loaded from the same store
+	Name() string
+	// Get returns the secret, ErrSecretNotFound when there's none
+	Get(name string) (string, error)
+	Set(name, secret string) error
+	// Delete removes the secret, the missing one is not an error
+	Delete(name string) error
+}
+
+// keyringAvailable checks once whether the keyring of the OS can be used
+var keyringAvailable = sync.OnceValue(probeKeyring)
+
+// keyringStore keeps the secrets in the keyring of the OS. The entries are scoped by the data directory, so the
+// apps with different data directories don't share the login.
+type keyringStore struct {
+	service string
+	account string
+}
+
+func newKeyringStore(dataDir string) *keyringStore {
+	return &keyringStore{service: KEYRING_SERVICE, account: dataDir}
+}
+
+func (k *keyringStore) Name() string {
+	return SECRET_STORE_KEYRING
+}
+
+// secretStore returns the store for the new secrets, the keyring when available unless the settings say otherwise
+func secretStore(appState *state.AppState) SecretStore {
+	switch appState.Settings().CredentialStore {
+	case SECRET_STORE_FILE:
+		return fileStore(appState)
+	case SECRET_STORE_KEYRING:
+		return newKeyringStore(appState.DataDir)
+	}
+	if keyringAvailable() {
+		return newKeyringStore(appState.DataDir)
+	}
+	return fileStore(appState)
+}
+
+// secretStoreByName returns the store the secrets were saved to
+func secretStoreByName(appState *state.AppState, name string) (SecretStore, error) {
+	switch name {
+	case SECRET_STORE_FILE:
+		return fileStore(appState), nil
+	case SECRET_STORE_KEYRING:
+		return newKeyringStore(appState.DataDir), nil
+	}
+	return nil, fmt.Errorf("unknown credential store '%s'", name)
+}
+
+func fileStore(appState *state.AppState) SecretStore {
+	return newEncryptedFileStore(filepath.Join(appState.DataDir, "auth", SECRETS_FILE), machineID, appState.Logger)
+}
+
+// saveSecrets puts the secrets into the secret store and returns the store, the empty secret is removed. The
+// encrypted file is used when the keyring refuses them, e.g. the keyring is locked or the secret is too big for it.
+func saveSecrets(appState *state.AppState, secrets map[string]string) (SecretStore, error) {
+	store := secretStore(appState)
+	err := setSecrets(store, secrets)
+	if err != nil && store.Name() == SECRET_STORE_KEYRING && appState.Settings().CredentialStore != SECRET_STORE_KEYRING {
+		appState.Logger.Warn("Failed to save the credentials into the keyring, using the encrypted file", "error", err)
+		deleteSecrets(store, secrets)
+		store = fileStore(appState)
+		err = setSecrets(store, secrets)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save credentials: %w", err)
+	}
+	return store, nil
+}
+
+func setSecrets(store SecretStore, secrets map[string]string) error {
+	for name, secret := range secrets {
+		if secret == "" {
+			if err := store.Delete(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := store.Set(name, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteSecrets(store SecretStore, secrets map[string]string) error {
+	var errs []error
+	for name := range secrets {
+		errs = append(errs, store.Delete(name))
+	}
+	return errors.Join(errs...)
+}
+
+// SaveSecret puts the secret, e.g. the key of the upload destination, into the secret store. The returned reference
+// is kept instead of the secret.
+func SaveSecret(appState *state.AppState, name, secret string) (string, error) {
+	store, err := saveSecrets(appState, map[string]string{name: secret})
+	if err != nil {
+		return "", err
+	}
+	return store.Name() + ":" + name, nil
+}
+
+// LoadSecret returns the secret by the reference returned by SaveSecret
+func LoadSecret(appState *state.AppState, ref string) (string, error) {
+	storeName, name, found := strings.Cut(ref, ":")
+	if !found {
+		return "", fmt.Errorf("invalid secret reference '%s'", ref)
+	}
+	store, err := secretStoreByName(appState, storeName)
+	if err != nil {
+		return "", err
+	}
+	return store.Get(name)
+}
+
+// saveTokens puts the tokens of the user into the secret store and returns the store
+func (am *AuthManager) saveTokens(userData *UserData) (SecretStore, error) {
+	return saveSecrets(am.appState, map[string]string{secretIDToken: userData.IDToken, secretRefreshToken: userData.RefreshToken})
+}
+
+// loadTokens fills the tokens of the user from the store
+func loadTokens(store SecretStore, userData *UserData) error {
+	idToken, err := store.Get(secretIDToken)
+	if err != nil {
+		return err
+	}
+	// not every provider gives the refresh token
+	refreshToken, err := store.Get(secretRefreshToken)
+	if err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return err
+	}
+	userData.IDToken = idToken
+	userData.RefreshToken = refreshToken
+	return nil
+}
+
+func deleteTokens(store SecretStore) error {
+	return deleteSecrets(store, map[string]string{secretIDToken: "", secretRefreshToken: ""})
+}
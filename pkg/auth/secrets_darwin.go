@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// securityItemNotFound is the exit code of the security tool for the missing item
+const securityItemNotFound = 44
+
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// machineID returns the hardware UUID of this Mac
+func machineID() ([]byte, error) {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the platform UUID: %w", err)
+	}
+	match := platformUUID.FindSubmatch(out)
+	if match == nil {
+		return nil, fmt.Errorf("platform UUID not found")
+	}
+	return match[1], nil
+}
+
+// probeKeyring checks that the Keychain can be used with the security tool
+func probeKeyring() bool {
+	_, err := exec.LookPath("security")
+	return err == nil
+}
+
+func (k *keyringStore) Get(name string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", k.service, "-a", k.accountOf(name), "-w")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read the secret from the keychain: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+func (k *keyringStore) Set(name, secret string) error {
+	// the command goes through stdin of the interactive mode, the arguments are visible to the other processes
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+		quote(k.service), quote(k.accountOf(name)), hex.EncodeToString([]byte(secret)))
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to save the secret into the keychain: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// the interactive mode reports the failed command only on stderr
+	if stderr.Len() > 0 {
+		return fmt.Errorf("failed to save the secret into the keychain: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (k *keyringStore) Delete(name string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "delete-generic-password", "-s", k.service, "-a", k.accountOf(name))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to remove the secret from the keychain: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// accountOf is the account of the keychain item, one item for every secret
+func (k *keyringStore) accountOf(name string) string {
+	return k.account + ":" + name
+}
+
+// quote quotes the argument for the interactive mode of the security tool
+func quote(arg string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(arg, `\`, `\\`), `"`, `\"`) + `"`
+}
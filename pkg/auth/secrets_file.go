@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// SECRETS_FILE_VERSION is the format of the encrypted file
+const SECRETS_FILE_VERSION = 1
+
+// secretsKeyInfo binds the derived key to its purpose
+const secretsKeyInfo = "racemate-desktop credentials v1"
+
+// errOtherMachine is returned for the file that can't be decrypted with the key of this machine
+var errOtherMachine = errors.New("failed to decrypt credentials, they are of another machine")
+
+// encryptedFileStore keeps the secrets in the file encrypted with AES-GCM. The key is derived from the ID of this
+// machine, so the file copied to another machine, e.g. with the backup of the data directory, can't be read.
+type encryptedFileStore struct {
+	file string
+	// machineSecret returns the secret bound to this machine the key is derived from
+	machineSecret func() ([]byte, error)
+	logger        *slog.Logger
+}
+
+// secretsFile is the content of the encrypted file
+type secretsFile struct {
+	Version int `json:"version"`
+	// Salt is new for every write, so is the key derived with it
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func newEncryptedFileStore(file string, machineSecret func() ([]byte, error), logger *slog.Logger) *encryptedFileStore {
+	return &encryptedFileStore{file: file, machineSecret: machineSecret, logger: logger}
+}
+
+func (s *encryptedFileStore) Name() string {
+	return SECRET_STORE_FILE
+}
+
+func (s *encryptedFileStore) Get(name string) (string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *encryptedFileStore) Set(name, secret string) error {
+	secrets, err := s.loadForUpdate()
+	if err != nil {
+		return err
+	}
+	secrets[name] = secret
+	return s.save(secrets)
+}
+
+func (s *encryptedFileStore) Delete(name string) error {
+	secrets, err := s.loadForUpdate()
+	if err != nil {
+		return err
+	}
+	delete(secrets, name)
+	if len(secrets) == 0 {
+		if err := os.Remove(s.file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove credentials file: %w", err)
+		}
+		return nil
+	}
+	return s.save(secrets)
+}
+
+// loadForUpdate decrypts the secrets to be changed. The file of another machine can't be read anyway, it is
+// replaced. The other failures, e.g. the file that can't be read right now, are returned, so the secrets in it
+// are not lost.
+func (s *encryptedFileStore) loadForUpdate() (map[string]string, error) {
+	secrets, err := s.load()
+	if errors.Is(err, errOtherMachine) {
+		s.logger.Warn("Replacing the credentials file of another machine, its secrets are lost", "file", s.file, "error", err)
+		return map[string]string{}, nil
+	}
+	return secrets, err
+}
+
+// load decrypts the secrets, no file gives no secrets
+func (s *encryptedFileStore) load() (map[string]string, error) {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	content := secretsFile{}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+	if content.Version != SECRETS_FILE_VERSION {
+		return nil, fmt.Errorf("unsupported credentials file version %d", content.Version)
+	}
+
+	aead, err := s.cipher(content.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(content.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt credentials: invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, content.Nonce, content.Data, []byte(secretsKeyInfo))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errOtherMachine, err)
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return secrets, nil
+}
+
+// save encrypts the secrets with the new salt and nonce and replaces the file
+func (s *encryptedFileStore) save(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+	content := secretsFile{Version: SECRETS_FILE_VERSION, Salt: make([]byte, 16)}
+	if _, err := rand.Read(content.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := s.cipher(content.Salt)
+	if err != nil {
+		return err
+	}
+	content.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(content.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	content.Data = aead.Seal(nil, content.Nonce, plaintext, []byte(secretsKeyInfo))
+
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0700); err != nil {
+		return fmt.Errorf("failed to create auth directory: %w", err)
+	}
+	tmpFile := s.file + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	if err := os.Rename(tmpFile, s.file); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	return nil
+}
+
+// cipher derives the key from the machine secret and the salt
+func (s *encryptedFileStore) cipher(salt []byte) (cipher.AEAD, error) {
+	secret, err := s.machineSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the machine ID: %w", err)
+	}
+	key, err := hkdf.Key(sha256.New, secret, salt, secretsKeyInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive credentials key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credentials cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
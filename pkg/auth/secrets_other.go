@@ -0,0 +1,85 @@
+//go:build !windows && !darwin
+
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// machineIDFiles hold the ID of the machine, set up by systemd or D-Bus
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// machineID returns the ID of this machine, the host name where there's none
+func machineID() ([]byte, error) {
+	for _, file := range machineIDFiles {
+		if id, err := os.ReadFile(file); err == nil && len(bytes.TrimSpace(id)) > 0 {
+			return bytes.TrimSpace(id), nil
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hostname), nil
+}
+
+// probeKeyring checks that secret-tool is installed and the Secret Service answers
+func probeKeyring() bool {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return false
+	}
+	// the missing secret exits with 1 and says nothing, the missing service complains
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", KEYRING_SERVICE, "account", "probe")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return err == nil || stderr.Len() == 0
+}
+
+func (k *keyringStore) Get(name string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", append([]string{"lookup"}, k.attributes(name)...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() == 0 {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read the secret from the keyring: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (k *keyringStore) Set(name, secret string) error {
+	args := append([]string{"store", "--label", KEYRING_SERVICE + " " + name}, k.attributes(name)...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", args...)
+	// the secret goes through stdin, the arguments are visible to the other processes
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to save the secret into the keyring: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (k *keyringStore) Delete(name string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", append([]string{"clear"}, k.attributes(name)...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil && stderr.Len() > 0 {
+		return fmt.Errorf("failed to remove the secret from the keyring: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// attributes are the arguments of secret-tool identifying the secret
+func (k *keyringStore) attributes(name string) []string {
+	return []string{"service", k.service, "account", k.account, "secret", name}
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to give the file store the machine secret
+func machineSecret(secret string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(secret), nil }
+}
+
+// Helper to create the file store of the machine
+func newTestFileStore(file, secret string) *encryptedFileStore {
+	return newEncryptedFileStore(file, machineSecret(secret), slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "auth", SECRETS_FILE)
+	store := newTestFileStore(file, "machine-1")
+
+	_, err := store.Get(secretIDToken)
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	assert.NoError(t, store.Set(secretIDToken, "id-token"))
+	assert.NoError(t, store.Set(secretRefreshToken, "refresh-token"))
+	secret, err := store.Get(secretIDToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "id-token", secret)
+
+	// the tokens are not readable in the file
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "id-token")
+	assert.NotContains(t, string(data), "refresh-token")
+
+	assert.NoError(t, store.Delete(secretIDToken))
+	_, err = store.Get(secretIDToken)
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+	secret, err = store.Get(secretRefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh-token", secret)
+
+	// the file goes away with the last secret
+	assert.NoError(t, store.Delete(secretRefreshToken))
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestEncryptedFileStoreOtherMachine(t *testing.T) {
+	file := filepath.Join(t.TempDir(), SECRETS_FILE)
+	assert.NoError(t, newTestFileStore(file, "machine-1").Set(secretIDToken, "id-token"))
+
+	_, err := newTestFileStore(file, "machine-2").Get(secretIDToken)
+	assert.ErrorContains(t, err, "another machine")
+	assert.NotErrorIs(t, err, ErrSecretNotFound)
+
+	// the file of another machine is replaced by the new secrets
+	store := newTestFileStore(file, "machine-2")
+	assert.NoError(t, store.Set(secretRefreshToken, "refresh-token"))
+	secret, err := store.Get(secretRefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh-token", secret)
+	_, err = store.Get(secretIDToken)
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestEncryptedFileStoreKeepsUnreadableFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"broken":        "{",
+		"newer version": `{"version": 2}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), SECRETS_FILE)
+			assert.NoError(t, os.WriteFile(file, []byte(content), 0600))
+			store := newTestFileStore(file, "machine-1")
+
+			// the other secrets in the file are not thrown away
+			assert.Error(t, store.Set(secretIDToken, "id-token"))
+			assert.Error(t, store.Delete(secretIDToken))
+			data, err := os.ReadFile(file)
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		})
+	}
+}
+
+func TestSaveUserDataKeepsTokensOutOfFile(t *testing.T) {
+	authManager, tempDir, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+
+	userData := createTestUserData()
+	assert.NoError(t, authManager.SaveUserData(userData))
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "auth", "user.json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), userData.IDToken)
+	assert.NotContains(t, string(data), userData.RefreshToken)
+	assert.Contains(t, string(data), `"secrets": "file"`)
+
+	loaded, err := NewAuthManager(authManager.appState).LoadUserData()
+	assert.NoError(t, err)
+	assert.Equal(t, userData.UID, loaded.UID)
+	assert.Equal(t, userData.IDToken, loaded.IDToken)
+	assert.Equal(t, userData.RefreshToken, loaded.RefreshToken)
+}
+
+func TestLoadUserDataMigratesPlaintextTokens(t *testing.T) {
+	authManager, tempDir, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+
+	// the user data file of the older version
+	userData := createTestUserData()
+	data, err := json.Marshal(userData)
+	assert.NoError(t, err)
+	userDataFile := filepath.Join(tempDir, "auth", "user.json")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(userDataFile), 0700))
+	assert.NoError(t, os.WriteFile(userDataFile, data, 0600))
+
+	loaded, err := authManager.LoadUserData()
+	assert.NoError(t, err)
+	assert.Equal(t, userData.IDToken, loaded.IDToken)
+	assert.Equal(t, userData.RefreshToken, loaded.RefreshToken)
+	assert.WithinDuration(t, userData.LastLoginTime, loaded.LastLoginTime, 0)
+
+	data, err = os.ReadFile(userDataFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), userData.IDToken)
+	assert.NotContains(t, string(data), userData.RefreshToken)
+	_, err = os.Stat(filepath.Join(tempDir, "auth", SECRETS_FILE))
+	assert.NoError(t, err)
+
+	loaded, err = NewAuthManager(authManager.appState).LoadUserData()
+	assert.NoError(t, err)
+	assert.Equal(t, userData.IDToken, loaded.IDToken)
+	assert.Equal(t, userData.RefreshToken, loaded.RefreshToken)
+}
+
+func TestLoadUserDataMissingSecrets(t *testing.T) {
+	authManager, tempDir, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	assert.NoError(t, authManager.SaveUserData(createTestUserData()))
+
+	assert.NoError(t, os.Remove(filepath.Join(tempDir, "auth", SECRETS_FILE)))
+
+	loaded, err := NewAuthManager(authManager.appState).LoadUserData()
+	assert.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+func TestLogoutRemovesSecrets(t *testing.T) {
+	authManager, tempDir, cleanup := setupTestAuthManager(t)
+	defer cleanup()
+	assert.NoError(t, authManager.SaveUserData(createTestUserData()))
+
+	assert.NoError(t, authManager.Logout())
+
+	_, err := os.Stat(filepath.Join(tempDir, "auth", SECRETS_FILE))
+	assert.True(t, os.IsNotExist(err))
+}
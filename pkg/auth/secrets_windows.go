@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Credential Manager constants of wincred.h
+const (
+	credTypeGeneric           = 1
+	credPersistLocalMachine   = 2
+	credMaxCredentialBlobSize = 5 * 512
+)
+
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+// credential is CREDENTIALW of wincred.h
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// machineID returns the machine GUID Windows generates on the installation
+func machineID() ([]byte, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the cryptography registry key: %w", err)
+	}
+	defer key.Close()
+	guid, _, err := key.GetStringValue("MachineGuid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the machine GUID: %w", err)
+	}
+	return []byte(guid), nil
+}
+
+// probeKeyring checks that Credential Manager is there, it is on every supported Windows
+func probeKeyring() bool {
+	return procCredReadW.Find() == nil
+}
+
+func (k *keyringStore) Get(name string) (string, error) {
+	target, err := windows.UTF16PtrFromString(k.target(name))
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	if r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read the secret from Credential Manager: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (k *keyringStore) Set(name, secret string) error {
+	if len(secret) > credMaxCredentialBlobSize {
+		return fmt.Errorf("secret is too big for Credential Manager, %d bytes", len(secret))
+	}
+	target, err := windows.UTF16PtrFromString(k.target(name))
+	if err != nil {
+		return err
+	}
+	userName, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return fmt.Errorf("failed to save the secret into Credential Manager: %w", err)
+	}
+	return nil
+}
+
+func (k *keyringStore) Delete(name string) error {
+	target, err := windows.UTF16PtrFromString(k.target(name))
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return fmt.Errorf("failed to remove the secret from Credential Manager: %w", err)
+	}
+	return nil
+}
+
+// target is the name of the credential, one credential for every secret
+func (k *keyringStore) target(name string) string {
+	return k.service + ":" + k.account + ":" + name
+}
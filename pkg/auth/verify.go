@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// GOOGLE_JWKS_URL publishes the keys signing the Firebase ID tokens
+const GOOGLE_JWKS_URL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+
+// FIREBASE_ISSUER_PREFIX is the issuer of the ID tokens followed by the project ID
+const FIREBASE_ISSUER_PREFIX = "https://securetoken.google.com/"
+
+// JWKS_CACHE_FILE is the name of the file in the auth directory caching the signing keys
+const JWKS_CACHE_FILE = "jwks.json"
+
+// JWKS_REFRESH_INTERVAL is how often at most the keys are downloaded again for the unknown key ID
+const JWKS_REFRESH_INTERVAL = time.Minute
+
+// allowedClockSkew tolerates the clock of this computer being off a bit
+const allowedClockSkew = time.Minute
+
+// ErrInvalidToken is returned for the ID token that can't be trusted
+var ErrInvalidToken = errors.New("invalid ID token")
+
+// IDTokenClaims are the verified claims of the Firebase ID token
+type IDTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	AuthTime  int64  `json:"auth_time"`
+	Email     string `json:"email"`
+}
+
+// TokenVerifier verifies the Firebase ID tokens against the keys published by Google. The keys are cached
+// on disk for as long as Google allows.
+type TokenVerifier struct {
+	projectID string
+	jwksURL   string
+	cacheFile string
+	client    HTTPClient
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+}
+
+// NewTokenVerifier creates the verifier of the ID tokens of the Firebase project
+func NewTokenVerifier(appState *state.AppState, projectID string) *TokenVerifier {
+	return &TokenVerifier{
+		projectID: projectID,
+		jwksURL:   GOOGLE_JWKS_URL,
+		cacheFile: filepath.Join(appState.DataDir, "auth", JWKS_CACHE_FILE),
+		client:    httpClient,
+		logger:    appState.Logger,
+		now:       time.Now,
+	}
+}
+
+// VerifyIDToken checks the signature and the claims of the ID token and that it belongs to the user
+func (v *TokenVerifier) VerifyIDToken(idToken, uid string) (*IDTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: broken header: %v", ErrInvalidToken, err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unexpected algorithm '%s'", ErrInvalidToken, header.Algorithm)
+	}
+	claims := &IDTokenClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("%w: broken claims: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: broken signature: %v", ErrInvalidToken, err)
+	}
+
+	key, err := v.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: signature doesn't match", ErrInvalidToken)
+	}
+
+	if err := v.checkClaims(claims, uid); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims checks that the token was issued for this project to the user and is valid now
+func (v *TokenVerifier) checkClaims(claims *IDTokenClaims, uid string) error {
+	now := v.now()
+	switch {
+	case claims.Audience != v.projectID:
+		return fmt.Errorf("%w: issued for '%s'", ErrInvalidToken, claims.Audience)
+	case claims.Issuer != FIREBASE_ISSUER_PREFIX+v.projectID:
+		return fmt.Errorf("%w: issued by '%s'", ErrInvalidToken, claims.Issuer)
+	case !now.Before(time.Unix(claims.ExpiresAt, 0).Add(allowedClockSkew)):
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(allowedClockSkew)) || time.Unix(claims.AuthTime, 0).After(now.Add(allowedClockSkew)):
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case claims.Subject == "":
+		return fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case claims.Subject != uid:
+		return fmt.Errorf("%w: issued to another user", ErrInvalidToken)
+	}
+	return nil
+}
+
+// key returns the public key by its ID. The keys are downloaded again when they expired or the key is unknown,
+// Google might have rotated them. The cached keys are used while the download fails.
+func (v *TokenVerifier) key(keyID string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.keys == nil {
+		v.loadCache()
+	}
+
+	now := v.now()
+	key, ok := v.keys[keyID]
+	if ok && now.Before(v.expiresAt) {
+		return key, nil
+	}
+	if now.Sub(v.fetchedAt) >= JWKS_REFRESH_INTERVAL {
+		if err := v.fetch(); err != nil {
+			if !ok {
+				return nil, err
+			}
+			v.logger.Warn("Failed to refresh the ID token keys, using the cached ones", "error", err)
+		}
+		key, ok = v.keys[keyID]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key '%s'", ErrInvalidToken, keyID)
+	}
+	return key, nil
+}
+
+// jwksCache is the content of the cache file
+type jwksCache struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+	Keys      []jwk     `json:"keys"`
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// fetch downloads the keys and caches them for the time the response allows
+func (v *TokenVerifier) fetch() error {
+	v.fetchedAt = v.now()
+	req, err := http.NewRequest(http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create ID token keys request: %w", err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download ID token keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ID token keys request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	cache := jwksCache{ExpiresAt: v.now().Add(maxAge(resp.Header.Get("Cache-Control")))}
+	if err := json.NewDecoder(resp.Body).Decode(&cache); err != nil {
+		return fmt.Errorf("failed to decode ID token keys: %w", err)
+	}
+	keys, err := parseKeys(cache.Keys)
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	v.expiresAt = cache.ExpiresAt
+
+	if err := v.saveCache(cache); err != nil {
+		v.logger.Warn("Failed to cache the ID token keys", "error", err)
+	}
+	return nil
+}
+
+// loadCache reads the cached keys, missing or broken cache gives no keys
+func (v *TokenVerifier) loadCache() {
+	v.keys = map[string]*rsa.PublicKey{}
+	data, err := os.ReadFile(v.cacheFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			v.logger.Warn("Failed to read the cached ID token keys", "error", err)
+		}
+		return
+	}
+	cache := jwksCache{}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		v.logger.Warn("Failed to parse the cached ID token keys", "error", err)
+		return
+	}
+	keys, err := parseKeys(cache.Keys)
+	if err != nil {
+		v.logger.Warn("Failed to parse the cached ID token keys", "error", err)
+		return
+	}
+	v.keys = keys
+	v.expiresAt = cache.ExpiresAt
+}
+
+func (v *TokenVerifier) saveCache(cache jwksCache) error {
+	if err := os.MkdirAll(filepath.Dir(v.cacheFile), 0700); err != nil {
+		return fmt.Errorf("failed to create auth directory: %w", err)
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ID token keys: %w", err)
+	}
+	tmpFile := v.cacheFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write ID token keys: %w", err)
+	}
+	if err := os.Rename(tmpFile, v.cacheFile); err != nil {
+		return fmt.Errorf("failed to write ID token keys: %w", err)
+	}
+	return nil
+}
+
+// parseKeys converts the RSA keys of the JWKS, the other keys are skipped
+func parseKeys(jwks []jwk) (map[string]*rsa.PublicKey, error) {
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range jwks {
+		if key.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of the key '%s': %w", key.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of the key '%s'", key.KeyID)
+		}
+		keys[key.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA keys among the ID token keys")
+	}
+	return keys, nil
+}
+
+// maxAge returns the max-age of the Cache-Control header, an hour when missing
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "max-age") {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return time.Hour
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
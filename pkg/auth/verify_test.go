@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	"github.com/stretchr/testify/assert"
+)
+
+const testProjectID = "racemate-test"
+
+// jwksServer is the stand-in for the keys published by Google
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	requests int
+	maxAge   int
+}
+
+// Helper to create the key set stand-in with one key
+func setupJWKSServer(t *testing.T) *jwksServer {
+	server := &jwksServer{keys: map[string]*rsa.PrivateKey{}, maxAge: 3600}
+	server.addKey(t, "key-1")
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.requests++
+		keys := []jwk{}
+		for kid, key := range server.keys {
+			keys = append(keys, jwk{
+				KeyType: "RSA",
+				KeyID:   kid,
+				N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(server.maxAge)+", must-revalidate")
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+	return key
+}
+
+// Helper to create the verifier using the stand-in keys
+func setupTestVerifier(t *testing.T, server *jwksServer) *TokenVerifier {
+	authManager, _, cleanup := setupTestAuthManager(t)
+	t.Cleanup(cleanup)
+	verifier := NewTokenVerifier(authManager.appState, testProjectID)
+	verifier.jwksURL = server.URL
+	verifier.client = server.Client()
+	return verifier
+}
+
+// Helper to create the claims Firebase issues to the test user
+func validClaims() IDTokenClaims {
+	now := time.Now()
+	return IDTokenClaims{
+		Issuer:    FIREBASE_ISSUER_PREFIX + testProjectID,
+		Audience:  testProjectID,
+		Subject:   "test-user-123",
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		AuthTime:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+}
+
+// Helper to sign the claims as the ID token
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims IDTokenClaims) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	server := setupJWKSServer(t)
+	verifier := setupTestVerifier(t, server)
+	token := signToken(t, server.keys["key-1"], "key-1", validClaims())
+
+	claims, err := verifier.VerifyIDToken(token, "test-user-123")
+	assert.NoError(t, err)
+	assert.Equal(t, "test-user-123", claims.Subject)
+
+	// the keys are cached
+	_, err = verifier.VerifyIDToken(token, "test-user-123")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.requests)
+	assert.FileExists(t, verifier.cacheFile)
+}
+
+func TestVerifyIDTokenInvalid(t *testing.T) {
+	server := setupJWKSServer(t)
+	verifier := setupTestVerifier(t, server)
+	key := server.keys["key-1"]
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		token string
+		uid   string
+	}{
+		"other user": {signToken(t, key, "key-1", validClaims()), "someone-else"},
+		"other project": {signToken(t, key, "key-1", func() IDTokenClaims {
+			claims := validClaims()
+			claims.Audience = "other-project"
+			return claims
+		}()), "test-user-123"},
+		"other issuer": {signToken(t, key, "key-1", func() IDTokenClaims {
+			claims := validClaims()
+			claims.Issuer = "https://accounts.example.com"
+			return claims
+		}()), "test-user-123"},
+		"expired": {signToken(t, key, "key-1", func() IDTokenClaims {
+			claims := validClaims()
+			claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+			return claims
+		}()), "test-user-123"},
+		"issued in the future": {signToken(t, key, "key-1", func() IDTokenClaims {
+			claims := validClaims()
+			claims.IssuedAt = time.Now().Add(time.Hour).Unix()
+			return claims
+		}()), "test-user-123"},
+		"missing subject": {signToken(t, key, "key-1", func() IDTokenClaims {
+			claims := validClaims()
+			claims.Subject = ""
+			return claims
+		}()), ""},
+		"forged signature": {signToken(t, otherKey, "key-1", validClaims()), "test-user-123"},
+		"unknown key":      {signToken(t, otherKey, "key-2", validClaims()), "test-user-123"},
+		"unsigned":         {"eyJhbGciOiJub25lIn0.eyJzdWIiOiJ0ZXN0LXVzZXItMTIzIn0.", "test-user-123"},
+		"not a JWT":        {"token", "test-user-123"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifier.VerifyIDToken(test.token, test.uid)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestVerifyIDTokenRotatedKeys(t *testing.T) {
+	server := setupJWKSServer(t)
+	verifier := setupTestVerifier(t, server)
+	_, err := verifier.VerifyIDToken(signToken(t, server.keys["key-1"], "key-1", validClaims()), "test-user-123")
+	assert.NoError(t, err)
+
+	// the new key is downloaded, but not more often than allowed
+	key := server.addKey(t, "key-2")
+	_, err = verifier.VerifyIDToken(signToken(t, key, "key-2", validClaims()), "test-user-123")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 1, server.requests)
+
+	verifier.now = func() time.Time { return time.Now().Add(JWKS_REFRESH_INTERVAL) }
+	_, err = verifier.VerifyIDToken(signToken(t, key, "key-2", validClaims()), "test-user-123")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.requests)
+}
+
+func TestVerifyIDTokenCachedKeys(t *testing.T) {
+	server := setupJWKSServer(t)
+	verifier := setupTestVerifier(t, server)
+	token := signToken(t, server.keys["key-1"], "key-1", validClaims())
+	_, err := verifier.VerifyIDToken(token, "test-user-123")
+	assert.NoError(t, err)
+	server.Close()
+
+	// the next start uses the keys cached on disk
+	dataDir := filepath.Dir(filepath.Dir(verifier.cacheFile))
+	cached := NewTokenVerifier(&state.AppState{DataDir: dataDir, Logger: verifier.logger}, testProjectID)
+	cached.jwksURL = server.URL
+	_, err = cached.VerifyIDToken(token, "test-user-123")
+	assert.NoError(t, err)
+
+	// the expired keys are still used while the new ones can't be downloaded
+	cached.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	claims := validClaims()
+	claims.ExpiresAt = time.Now().Add(3 * time.Hour).Unix()
+	_, err = cached.VerifyIDToken(signToken(t, server.keys["key-1"], "key-1", claims), "test-user-123")
+	assert.NoError(t, err)
+}
+
+func TestMaxAge(t *testing.T) {
+	assert.Equal(t, 19845*time.Second, maxAge("public, max-age=19845, must-revalidate, no-transform"))
+	assert.Equal(t, time.Hour, maxAge("no-cache"))
+	assert.Equal(t, time.Hour, maxAge(""))
+}
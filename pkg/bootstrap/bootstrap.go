@@ -0,0 +1,192 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/sparkoo/racemate-desktop/pkg/logger"
+	"github.com/sparkoo/racemate-desktop/pkg/settings"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// DATA_DIR_ENV is the environment variable overriding the data directory
+const DATA_DIR_ENV = "RACEMATE_DATA_DIR"
+
+// Options of the app initialization
+type Options struct {
+	// DataDir overrides the platform data directory, takes precedence over DATA_DIR_ENV
+	DataDir string
+	// Console is where the log records are written besides the log file
+	Console io.Writer
+}
+
+// InitApp creates the app state with data directories, user settings and logger
+func InitApp(appName string, options Options) (*state.AppState, error) {
+	appState := &state.AppState{
+		LogLevel: &slog.LevelVar{},
+	}
+
+	if err := initDataDirs(appName, options.DataDir, appState); err != nil {
+		return nil, fmt.Errorf("failed to init data dirs: %w", err)
+	}
+
+	// broken settings file must not prevent the app from starting, we go with defaults then
+	userSettings, settingsErr := settings.Load(appState.DataDir)
+	if settingsErr != nil {
+		userSettings = settings.Default()
+	}
+	userSettings.Apply(appState)
+
+	initLogger(appState, options.Console)
+	if settingsErr != nil {
+		appState.Logger.Warn("Failed to load settings, using defaults", "error", settingsErr)
+	}
+	if moved, err := userSettings.MoveSecrets(appState); err != nil {
+		appState.Logger.Warn("Failed to move the secrets out of the settings", "error", err)
+	} else if moved {
+		if err := userSettings.Save(appState.DataDir); err != nil {
+			appState.Logger.Warn("Failed to save the settings without the secrets", "error", err)
+		}
+		userSettings.Apply(appState)
+	}
+
+	// laps could have been added or removed while the app was not running
+	appState.Library = library.Open(appState.DataDir, appState.UploadDir, appState.UploadedDir, appState.Logger)
+	if err := appState.Library.Rebuild(); err != nil {
+		appState.Logger.Warn("Failed to rebuild the lap library", "error", err)
+	}
+
+	return appState, nil
+}
+
+func initLogger(appState *state.AppState, console io.Writer) {
+	// Initialize logger with default configuration
+	config := logger.DefaultConfig(appState.LogsDir)
+	config.Console = console
+	config.Level = appState.LogLevel
+	appState.Logger = logger.Initialize(config)
+}
+
+// resolveDirs returns the app data directory and the logs directory for given OS.
+// Non-empty dataRoot overrides the platform default, everything is stored under it then.
+func resolveDirs(appName, goos string, getenv func(string) string, dataRoot string) (string, string, error) {
+	if dataRoot == "" {
+		dataRoot = getenv(DATA_DIR_ENV)
+	}
+	if dataRoot != "" {
+		return dataRoot, filepath.Join(dataRoot, "logs"), nil
+	}
+
+	switch goos {
+	case "windows":
+		appDataDir := getenv("AppData")
+		if appDataDir == "" {
+			appDataDir = getenv("LOCALAPPDATA")
+		}
+		if appDataDir == "" {
+			return "", "", fmt.Errorf("neither AppData nor LOCALAPPDATA is set")
+		}
+		appDir := filepath.Join(appDataDir, appName)
+		return appDir, filepath.Join(appDir, "logs"), nil
+	case "darwin":
+		home := getenv("HOME")
+		if home == "" {
+			return "", "", fmt.Errorf("HOME is not set")
+		}
+		return filepath.Join(home, "Library", "Application Support", appName),
+			filepath.Join(home, "Library", "Logs", appName), nil
+	default:
+		// XDG Base Directory Specification, logs are state data. Relative paths are invalid by the spec.
+		home := getenv("HOME")
+		dataHome := getenv("XDG_DATA_HOME")
+		if !path.IsAbs(dataHome) {
+			if home == "" {
+				return "", "", fmt.Errorf("neither XDG_DATA_HOME nor HOME is set")
+			}
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		stateHome := getenv("XDG_STATE_HOME")
+		if !path.IsAbs(stateHome) {
+			if home == "" {
+				return "", "", fmt.Errorf("neither XDG_STATE_HOME nor HOME is set")
+			}
+			stateHome = filepath.Join(home, ".local", "state")
+		}
+		dirName := strings.ToLower(appName)
+		return filepath.Join(dataHome, dirName), filepath.Join(stateHome, dirName, "logs"), nil
+	}
+}
+
+func initDataDirs(appName string, dataRoot string, appState *state.AppState) error {
+	appDir, logsDir, err := resolveDirs(appName, runtime.GOOS, os.Getenv, dataRoot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve data directory: %w", err)
+	}
+
+	if err := CreateFullDir(appDir); err != nil {
+		return fmt.Errorf("failed to create an app dir '%s': %w", appDir, err)
+	} else {
+		appState.DataDir = appDir
+	}
+
+	uploadDir := filepath.Join(appDir, "upload")
+	if err := CreateFullDir(uploadDir); err != nil {
+		return fmt.Errorf("failed to create an upload dir '%s': %w", uploadDir, err)
+	} else {
+		appState.UploadDir = uploadDir
+	}
+
+	uploadedDir := filepath.Join(appDir, "uploaded")
+	if err := CreateFullDir(uploadedDir); err != nil {
+		return fmt.Errorf("failed to create an uploaded dir '%s': %w", uploadedDir, err)
+	} else {
+		appState.UploadedDir = uploadedDir
+	}
+
+	if err := CreateFullDir(logsDir); err != nil {
+		return fmt.Errorf("failed to create a logs dir '%s': %w", logsDir, err)
+	} else {
+		appState.LogsDir = logsDir
+	}
+
+	recordingsDir := filepath.Join(appDir, "recordings")
+	if err := CreateFullDir(recordingsDir); err != nil {
+		return fmt.Errorf("failed to create a recordings dir '%s': %w", recordingsDir, err)
+	} else {
+		appState.RecordingsDir = recordingsDir
+	}
+
+	quarantineDir := filepath.Join(appDir, "quarantine")
+	if err := CreateFullDir(quarantineDir); err != nil {
+		return fmt.Errorf("failed to create a quarantine dir '%s': %w", quarantineDir, err)
+	} else {
+		appState.QuarantineDir = quarantineDir
+	}
+
+	rejectedDir := filepath.Join(appDir, "rejected")
+	if err := CreateFullDir(rejectedDir); err != nil {
+		return fmt.Errorf("failed to create a rejected dir '%s': %w", rejectedDir, err)
+	} else {
+		appState.RejectedDir = rejectedDir
+	}
+
+	return nil
+}
+
+// CreateFullDir creates the directory with all its parents if it doesn't exist yet
+func CreateFullDir(dirPath string) error {
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		err := os.MkdirAll(dirPath, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create getenv function from the map
+func fakeEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestResolveDirsWindows(t *testing.T) {
+	dataDir, logsDir, err := resolveDirs("RaceMate", "windows", fakeEnv(map[string]string{
+		"AppData": `C:\Users\racer\AppData\Roaming`,
+	}), "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(`C:\Users\racer\AppData\Roaming`, "RaceMate"), dataDir)
+	assert.Equal(t, filepath.Join(dataDir, "logs"), logsDir)
+}
+
+func TestResolveDirsWindowsLocalAppData(t *testing.T) {
+	dataDir, _, err := resolveDirs("RaceMate", "windows", fakeEnv(map[string]string{
+		"LOCALAPPDATA": `C:\Users\racer\AppData\Local`,
+	}), "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(`C:\Users\racer\AppData\Local`, "RaceMate"), dataDir)
+}
+
+func TestResolveDirsLinuxDefaults(t *testing.T) {
+	dataDir, logsDir, err := resolveDirs("RaceMate", "linux", fakeEnv(map[string]string{
+		"HOME": "/home/racer",
+	}), "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/racer", ".local", "share", "racemate"), dataDir)
+	assert.Equal(t, filepath.Join("/home/racer", ".local", "state", "racemate", "logs"), logsDir)
+}
+
+func TestResolveDirsLinuxXDG(t *testing.T) {
+	dataDir, logsDir, err := resolveDirs("RaceMate", "linux", fakeEnv(map[string]string{
+		"HOME":           "/home/racer",
+		"XDG_DATA_HOME":  "/data",
+		"XDG_STATE_HOME": "relative/is/ignored",
+	}), "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/data", "racemate"), dataDir)
+	assert.Equal(t, filepath.Join("/home/racer", ".local", "state", "racemate", "logs"), logsDir)
+}
+
+func TestResolveDirsLinuxNoHome(t *testing.T) {
+	_, _, err := resolveDirs("RaceMate", "linux", fakeEnv(map[string]string{}), "")
+	assert.Error(t, err)
+}
+
+func TestResolveDirsDarwin(t *testing.T) {
+	dataDir, logsDir, err := resolveDirs("RaceMate", "darwin", fakeEnv(map[string]string{
+		"HOME": "/Users/racer",
+	}), "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/Users/racer", "Library", "Application Support", "RaceMate"), dataDir)
+	assert.Equal(t, filepath.Join("/Users/racer", "Library", "Logs", "RaceMate"), logsDir)
+}
+
+func TestResolveDirsOverride(t *testing.T) {
+	env := fakeEnv(map[string]string{
+		"HOME":       "/home/racer",
+		DATA_DIR_ENV: "/from/env",
+	})
+
+	dataDir, logsDir, err := resolveDirs("RaceMate", "linux", env, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/from/env", dataDir)
+	assert.Equal(t, filepath.Join("/from/env", "logs"), logsDir)
+
+	// explicit directory wins over the environment
+	dataDir, _, err = resolveDirs("RaceMate", "windows", env, "/from/flag")
+	assert.NoError(t, err)
+	assert.Equal(t, "/from/flag", dataDir)
+}
+
+func TestInitDataDirsCreatesDirs(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "racemate")
+
+	appState := &state.AppState{}
+	err := initDataDirs("RaceMate", dataDir, appState)
+	assert.NoError(t, err)
+	assert.Equal(t, dataDir, appState.DataDir)
+
+	for _, dir := range []string{appState.UploadDir, appState.UploadedDir, appState.LogsDir, appState.RecordingsDir, appState.QuarantineDir, appState.RejectedDir} {
+		info, err := os.Stat(dir)
+		assert.NoError(t, err)
+		assert.True(t, info.IsDir())
+	}
+}
@@ -0,0 +1,134 @@
+package channels
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// Channel is one value of the frame as it is exported to and imported from other telemetry formats
+type Channel struct {
+	Name      string
+	ShortName string
+	Unit      string
+	// Discrete channels are not interpolated, e.g. the gear
+	Discrete bool
+	// Get reads the value from the frame in the channel unit
+	Get func(frame *message.Frame) float64
+	// Set writes the value in the channel unit to the frame
+	Set func(frame *message.Frame, value float64)
+}
+
+// ALL are all the frame channels
+var ALL = []Channel{
+	{
+		Name: "Lap Time", ShortName: "LapTime", Unit: "s",
+		Get: func(f *message.Frame) float64 { return float64(f.CurrentTime) / 1000 },
+		Set: func(f *message.Frame, v float64) { f.CurrentTime = int32(v*1000 + 0.5) },
+	},
+	{
+		Name: "Lap Position", ShortName: "LapPos", Unit: "",
+		Get: func(f *message.Frame) float64 { return float64(f.NormalizedCarPosition) },
+		Set: func(f *message.Frame, v float64) { f.NormalizedCarPosition = float32(v) },
+	},
+	{
+		Name: "Speed", ShortName: "Speed", Unit: "km/h",
+		Get: func(f *message.Frame) float64 { return float64(f.SpeedKmh) },
+		Set: func(f *message.Frame, v float64) { f.SpeedKmh = float32(v) },
+	},
+	{
+		// ACC reports pedals from 0 to 1, analysis tools expect percents
+		Name: "Throttle Pos", ShortName: "Throttle", Unit: "%",
+		Get: func(f *message.Frame) float64 { return float64(f.Gas) * 100 },
+		Set: func(f *message.Frame, v float64) { f.Gas = float32(v / 100) },
+	},
+	{
+		Name: "Brake Pos", ShortName: "Brake", Unit: "%",
+		Get: func(f *message.Frame) float64 { return float64(f.Brake) * 100 },
+		Set: func(f *message.Frame, v float64) { f.Brake = float32(v / 100) },
+	},
+	{
+		// normalized steering input from -1 to 1
+		Name: "Steering", ShortName: "Steer", Unit: "",
+		Get: func(f *message.Frame) float64 { return float64(f.SteerAngle) },
+		Set: func(f *message.Frame, v float64) { f.SteerAngle = float32(v) },
+	},
+	{
+		Name: "Gear", ShortName: "Gear", Unit: "", Discrete: true,
+		Get: func(f *message.Frame) float64 { return float64(f.Gear) },
+		Set: func(f *message.Frame, v float64) { f.Gear = int32(math.Round(v)) },
+	},
+	{
+		Name: "Engine RPM", ShortName: "RPM", Unit: "rpm",
+		Get: func(f *message.Frame) float64 { return float64(f.Rpm) },
+		Set: func(f *message.Frame, v float64) { f.Rpm = int32(math.Round(v)) },
+	},
+	{
+		Name: "Car Pos X", ShortName: "CarX", Unit: "m",
+		Get: func(f *message.Frame) float64 { return float64(f.CarCoordinateX) },
+		Set: func(f *message.Frame, v float64) { f.CarCoordinateX = float32(v) },
+	},
+	{
+		Name: "Car Pos Y", ShortName: "CarY", Unit: "m",
+		Get: func(f *message.Frame) float64 { return float64(f.CarCoordinateY) },
+		Set: func(f *message.Frame, v float64) { f.CarCoordinateY = float32(v) },
+	},
+	{
+		Name: "Car Pos Z", ShortName: "CarZ", Unit: "m",
+		Get: func(f *message.Frame) float64 { return float64(f.CarCoordinateZ) },
+		Set: func(f *message.Frame, v float64) { f.CarCoordinateZ = float32(v) },
+	},
+	{
+		Name: "Valid Lap", ShortName: "Valid", Unit: "", Discrete: true,
+		Get: func(f *message.Frame) float64 { return float64(f.IsValidLap) },
+		Set: func(f *message.Frame, v float64) { f.IsValidLap = int32(math.Round(v)) },
+	},
+	{
+		Name: "Penalty", ShortName: "Penalty", Unit: "", Discrete: true,
+		Get: func(f *message.Frame) float64 { return float64(f.PenaltyType) },
+		Set: func(f *message.Frame, v float64) { f.PenaltyType = int32(math.Round(v)) },
+	},
+}
+
+// DEFAULT are the channels exported when no channels are chosen
+var DEFAULT = []string{
+	"Lap Time", "Lap Position", "Speed", "Throttle Pos", "Brake Pos", "Steering", "Gear", "Engine RPM",
+	"Car Pos X", "Car Pos Y", "Car Pos Z",
+}
+
+// Lookup finds the channel by its name or short name, case insensitive
+func Lookup(name string) (Channel, bool) {
+	for _, channel := range ALL {
+		if strings.EqualFold(channel.Name, name) || strings.EqualFold(channel.ShortName, name) {
+			return channel, true
+		}
+	}
+	return Channel{}, false
+}
+
+// LookupAll finds all the channels, empty names give the DEFAULT channels
+func LookupAll(names []string) ([]Channel, error) {
+	if len(names) == 0 {
+		names = DEFAULT
+	}
+	found := make([]Channel, 0, len(names))
+	for _, name := range names {
+		channel, ok := Lookup(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown channel '%s'", name)
+		}
+		found = append(found, channel)
+	}
+	return found, nil
+}
+
+// Names returns the names of all the channels
+func Names() []string {
+	names := make([]string, 0, len(ALL))
+	for _, channel := range ALL {
+		names = append(names, channel.Name)
+	}
+	return names
+}
@@ -0,0 +1,62 @@
+package channels
+
+import (
+	"testing"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	channel, found := Lookup("speed")
+	assert.True(t, found)
+	assert.Equal(t, "km/h", channel.Unit)
+
+	channel, found = Lookup("Throttle")
+	assert.True(t, found)
+	assert.Equal(t, "Throttle Pos", channel.Name)
+
+	_, found = Lookup("Oil Temp")
+	assert.False(t, found)
+}
+
+func TestLookupAll(t *testing.T) {
+	found, err := LookupAll(nil)
+	assert.NoError(t, err)
+	assert.Len(t, found, len(DEFAULT))
+
+	found, err = LookupAll([]string{"Speed", " Gear"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Gear", found[1].Name)
+
+	_, err = LookupAll([]string{"Speed", "Oil Temp"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Oil Temp")
+}
+
+func TestGetSetRoundTrip(t *testing.T) {
+	frame := &message.Frame{
+		CurrentTime:           61234,
+		NormalizedCarPosition: 0.5,
+		SpeedKmh:              212.5,
+		Gas:                   0.75,
+		Brake:                 0.25,
+		SteerAngle:            -0.1,
+		Gear:                  5,
+		Rpm:                   7200,
+		CarCoordinateX:        -100.5,
+		CarCoordinateY:        12,
+		CarCoordinateZ:        350.25,
+		IsValidLap:            1,
+		PenaltyType:           2,
+	}
+
+	copied := &message.Frame{}
+	for _, channel := range ALL {
+		channel.Set(copied, channel.Get(frame))
+	}
+	assert.Equal(t, frame.String(), copied.String())
+
+	throttle, _ := Lookup("Throttle Pos")
+	assert.Equal(t, float64(75), throttle.Get(frame))
+}
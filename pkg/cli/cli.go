@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sparkoo/racemate-desktop/pkg/bootstrap"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+const usage = `Usage: %[1]s [--data-dir DIR] [command]
+
+Without a command the desktop app is started.
+
+Options:
+  --data-dir DIR                 store all data in DIR instead of the platform default,
+                                 can be set also with RACEMATE_DATA_DIR environment variable
+
+Commands:
+  run [--headless]               run the app, --headless runs telemetry and upload without the window
+  upload                         upload all laps waiting for the upload
+  login [--port PORT] [--issuer URL --client-id ID] [--device]
+                                 log in using the browser, with --issuer using the OpenID Connect provider,
+                                 --device prints the code to log in with on another device
+  logout                         log out
+  laps list [--track T] [--car C] [--from DATE] [--to DATE]
+                                 list recorded laps, dates are YYYY-MM-DD
+  laps best [--track T] [--car C] [--from DATE] [--to DATE]
+                                 list the best lap and the theoretical best of every track and car
+  laps pb                        list personal bests
+  laps export [--format csv|ld] [--channels LIST] [--time-base time|distance] [--frequency HZ] [--out FILE] LAP
+                                 export the lap to CSV or MoTeC i2, LAP is the file or its name from laps list
+  laps import [--mapping FILE] [--track T] [--car C] [--date DATE] FILE...
+                                 import laps from MoTeC i2 .ld or CSV files
+  replay [--speed N] [--out DIR] FILE
+                                 replay recorded telemetry session through the lap detection
+`
+
+// Cli runs racemate commands without the GUI
+type Cli struct {
+	appName string
+	stdout  io.Writer
+	stderr  io.Writer
+	dataDir string
+	initApp func(appName string, options bootstrap.Options) (*state.AppState, error)
+}
+
+// New creates a new Cli writing its output to stdout and errors and logs to stderr
+func New(appName string, stdout, stderr io.Writer) *Cli {
+	return &Cli{
+		appName: appName,
+		stdout:  stdout,
+		stderr:  stderr,
+		initApp: bootstrap.InitApp,
+	}
+}
+
+// GlobalFlags are the options valid for the desktop app and all the commands
+type GlobalFlags struct {
+	DataDir string
+}
+
+// ParseGlobalFlags parses the global flags preceding the command and returns the remaining arguments
+func ParseGlobalFlags(args []string) (GlobalFlags, []string, error) {
+	global := GlobalFlags{}
+	for len(args) > 0 {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if !strings.HasPrefix(args[0], "-") || name != "data-dir" {
+			break
+		}
+		if !hasValue {
+			if len(args) < 2 {
+				return global, nil, usageError("flag needs an argument: --data-dir")
+			}
+			value = args[1]
+			args = args[1:]
+		}
+		global.DataDir = value
+		args = args[1:]
+	}
+	return global, args, nil
+}
+
+// IsGUI reports whether given command line arguments ask for the desktop app
+func IsGUI(args []string) bool {
+	if _, rest, err := ParseGlobalFlags(args); err == nil {
+		args = rest
+	}
+	if len(args) == 0 {
+		return true
+	}
+	if args[0] != "run" {
+		return false
+	}
+	for _, arg := range args[1:] {
+		if arg == "--headless" || arg == "-headless" || arg == "--headless=true" {
+			return false
+		}
+	}
+	return true
+}
+
+// Run executes the command and returns the process exit code
+func (c *Cli) Run(args []string) int {
+	global, args, err := ParseGlobalFlags(args)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return 2
+	}
+	c.dataDir = global.DataDir
+
+	if len(args) == 0 {
+		c.printUsage()
+		return 2
+	}
+
+	var cmdErr error
+	switch args[0] {
+	case "run":
+		cmdErr = c.runHeadless(args[1:])
+	case "upload":
+		cmdErr = c.upload(args[1:])
+	case "login":
+		cmdErr = c.login(args[1:])
+	case "logout":
+		cmdErr = c.logout(args[1:])
+	case "laps":
+		cmdErr = c.laps(args[1:])
+	case "replay":
+		cmdErr = c.replay(args[1:])
+	case "help", "-h", "--help":
+		c.printUsage()
+		return 0
+	default:
+		fmt.Fprintf(c.stderr, "unknown command '%s'\n", args[0])
+		c.printUsage()
+		return 2
+	}
+
+	if cmdErr != nil {
+		if cmdErr == flag.ErrHelp {
+			return 0
+		}
+		if _, ok := cmdErr.(usageError); ok {
+			fmt.Fprintln(c.stderr, cmdErr)
+			return 2
+		}
+		fmt.Fprintf(c.stderr, "Error: %v\n", cmdErr)
+		return 1
+	}
+	return 0
+}
+
+// usageError is returned when the command was invoked wrong
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func (c *Cli) printUsage() {
+	fmt.Fprintf(c.stderr, usage, c.appName)
+}
+
+func (c *Cli) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// parseFlags parses the command flags, wrapping the parse errors as usage errors
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return usageError(err.Error())
+	}
+	return nil
+}
+
+func (c *Cli) appState() (*state.AppState, error) {
+	appState, err := c.initApp(c.appName, bootstrap.Options{DataDir: c.dataDir, Console: c.stderr})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize the app: %w", err)
+	}
+	return appState, nil
+}
+
+// signalContext returns the context with app state, canceled on interrupt or terminate signal
+func signalContext(appState *state.AppState) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), state.APP_STATE, appState)
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
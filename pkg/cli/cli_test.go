@@ -0,0 +1,278 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparkoo/racemate-desktop/pkg/bootstrap"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a Cli working with app state in a temporary directory
+func setupTestCli(t *testing.T) (*Cli, *state.AppState, *bytes.Buffer, *bytes.Buffer) {
+	dataDir := t.TempDir()
+	appState := &state.AppState{
+		DataDir:     dataDir,
+		UploadDir:   filepath.Join(dataDir, "upload"),
+		UploadedDir: filepath.Join(dataDir, "uploaded"),
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})),
+	}
+	assert.NoError(t, os.MkdirAll(appState.UploadDir, 0755))
+	assert.NoError(t, os.MkdirAll(appState.UploadedDir, 0755))
+	appState.Library = library.Open(dataDir, appState.UploadDir, appState.UploadedDir, appState.Logger)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	c := New("racemate", stdout, stderr)
+	c.initApp = func(appName string, options bootstrap.Options) (*state.AppState, error) {
+		return appState, nil
+	}
+	return c, appState, stdout, stderr
+}
+
+func TestIsGUI(t *testing.T) {
+	assert.True(t, IsGUI(nil))
+	assert.True(t, IsGUI([]string{"run"}))
+	assert.False(t, IsGUI([]string{"run", "--headless"}))
+	assert.False(t, IsGUI([]string{"upload"}))
+	assert.False(t, IsGUI([]string{"laps", "list"}))
+	assert.True(t, IsGUI([]string{"--data-dir", "/tmp/racemate"}))
+	assert.False(t, IsGUI([]string{"--data-dir=/tmp/racemate", "upload"}))
+}
+
+func TestParseGlobalFlags(t *testing.T) {
+	global, rest, err := ParseGlobalFlags([]string{"--data-dir", "/data", "laps", "list"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/data", global.DataDir)
+	assert.Equal(t, []string{"laps", "list"}, rest)
+
+	global, rest, err = ParseGlobalFlags([]string{"-data-dir=/data", "upload"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/data", global.DataDir)
+	assert.Equal(t, []string{"upload"}, rest)
+
+	_, _, err = ParseGlobalFlags([]string{"--data-dir"})
+	assert.Error(t, err)
+}
+
+func TestDataDirPassedToInit(t *testing.T) {
+	c, appState, _, _ := setupTestCli(t)
+	var options bootstrap.Options
+	c.initApp = func(appName string, o bootstrap.Options) (*state.AppState, error) {
+		options = o
+		return appState, nil
+	}
+
+	assert.Equal(t, 0, c.Run([]string{"--data-dir", "/data", "laps", "list"}))
+	assert.Equal(t, "/data", options.DataDir)
+}
+
+func TestUnknownCommand(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 2, c.Run([]string{"drive"}))
+	assert.Contains(t, stderr.String(), "unknown command 'drive'")
+	assert.Contains(t, stderr.String(), "Usage:")
+}
+
+func TestRunWithoutHeadless(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 2, c.Run([]string{"run"}))
+	assert.Contains(t, stderr.String(), "--headless")
+}
+
+// Helper to save the lap file into the directory
+func saveLap(t *testing.T, dir, file string, lap *message.Lap) {
+	assert.NoError(t, lapfile.Save(filepath.Join(dir, file), lap))
+}
+
+func TestLapsList(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	saveLap(t, appState.UploadDir, "1740000100_monza_ferrari_296_gt3.lap.gzip",
+		&message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 107345, SessionType: 0, Timestamp: 1740000100})
+	saveLap(t, appState.UploadedDir, "1740000000_spa_bmw_m4_gt3.lap.gzip",
+		&message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 138002, SessionType: 2, Timestamp: 1740000000})
+	assert.NoError(t, os.WriteFile(filepath.Join(appState.UploadDir, "notes.txt"), []byte{}, 0644))
+	assert.NoError(t, appState.Library.Rebuild())
+
+	assert.Equal(t, 0, c.Run([]string{"laps", "list"}))
+
+	output := stdout.String()
+	assert.Contains(t, output, "monza  ferrari_296_gt3  1:47.345  practice  pending   1740000100_monza_ferrari_296_gt3.lap.gzip")
+	assert.Contains(t, output, "spa    bmw_m4_gt3       2:18.002  race      uploaded  1740000000_spa_bmw_m4_gt3.lap.gzip")
+	assert.NotContains(t, output, "notes.txt")
+	// older lap goes first
+	assert.Less(t, bytes.Index(stdout.Bytes(), []byte("spa")), bytes.Index(stdout.Bytes(), []byte("monza")))
+}
+
+func TestLapsListFilter(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	saveLap(t, appState.UploadDir, "1740000100_monza_ferrari_296_gt3.lap.gzip",
+		&message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 107345, Timestamp: 1740000100})
+	saveLap(t, appState.UploadDir, "1740000000_spa_bmw_m4_gt3.lap.gzip",
+		&message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 138002, Timestamp: 1740000000})
+	saveLap(t, appState.UploadDir, "1700000000_spa_bmw_m4_gt3.lap.gzip",
+		&message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 139000, Timestamp: 1700000000})
+	assert.NoError(t, appState.Library.Rebuild())
+
+	assert.Equal(t, 0, c.Run([]string{"laps", "list", "--track", "spa", "--from", "2025-01-01"}))
+
+	output := stdout.String()
+	assert.Contains(t, output, "1740000000_spa_bmw_m4_gt3.lap.gzip")
+	assert.NotContains(t, output, "1700000000_spa_bmw_m4_gt3.lap.gzip")
+	assert.NotContains(t, output, "monza")
+}
+
+func TestLapsListInvalidDate(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 2, c.Run([]string{"laps", "list", "--to", "yesterday"}))
+	assert.Contains(t, stderr.String(), "invalid --to date")
+}
+
+func TestLapsBest(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	saveLap(t, appState.UploadDir, "1740000000_spa_bmw_m4_gt3.lap.gzip",
+		&message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 138002, Timestamp: 1740000000})
+	saveLap(t, appState.UploadedDir, "1700000000_spa_bmw_m4_gt3.lap.gzip",
+		&message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 137500, Timestamp: 1700000000})
+	assert.NoError(t, appState.Library.Rebuild())
+
+	assert.Equal(t, 0, c.Run([]string{"laps", "best"}))
+
+	output := stdout.String()
+	assert.Contains(t, output, "2:17.500")
+	assert.NotContains(t, output, "2:18.002")
+}
+
+func TestLapsPersonalBests(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	file := filepath.Join(appState.UploadDir, "1740000000_spa_bmw_m4_gt3.lap.gzip")
+	lap := &message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 138002, Timestamp: 1740000000}
+	assert.NoError(t, lapfile.Save(file, lap))
+	entry, err := appState.Library.Add(file, lap)
+	assert.NoError(t, err)
+	_, _, err = appState.Library.UpdatePersonalBest(entry, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0, c.Run([]string{"laps", "pb"}))
+	assert.Contains(t, stdout.String(), "spa    bmw_m4_gt3  any         2:18.002")
+}
+
+func TestLapsExport(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	lap := &message.Lap{Track: "spa", CarModel: "bmw_m4_gt3", LapTimeMs: 100, Timestamp: 1740000000, Frames: []*message.Frame{
+		{CurrentTime: 0, SpeedKmh: 100},
+		{CurrentTime: 100, SpeedKmh: 110},
+	}}
+	saveLap(t, appState.UploadedDir, "1740000000_spa_bmw_m4_gt3.lap.gzip", lap)
+	assert.NoError(t, appState.Library.Rebuild())
+
+	out := filepath.Join(t.TempDir(), "lap.csv")
+	assert.Equal(t, 0, c.Run([]string{"laps", "export", "--channels", "Speed", "--out", out, "1740000000_spa_bmw_m4_gt3.lap.gzip"}))
+	assert.Contains(t, stdout.String(), "Lap exported to")
+
+	exported, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "Time [s],Speed [km/h]\n0.000,100\n0.100,110\n", string(exported))
+}
+
+func TestLapsImport(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	csvFile := filepath.Join(t.TempDir(), "session.csv")
+	csv := "Time,Lap Position,Speed,Oil Temp\n0,0.01,100,90\n1,0.50,110,90\n2,0.99,120,91\n"
+	assert.NoError(t, os.WriteFile(csvFile, []byte(csv), 0644))
+
+	args := []string{"laps", "import", "--track", "spa", "--car", "bmw_m4_gt3", "--date", "2025-03-01 14:00:00", csvFile}
+	assert.Equal(t, 0, c.Run(args))
+	assert.Contains(t, stdout.String(), "not imported channels: Oil Temp")
+	assert.Contains(t, stdout.String(), "imported 0:02.000")
+
+	laps := appState.Library.Find(library.Query{Track: "spa"})
+	assert.Len(t, laps, 1)
+	assert.Equal(t, int32(2000), laps[0].LapTimeMs)
+	_, err := os.Stat(filepath.Join(appState.UploadDir, laps[0].File))
+	assert.NoError(t, err)
+
+	// the same file is not imported twice
+	assert.Equal(t, 0, c.Run(args))
+	assert.Contains(t, stdout.String(), "was already imported")
+	assert.Len(t, appState.Library.Find(library.Query{Track: "spa"}), 1)
+}
+
+func TestLapsImportWithoutTrack(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	csvFile := filepath.Join(t.TempDir(), "session.csv")
+	assert.NoError(t, os.WriteFile(csvFile, []byte("Time,Speed\n0,100\n1,110\n"), 0644))
+
+	assert.Equal(t, 1, c.Run([]string{"laps", "import", csvFile}))
+	assert.Contains(t, stderr.String(), "the track and the car")
+}
+
+func TestLapsWithoutSubcommand(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 2, c.Run([]string{"laps"}))
+	assert.Contains(t, stderr.String(), "usage: laps list")
+}
+
+func TestUploadNotLoggedIn(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 1, c.Run([]string{"upload"}))
+	assert.Contains(t, stderr.String(), "not logged in")
+}
+
+func TestUploadToFolderNotLoggedIn(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+	folder := t.TempDir()
+	appState.UploadDestinations = []state.UploadDestination{{Type: state.DESTINATION_FOLDER, Path: folder}}
+	saveLap(t, appState.UploadDir, "1740000100_monza_ferrari_296_gt3.lap.gzip", &message.Lap{Track: "monza", LapTimeMs: 107345})
+
+	assert.Equal(t, 0, c.Run([]string{"upload"}))
+	assert.Contains(t, stdout.String(), "Uploaded 1 laps")
+	assert.FileExists(t, filepath.Join(folder, "1740000100_monza_ferrari_296_gt3.lap.gzip"))
+}
+
+func TestLoginDeviceWithoutIssuer(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 1, c.Run([]string{"login", "--device"}))
+	assert.Contains(t, stderr.String(), "needs the OpenID Connect provider")
+}
+
+func TestLogout(t *testing.T) {
+	c, appState, stdout, _ := setupTestCli(t)
+
+	userFile := filepath.Join(appState.DataDir, "auth", "user.json")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(userFile), 0700))
+	assert.NoError(t, os.WriteFile(userFile, []byte("{}"), 0600))
+
+	assert.Equal(t, 0, c.Run([]string{"logout"}))
+	assert.Contains(t, stdout.String(), "Logged out")
+	_, err := os.Stat(userFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	c, _, _, stderr := setupTestCli(t)
+
+	assert.Equal(t, 2, c.Run([]string{"replay"}))
+	assert.Equal(t, 1, c.Run([]string{"replay", "--speed", "10", "missing.rec.gzip"}))
+	assert.Contains(t, stderr.String(), "failed to open the recording")
+}
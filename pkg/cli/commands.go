@@ -0,0 +1,460 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/acc"
+	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/channels"
+	"github.com/sparkoo/racemate-desktop/pkg/export"
+	"github.com/sparkoo/racemate-desktop/pkg/importer"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/sparkoo/racemate-desktop/pkg/upload"
+	"github.com/sparkoo/racemate-desktop/pkg/webserver"
+)
+
+// runHeadless runs the telemetry loop and the upload job until interrupted
+func (c *Cli) runHeadless(args []string) error {
+	flags := c.newFlagSet("run")
+	headless := flags.Bool("headless", false, "run without the window")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if !*headless {
+		return usageError("the window can't be started from here, use --headless")
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext(appState)
+	defer cancel()
+
+	appState.Logger.Info("Running headless, press Ctrl+C to stop")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		acc.TelemetryLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		if err := upload.UploadJob(ctx); err != nil {
+			appState.Logger.Error("Upload job failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	appState.Logger.Info("Stopping")
+	wg.Wait()
+	return nil
+}
+
+// upload uploads all waiting laps right away
+func (c *Cli) upload(args []string) error {
+	if err := parseFlags(c.newFlagSet("upload"), args); err != nil {
+		return err
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	if upload.NeedsLogin(appState) && !auth.NewAuthManager(appState).IsLoggedIn() {
+		return fmt.Errorf("not logged in, run '%s login' first", c.appName)
+	}
+
+	uploaded, err := upload.UploadAll(appState)
+	fmt.Fprintf(c.stdout, "Uploaded %d laps\n", uploaded)
+	return err
+}
+
+// login opens the login page and waits until the user logs in or the login server times out
+func (c *Cli) login(args []string) error {
+	flags := c.newFlagSet("login")
+	port := flags.Int("port", 0, "port of the local login server (default from the settings)")
+	issuer := flags.String("issuer", "", "OpenID Connect provider to log in with instead of Firebase (default from the settings)")
+	clientID := flags.String("client-id", "", "client ID at the OpenID Connect provider (default from the settings)")
+	device := flags.Bool("device", false, "log in on another device with the code, for the machines without a browser (needs the OpenID Connect provider)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	authManager := auth.NewAuthManager(appState)
+	if authManager.IsLoggedIn() {
+		user, _ := authManager.GetCurrentUser()
+		fmt.Fprintf(c.stdout, "Already logged in as %s\n", user.DisplayName)
+		return nil
+	}
+
+	ctx, cancel := signalContext(appState)
+	defer cancel()
+
+	if *issuer == "" {
+		*issuer = appState.OIDCIssuer
+	}
+	if *clientID == "" {
+		*clientID = appState.OIDCClientID
+	}
+	if *device {
+		if *issuer == "" {
+			return fmt.Errorf("device login needs the OpenID Connect provider, set --issuer or oidcIssuer in the settings")
+		}
+		user, err := authManager.LoginWithDeviceCode(ctx, auth.OAuthConfig{Issuer: *issuer, ClientID: *clientID},
+			func(code auth.DeviceCode) {
+				fmt.Fprintf(c.stdout, "To log in, open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+				if code.VerificationURIComplete != "" {
+					fmt.Fprintf(c.stdout, "or open %s\n", code.VerificationURIComplete)
+				}
+			})
+		if err != nil {
+			return fmt.Errorf("login failed: %w", err)
+		}
+		fmt.Fprintf(c.stdout, "Logged in as %s\n", user.DisplayName)
+		return nil
+	}
+	if *issuer != "" {
+		user, err := authManager.LoginWithOAuth(ctx, auth.OAuthConfig{Issuer: *issuer, ClientID: *clientID},
+			(&browserOpener{c: c}).OpenURL)
+		if err != nil {
+			return fmt.Errorf("login failed: %w", err)
+		}
+		fmt.Fprintf(c.stdout, "Logged in as %s\n", user.DisplayName)
+		return nil
+	}
+
+	if *port == 0 {
+		*port = appState.WebServerPort
+	}
+	server := webserver.NewServer(*port, &browserOpener{c: c})
+	server.SetAuthManager(appState)
+	if err := server.Start(); err != nil {
+		return fmt.Errorf("failed to start login server: %w", err)
+	}
+
+	// the server stops itself after successful login or when it times out
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for server.IsActive() {
+		select {
+		case <-ctx.Done():
+			if err := server.Stop(); err != nil {
+				appState.Logger.Error("Error stopping login server", "error", err)
+			}
+			return fmt.Errorf("login interrupted")
+		case <-ticker.C:
+		}
+	}
+
+	user, err := auth.NewAuthManager(appState).GetCurrentUser()
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("login was not completed")
+	}
+	fmt.Fprintf(c.stdout, "Logged in as %s\n", user.DisplayName)
+	return nil
+}
+
+func (c *Cli) logout(args []string) error {
+	if err := parseFlags(c.newFlagSet("logout"), args); err != nil {
+		return err
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	if err := auth.NewAuthManager(appState).Logout(); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, "Logged out")
+	return nil
+}
+
+func (c *Cli) laps(args []string) error {
+	if len(args) > 0 && args[0] == "pb" {
+		return c.personalBests(args[1:])
+	}
+	if len(args) > 0 && args[0] == "export" {
+		return c.exportLap(args[1:])
+	}
+	if len(args) > 0 && args[0] == "import" {
+		return c.importLaps(args[1:])
+	}
+	if len(args) == 0 || (args[0] != "list" && args[0] != "best") {
+		return usageError("usage: laps list|best|pb|export|import")
+	}
+
+	flags := c.newFlagSet("laps " + args[0])
+	query := library.Query{}
+	flags.StringVar(&query.Track, "track", "", "show only laps on the track")
+	flags.StringVar(&query.CarModel, "car", "", "show only laps with the car")
+	from := flags.String("from", "", "show only laps recorded since the date (YYYY-MM-DD)")
+	to := flags.String("to", "", "show only laps recorded until the date including (YYYY-MM-DD)")
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	if *from != "" {
+		date, err := time.ParseInLocation(time.DateOnly, *from, time.Local)
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid --from date '%s', use YYYY-MM-DD", *from))
+		}
+		query.From = date
+	}
+	if *to != "" {
+		date, err := time.ParseInLocation(time.DateOnly, *to, time.Local)
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid --to date '%s', use YYYY-MM-DD", *to))
+		}
+		query.To = date.AddDate(0, 0, 1)
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "best" {
+		return c.printBestLaps(appState.Library, appState.Library.BestLaps(query))
+	}
+	return c.printLaps(appState.Library.Find(query))
+}
+
+// printBestLaps prints the best laps with the theoretical best made of the best sectors
+func (c *Cli) printBestLaps(lib *library.Library, laps []library.Entry) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TRACK\tCAR\tLAP TIME\tTHEORETICAL\tRECORDED\tFILE")
+	for _, lap := range laps {
+		theoretical := "-"
+		if best, found := lib.TheoreticalBest(lap.Track, lap.CarModel); found {
+			theoretical = library.FormatLapTime(best.LapTimeMs)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", lap.Track, lap.CarModel, library.FormatLapTime(lap.LapTimeMs),
+			theoretical, lap.RecordedAt.Local().Format(time.DateTime), lap.File)
+	}
+	return w.Flush()
+}
+
+func (c *Cli) printLaps(laps []library.Entry) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RECORDED\tTRACK\tCAR\tLAP TIME\tSESSION\tSTATUS\tFILE")
+	for _, lap := range laps {
+		status := "pending"
+		if lap.Uploaded {
+			status = "uploaded"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			lap.RecordedAt.Local().Format(time.DateTime), lap.Track, lap.CarModel,
+			library.FormatLapTime(lap.LapTimeMs), library.SessionTypeName(lap.SessionType), status, lap.File)
+	}
+	return w.Flush()
+}
+
+// personalBests prints the recorded personal bests
+func (c *Cli) personalBests(args []string) error {
+	if err := parseFlags(c.newFlagSet("laps pb"), args); err != nil {
+		return err
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TRACK\tCAR\tCONDITIONS\tLAP TIME\tSET")
+	for _, pb := range appState.Library.PersonalBests() {
+		conditions := pb.Conditions
+		if conditions == "" {
+			conditions = "any"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pb.Track, pb.CarModel, conditions,
+			library.FormatLapTime(pb.LapTimeMs), pb.SetAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+// exportLap exports the lap to the format of other analysis tools
+func (c *Cli) exportLap(args []string) error {
+	flags := c.newFlagSet("laps export")
+	format := flags.String("format", string(export.FORMAT_CSV), "export format, csv or ld (MoTeC i2)")
+	channelNames := flags.String("channels", "", "comma separated channels to export (default "+strings.Join(channels.DEFAULT, ", ")+")")
+	timeBase := flags.String("time-base", string(export.TIME_BASE_TIME), "first CSV column, time or distance")
+	frequency := flags.Int("frequency", 0, "MoTeC sample rate in Hz (default the rate of the recorded frames)")
+	out := flags.String("out", "", "output file (default the lap file name with the format extension)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("usage: laps export [--format csv|ld] [--channels LIST] [--time-base time|distance] [--frequency HZ] [--out FILE] LAP")
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	// the lap can be given by the path or by the file name from the laps list
+	lapPath := flags.Arg(0)
+	if entry, found := appState.Library.Get(lapPath); found {
+		lapPath = appState.Library.Path(entry)
+	}
+	lap, err := lapfile.Load(lapPath)
+	if err != nil {
+		return err
+	}
+
+	options := export.Options{Format: export.Format(*format), TimeBase: export.TimeBase(*timeBase), Frequency: *frequency}
+	if *channelNames != "" {
+		options.Channels = strings.Split(*channelNames, ",")
+	}
+	outFile := *out
+	if outFile == "" {
+		outFile = strings.TrimSuffix(filepath.Base(lapPath), lapfile.SUFFIX) + "." + *format
+	}
+	if err := export.ToFile(lap, outFile, options); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Lap exported to %s\n", outFile)
+	return nil
+}
+
+// importLaps imports the laps recorded by other loggers into the upload directory
+func (c *Cli) importLaps(args []string) error {
+	flags := c.newFlagSet("laps import")
+	mappingFile := flags.String("mapping", "", "channel mapping file (default "+importer.MAPPING_FILE+" in the data directory)")
+	options := importer.Options{}
+	flags.StringVar(&options.Track, "track", "", "track of the laps, required for CSV files")
+	flags.StringVar(&options.CarModel, "car", "", "car of the laps, required for CSV files")
+	date := flags.String("date", "", "when the file was recorded, YYYY-MM-DD HH:MM:SS (default from the file)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageError("usage: laps import [--mapping FILE] [--track T] [--car C] [--date DATE] FILE...")
+	}
+	if *date != "" {
+		parsed, err := time.ParseInLocation(time.DateTime, *date, time.Local)
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid --date '%s', use YYYY-MM-DD HH:MM:SS", *date))
+		}
+		options.Date = parsed
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	if *mappingFile == "" {
+		*mappingFile = filepath.Join(appState.DataDir, importer.MAPPING_FILE)
+	}
+	mapping, err := importer.LoadMapping(*mappingFile)
+	if err != nil {
+		return err
+	}
+	options.Mapping = mapping
+
+	for _, file := range flags.Args() {
+		result, err := importer.FromFile(file, options)
+		if err != nil {
+			return fmt.Errorf("failed to import '%s': %w", file, err)
+		}
+		if len(result.Unmapped) > 0 {
+			fmt.Fprintf(c.stdout, "%s: not imported channels: %s\n", file, strings.Join(result.Unmapped, ", "))
+		}
+		if len(result.Derived) > 0 {
+			fmt.Fprintf(c.stdout, "%s: computed channels: %s\n", file, strings.Join(result.Derived, ", "))
+		}
+		for _, lap := range result.Laps {
+			name := lapfile.Name(lap)
+			if _, found := appState.Library.Get(name); found {
+				fmt.Fprintf(c.stdout, "%s: %s was already imported\n", file, name)
+				continue
+			}
+			lapPath := filepath.Join(appState.UploadDir, name)
+			if err := lapfile.Save(lapPath, lap); err != nil {
+				return err
+			}
+			if _, err := appState.Library.Add(lapPath, lap); err != nil {
+				appState.Logger.Warn("Failed to add the lap to the library", "error", err)
+			}
+			fmt.Fprintf(c.stdout, "%s: imported %s %s\n", file, library.FormatLapTime(lap.LapTimeMs), name)
+		}
+	}
+	return nil
+}
+
+// replay replays the recorded session. Laps are saved into separate directory so they are not uploaded, and
+// they are kept out of the library and personal bests.
+func (c *Cli) replay(args []string) error {
+	flags := c.newFlagSet("replay")
+	speed := flags.Float64("speed", 1, "replay speed, 1 is the original speed")
+	out := flags.String("out", "", "directory where to save replayed laps (default <data dir>/replay)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("usage: replay [--speed N] [--out DIR] FILE")
+	}
+
+	appState, err := c.appState()
+	if err != nil {
+		return err
+	}
+
+	outDir := *out
+	if outDir == "" {
+		outDir = filepath.Join(appState.DataDir, "replay")
+	}
+
+	ctx, cancel := signalContext(appState)
+	defer cancel()
+
+	if err := acc.Replay(ctx, flags.Arg(0), outDir, *speed); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Replay finished, laps were saved to %s\n", outDir)
+	return nil
+}
+
+// browserOpener prints the login URL and tries to open it in the default browser
+type browserOpener struct {
+	c *Cli
+}
+
+func (b *browserOpener) OpenURL(u *url.URL) error {
+	fmt.Fprintf(b.c.stdout, "Open %s in your browser to log in\n", u)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u.String())
+	case "darwin":
+		cmd = exec.Command("open", u.String())
+	default:
+		cmd = exec.Command("xdg-open", u.String())
+	}
+	return cmd.Start()
+}
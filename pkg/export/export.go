@@ -0,0 +1,194 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/channels"
+	"github.com/sparkoo/racemate-desktop/pkg/library"
+	"github.com/sparkoo/racemate-desktop/pkg/motec"
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// TimeBase is the first column of the CSV export
+type TimeBase string
+
+const (
+	// TIME_BASE_TIME is the lap time in seconds
+	TIME_BASE_TIME TimeBase = "time"
+	// TIME_BASE_DISTANCE is the distance in meters driven from the first frame, computed from the car coordinates
+	TIME_BASE_DISTANCE TimeBase = "distance"
+)
+
+// CSVOptions configure the CSV export
+type CSVOptions struct {
+	// Channels to export, empty exports the default channels
+	Channels []string
+	TimeBase TimeBase
+}
+
+// CSV writes the lap as CSV with one row per frame. The header contains channel names with units, e.g. Speed [km/h].
+func CSV(w io.Writer, lap *message.Lap, options CSVOptions) error {
+	exported, err := channels.LookupAll(options.Channels)
+	if err != nil {
+		return err
+	}
+
+	var base []float64
+	header := make([]string, 0, len(exported)+1)
+	switch options.TimeBase {
+	case TIME_BASE_TIME, "":
+		header = append(header, columnName("Time", "s"))
+		for _, frame := range lap.Frames {
+			base = append(base, float64(frame.CurrentTime)/1000)
+		}
+	case TIME_BASE_DISTANCE:
+		header = append(header, columnName("Distance", "m"))
+		base = distances(lap.Frames)
+	default:
+		return fmt.Errorf("unknown time base '%s', use %s or %s", options.TimeBase, TIME_BASE_TIME, TIME_BASE_DISTANCE)
+	}
+	for _, channel := range exported {
+		header = append(header, columnName(channel.Name, channel.Unit))
+	}
+
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	row := make([]string, len(header))
+	for i, frame := range lap.Frames {
+		row[0] = strconv.FormatFloat(base[i], 'f', 3, 64)
+		for j, channel := range exported {
+			row[j+1] = strconv.FormatFloat(channel.Get(frame), 'f', -1, 32)
+		}
+		if err := csvWriter.Write(row); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// MoTeCOptions configure the MoTeC export
+type MoTeCOptions struct {
+	// Channels to export, empty exports the default channels
+	Channels []string
+	// Frequency in Hz the frames are resampled to, 0 picks the frequency of the frames
+	Frequency int
+}
+
+// MoTeC writes the lap as MoTeC i2 .ld file. MoTeC channels have fixed frequency, so the frames are resampled
+// by the lap time.
+func MoTeC(w io.Writer, lap *message.Lap, options MoTeCOptions) error {
+	exported, err := channels.LookupAll(options.Channels)
+	if err != nil {
+		return err
+	}
+	frames := timeOrdered(lap.Frames)
+	if len(frames) < 2 {
+		return fmt.Errorf("lap has not enough frames to export")
+	}
+
+	start := float64(frames[0].CurrentTime) / 1000
+	duration := float64(frames[len(frames)-1].CurrentTime)/1000 - start
+	frequency := options.Frequency
+	if frequency == 0 {
+		frequency = int(math.Max(1, math.Round(float64(len(frames)-1)/duration)))
+	}
+	if frequency < 1 || frequency > math.MaxUint16 {
+		return fmt.Errorf("frequency must be between 1 and %d Hz, got %d", math.MaxUint16, frequency)
+	}
+
+	samples := int(duration*float64(frequency)) + 1
+	file := &motec.File{
+		Date:         time.Unix(int64(lap.Timestamp), 0),
+		Driver:       driverName(lap),
+		VehicleID:    lap.CarModel,
+		Venue:        lap.Track,
+		ShortComment: fmt.Sprintf("Lap %d", lap.LapNumber),
+		Event:        "RaceMate",
+		Session:      library.SessionTypeName(lap.SessionType),
+		Comment:      fmt.Sprintf("Lap time %d ms", lap.LapTimeMs),
+	}
+	for _, channel := range exported {
+		data := make([]float32, samples)
+		next := 1
+		for i := range data {
+			t := start + float64(i)/float64(frequency)
+			for next < len(frames)-1 && float64(frames[next].CurrentTime)/1000 < t {
+				next++
+			}
+			from, to := frames[next-1], frames[next]
+			data[i] = float32(interpolate(channel, from, to, t))
+		}
+		file.Channels = append(file.Channels, motec.Channel{
+			Name:      channel.Name,
+			ShortName: channel.ShortName,
+			Unit:      channel.Unit,
+			Frequency: uint16(frequency),
+			Data:      data,
+		})
+	}
+
+	return motec.Write(w, file)
+}
+
+// interpolate returns the channel value at time t between the frames, discrete channels keep the value of the
+// frame before
+func interpolate(channel channels.Channel, from, to *message.Frame, t float64) float64 {
+	fromTime, toTime := float64(from.CurrentTime)/1000, float64(to.CurrentTime)/1000
+	switch {
+	case t >= toTime:
+		return channel.Get(to)
+	case channel.Discrete || t <= fromTime:
+		return channel.Get(from)
+	}
+	ratio := (t - fromTime) / (toTime - fromTime)
+	return channel.Get(from) + (channel.Get(to)-channel.Get(from))*ratio
+}
+
+// timeOrdered returns the frames with increasing lap time, frames from before the lap started are skipped
+func timeOrdered(frames []*message.Frame) []*message.Frame {
+	ordered := make([]*message.Frame, 0, len(frames))
+	for _, frame := range frames {
+		if len(ordered) > 0 && frame.CurrentTime <= ordered[len(ordered)-1].CurrentTime {
+			continue
+		}
+		ordered = append(ordered, frame)
+	}
+	return ordered
+}
+
+// distances returns the distance driven at every frame computed from the car coordinates
+func distances(frames []*message.Frame) []float64 {
+	result := make([]float64, len(frames))
+	for i := 1; i < len(frames); i++ {
+		dx := float64(frames[i].CarCoordinateX - frames[i-1].CarCoordinateX)
+		dy := float64(frames[i].CarCoordinateY - frames[i-1].CarCoordinateY)
+		dz := float64(frames[i].CarCoordinateZ - frames[i-1].CarCoordinateZ)
+		result[i] = result[i-1] + math.Sqrt(dx*dx+dy*dy+dz*dz)
+	}
+	return result
+}
+
+func columnName(name, unit string) string {
+	if unit == "" {
+		return name
+	}
+	return fmt.Sprintf("%s [%s]", name, unit)
+}
+
+func driverName(lap *message.Lap) string {
+	if lap.PlayerName == "" && lap.PlayerSurname == "" {
+		return lap.PlayerNick
+	}
+	if lap.PlayerSurname == "" {
+		return lap.PlayerName
+	}
+	return lap.PlayerName + " " + lap.PlayerSurname
+}
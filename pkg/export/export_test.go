@@ -0,0 +1,126 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a lap driven along the X axis at 36 km/h, frames every 100ms
+func straightLap(count int) *message.Lap {
+	lap := &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: int32(count-1) * 100, Timestamp: 1740000000}
+	for i := 0; i < count; i++ {
+		lap.Frames = append(lap.Frames, &message.Frame{
+			CurrentTime:           int32(i) * 100,
+			NormalizedCarPosition: float32(i) / float32(count),
+			SpeedKmh:              36,
+			Gas:                   1,
+			Gear:                  int32(2 + i/5),
+			CarCoordinateX:        float32(i),
+		})
+	}
+	return lap
+}
+
+func TestCSV(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := CSV(buf, straightLap(3), CSVOptions{Channels: []string{"Speed", "Throttle Pos", "Gear"}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Time [s],Speed [km/h],Throttle Pos [%],Gear\n"+
+		"0.000,36,100,2\n"+
+		"0.100,36,100,2\n"+
+		"0.200,36,100,2\n", buf.String())
+}
+
+func TestCSVDistance(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := CSV(buf, straightLap(3), CSVOptions{Channels: []string{"Speed"}, TimeBase: TIME_BASE_DISTANCE})
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "Distance [m],Speed [km/h]", lines[0])
+	assert.Equal(t, "2.000,36", lines[3])
+}
+
+func TestCSVDefaultChannels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, CSV(buf, straightLap(3), CSVOptions{}))
+	header := strings.SplitN(buf.String(), "\n", 2)[0]
+	assert.True(t, strings.HasPrefix(header, "Time [s],Lap Time [s],Lap Position,Speed [km/h]"))
+}
+
+func TestCSVInvalidOptions(t *testing.T) {
+	assert.Error(t, CSV(&bytes.Buffer{}, straightLap(3), CSVOptions{Channels: []string{"Oil Temp"}}))
+	assert.Error(t, CSV(&bytes.Buffer{}, straightLap(3), CSVOptions{TimeBase: "lap"}))
+}
+
+// Helper to read the data of the channel from the exported .ld file
+func ldChannelData(t *testing.T, data []byte, index int) (uint16, []float32) {
+	metaPtr := binary.LittleEndian.Uint32(data[8:])
+	meta := data[int(metaPtr)+index*124:]
+	dataPtr := binary.LittleEndian.Uint32(meta[8:])
+	dataLen := binary.LittleEndian.Uint32(meta[12:])
+	frequency := binary.LittleEndian.Uint16(meta[22:])
+
+	values := make([]float32, dataLen)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[int(dataPtr)+i*4:]))
+	}
+	return frequency, values
+}
+
+func TestMoTeCResamples(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := MoTeC(buf, straightLap(11), MoTeCOptions{Channels: []string{"Car Pos X", "Gear"}, Frequency: 20})
+	assert.NoError(t, err)
+
+	frequency, x := ldChannelData(t, buf.Bytes(), 0)
+	assert.Equal(t, uint16(20), frequency)
+	assert.Len(t, x, 21)
+	assert.Equal(t, float32(0.5), x[1])
+	assert.Equal(t, float32(10), x[20])
+
+	// gear is not interpolated
+	_, gear := ldChannelData(t, buf.Bytes(), 1)
+	assert.Equal(t, float32(2), gear[9])
+	assert.Equal(t, float32(3), gear[10])
+}
+
+func TestMoTeCFrequencyOfFrames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, MoTeC(buf, straightLap(11), MoTeCOptions{}))
+
+	frequency, values := ldChannelData(t, buf.Bytes(), 0)
+	assert.Equal(t, uint16(10), frequency)
+	assert.Len(t, values, 11)
+}
+
+func TestMoTeCNotEnoughFrames(t *testing.T) {
+	assert.Error(t, MoTeC(&bytes.Buffer{}, straightLap(1), MoTeCOptions{}))
+}
+
+func TestToFile(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "lap.ld")
+	assert.NoError(t, ToFile(straightLap(11), file, Options{Format: FORMAT_MOTEC}))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Positive(t, info.Size())
+
+	// failed export leaves no file
+	failed := filepath.Join(dir, "failed.csv")
+	assert.Error(t, ToFile(straightLap(11), failed, Options{Format: FORMAT_CSV, Channels: []string{"Oil Temp"}}))
+	_, err = os.Stat(failed)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Error(t, ToFile(straightLap(11), filepath.Join(dir, "lap.xls"), Options{Format: "xls"}))
+}
@@ -0,0 +1,53 @@
+package export
+
+import (
+	"fmt"
+	"os"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// Format is the format of the exported file, it is also the file extension
+type Format string
+
+const (
+	FORMAT_CSV   Format = "csv"
+	FORMAT_MOTEC Format = "ld"
+)
+
+// Options configure the export to the file
+type Options struct {
+	Format Format
+	// Channels to export, empty exports the default channels
+	Channels []string
+	// TimeBase of the CSV export
+	TimeBase TimeBase
+	// Frequency of the MoTeC export
+	Frequency int
+}
+
+// ToFile exports the lap into the file in given format
+func ToFile(lap *message.Lap, filename string, options Options) error {
+	if options.Format != FORMAT_CSV && options.Format != FORMAT_MOTEC {
+		return fmt.Errorf("unknown export format '%s', use %s or %s", options.Format, FORMAT_CSV, FORMAT_MOTEC)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer f.Close()
+
+	if options.Format == FORMAT_CSV {
+		err = CSV(f, lap, CSVOptions{Channels: options.Channels, TimeBase: options.TimeBase})
+	} else {
+		err = MoTeC(f, lap, MoTeCOptions{Channels: options.Channels, Frequency: options.Frequency})
+	}
+	if err != nil {
+		// don't leave half written file behind
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,296 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/motec"
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// Options of the import
+type Options struct {
+	Mapping *Mapping
+	// Track, CarModel and Date override the values from the imported file, CSV files have none of them
+	Track    string
+	CarModel string
+	Date     time.Time
+}
+
+// Result is the outcome of the import
+type Result struct {
+	Laps []*message.Lap
+	// Unmapped are the channels of the file that were not imported
+	Unmapped []string
+	// Derived are the channels missing in the file that were computed from the other channels
+	Derived []string
+}
+
+// sample is the frame with the time since the start of the file
+type sample struct {
+	seconds float64
+	frame   *message.Frame
+}
+
+// FromFile imports the .ld or .csv file. Date of the file is the modification time when the file has none.
+func FromFile(filename string, options Options) (*Result, error) {
+	if options.Date.IsZero() && strings.EqualFold(filepath.Ext(filename), ".csv") {
+		if info, err := os.Stat(filename); err == nil {
+			options.Date = info.ModTime()
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ld":
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the file: %w", err)
+		}
+		return FromMoTeC(data, options)
+	case ".csv":
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the file: %w", err)
+		}
+		defer f.Close()
+		return FromCSV(f, options)
+	}
+	return nil, fmt.Errorf("unknown file type '%s', import .ld or .csv files", filepath.Ext(filename))
+}
+
+// FromMoTeC imports the MoTeC i2 .ld file. Channels are sampled at the highest frequency of the mapped channels.
+func FromMoTeC(data []byte, options Options) (*Result, error) {
+	file, err := motec.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	if options.Track == "" {
+		options.Track = file.Venue
+	}
+	if options.CarModel == "" {
+		options.CarModel = file.VehicleID
+	}
+	if options.Date.IsZero() {
+		options.Date = file.Date
+	}
+
+	mapping := options.mapping()
+	result := &Result{}
+	mapped := map[string]bool{}
+	var sources []motec.Channel
+	var targets []mappedChannel
+	frequency := 0
+	for _, channel := range file.Channels {
+		target, found := mapping.resolve(channel.Name)
+		if !found {
+			target, found = mapping.resolve(channel.ShortName)
+		}
+		if !found || mapped[target.channel.Name] || channel.Frequency == 0 || len(channel.Data) == 0 {
+			result.Unmapped = append(result.Unmapped, channel.Name)
+			continue
+		}
+		mapped[target.channel.Name] = true
+		sources = append(sources, channel)
+		targets = append(targets, target)
+		frequency = max(frequency, int(channel.Frequency))
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no channel of the file is mapped")
+	}
+
+	count := 0
+	for _, source := range sources {
+		count = max(count, len(source.Data)*frequency/int(source.Frequency))
+	}
+	samples := make([]sample, count)
+	for i := range samples {
+		seconds := float64(i) / float64(frequency)
+		frame := &message.Frame{}
+		for j, source := range sources {
+			index := min(int(seconds*float64(source.Frequency)), len(source.Data)-1)
+			targets[j].apply(frame, float64(source.Data[index]))
+		}
+		samples[i] = sample{seconds: seconds, frame: frame}
+	}
+
+	return result, result.buildLaps(samples, mapped, options)
+}
+
+// FromCSV imports the CSV file with the header row and the time column in seconds
+func FromCSV(r io.Reader, options Options) (*Result, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	mapping := options.mapping()
+	result := &Result{}
+	mapped := map[string]bool{}
+	timeColumn := -1
+	timeScale := 1.0
+	targets := map[int]mappedChannel{}
+	for i, column := range header {
+		if timeColumn < 0 && strings.EqualFold(stripUnit(column), mapping.TimeColumn) {
+			timeColumn = i
+			if strings.HasSuffix(column, "[ms]") {
+				timeScale = 0.001
+			}
+			continue
+		}
+		target, found := mapping.resolve(column)
+		if !found || mapped[target.channel.Name] {
+			result.Unmapped = append(result.Unmapped, column)
+			continue
+		}
+		mapped[target.channel.Name] = true
+		targets[i] = target
+	}
+	if timeColumn < 0 {
+		return nil, fmt.Errorf("CSV has no time column '%s'", mapping.TimeColumn)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no column of the file is mapped")
+	}
+
+	var samples []sample
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row: %w", err)
+		}
+		if timeColumn >= len(row) {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(row[timeColumn]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time '%s' on line %d", row[timeColumn], len(samples)+2)
+		}
+		frame := &message.Frame{}
+		for i, target := range targets {
+			if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s' of '%s' on line %d", row[i], header[i], len(samples)+2)
+			}
+			target.apply(frame, value)
+		}
+		samples = append(samples, sample{seconds: seconds * timeScale, frame: frame})
+	}
+
+	return result, result.buildLaps(samples, mapped, options)
+}
+
+// buildLaps derives the missing channels and splits the samples into the laps
+func (r *Result) buildLaps(samples []sample, mapped map[string]bool, options Options) error {
+	if options.Track == "" || options.CarModel == "" {
+		return fmt.Errorf("the file doesn't say the track and the car, they must be set")
+	}
+	if options.Date.IsZero() {
+		return fmt.Errorf("the file doesn't say when it was recorded, the date must be set")
+	}
+	if len(samples) < 2 {
+		return fmt.Errorf("the file has not enough samples")
+	}
+
+	var laps [][]sample
+	if mapped["Lap Position"] {
+		laps = completeLaps(samples)
+		if len(laps) == 0 {
+			return fmt.Errorf("the file has no complete lap")
+		}
+	} else {
+		// without the position we can't tell where the laps start, the whole file is taken as a single lap
+		derivePositions(samples, mapped)
+		r.Derived = append(r.Derived, "Lap Position")
+		laps = [][]sample{samples}
+	}
+	if !mapped["Lap Time"] {
+		r.Derived = append(r.Derived, "Lap Time")
+	}
+
+	for _, lapSamples := range laps {
+		lap := &message.Lap{
+			Track:     options.Track,
+			CarModel:  options.CarModel,
+			Timestamp: uint64(options.Date.Add(time.Duration(lapSamples[0].seconds * float64(time.Second))).Unix()),
+		}
+		for _, s := range lapSamples {
+			if !mapped["Lap Time"] {
+				s.frame.CurrentTime = int32(math.Round((s.seconds - lapSamples[0].seconds) * 1000))
+			}
+			lap.Frames = append(lap.Frames, s.frame)
+		}
+		lap.LapTimeMs = lap.Frames[len(lap.Frames)-1].CurrentTime
+		r.Laps = append(r.Laps, lap)
+	}
+	sort.Strings(r.Unmapped)
+	return nil
+}
+
+// completeLaps splits the samples where the position goes back to the start and returns the laps
+// driven from the start to the finish line
+func completeLaps(samples []sample) [][]sample {
+	var laps [][]sample
+	start := 0
+	for i := 1; i <= len(samples); i++ {
+		if i < len(samples) && samples[i].frame.NormalizedCarPosition-samples[i-1].frame.NormalizedCarPosition > -0.5 {
+			continue
+		}
+		lap := samples[start:i]
+		if lap[0].frame.NormalizedCarPosition < 0.05 && lap[len(lap)-1].frame.NormalizedCarPosition > 0.95 {
+			laps = append(laps, lap)
+		}
+		start = i
+	}
+	return laps
+}
+
+// derivePositions sets the position as the fraction of the distance driven when there are coordinates,
+// or as the fraction of the time
+func derivePositions(samples []sample, mapped map[string]bool) {
+	progress := make([]float64, len(samples))
+	if mapped["Car Pos X"] || mapped["Car Pos Y"] || mapped["Car Pos Z"] {
+		for i := 1; i < len(samples); i++ {
+			from, to := samples[i-1].frame, samples[i].frame
+			dx := float64(to.CarCoordinateX - from.CarCoordinateX)
+			dy := float64(to.CarCoordinateY - from.CarCoordinateY)
+			dz := float64(to.CarCoordinateZ - from.CarCoordinateZ)
+			progress[i] = progress[i-1] + math.Sqrt(dx*dx+dy*dy+dz*dz)
+		}
+	}
+	if progress[len(progress)-1] == 0 {
+		for i, s := range samples {
+			progress[i] = s.seconds - samples[0].seconds
+		}
+	}
+	total := progress[len(progress)-1]
+	if total == 0 {
+		return
+	}
+	for i, s := range samples {
+		s.frame.NormalizedCarPosition = float32(progress[i] / total)
+	}
+}
+
+func (o Options) mapping() *Mapping {
+	if o.Mapping == nil {
+		return DefaultMapping()
+	}
+	return o.Mapping
+}
@@ -0,0 +1,179 @@
+package importer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/export"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a lap from the start to the finish line, frames every 100ms
+func fullLap(count int) *message.Lap {
+	lap := &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: int32(count-1) * 100, Timestamp: 1740000000}
+	for i := 0; i < count; i++ {
+		lap.Frames = append(lap.Frames, &message.Frame{
+			CurrentTime:           int32(i) * 100,
+			NormalizedCarPosition: float32(i) / float32(count-1),
+			SpeedKmh:              float32(100 + i),
+			Gas:                   0.5,
+			Gear:                  3,
+		})
+	}
+	return lap
+}
+
+func TestFromMoTeC(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, export.MoTeC(buf, fullLap(11), export.MoTeCOptions{}))
+
+	result, err := FromMoTeC(buf.Bytes(), Options{})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Unmapped)
+	assert.Empty(t, result.Derived)
+	assert.Len(t, result.Laps, 1)
+
+	lap := result.Laps[0]
+	assert.Equal(t, "monza", lap.Track)
+	assert.Equal(t, "ferrari_296_gt3", lap.CarModel)
+	assert.Equal(t, uint64(1740000000), lap.Timestamp)
+	assert.Equal(t, int32(1000), lap.LapTimeMs)
+	assert.Len(t, lap.Frames, 11)
+	assert.Equal(t, int32(500), lap.Frames[5].CurrentTime)
+	assert.InDelta(t, 105, lap.Frames[5].SpeedKmh, 0.01)
+	assert.InDelta(t, 0.5, lap.Frames[5].Gas, 0.01)
+	assert.Equal(t, int32(3), lap.Frames[5].Gear)
+}
+
+func TestFromCSV(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := export.CSV(buf, fullLap(11), export.CSVOptions{Channels: []string{"Lap Position", "Speed", "Gear"}})
+	assert.NoError(t, err)
+
+	date := time.Unix(1740000000, 0)
+	result, err := FromCSV(buf, Options{Track: "monza", CarModel: "ferrari_296_gt3", Date: date})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Lap Time"}, result.Derived)
+	assert.Len(t, result.Laps, 1)
+
+	lap := result.Laps[0]
+	assert.Equal(t, int32(1000), lap.LapTimeMs)
+	assert.Equal(t, uint64(1740000000), lap.Timestamp)
+	assert.Equal(t, int32(300), lap.Frames[3].CurrentTime)
+	assert.InDelta(t, 0.3, lap.Frames[3].NormalizedCarPosition, 0.001)
+	assert.Equal(t, float32(103), lap.Frames[3].SpeedKmh)
+}
+
+func TestFromCSVReportsUnmapped(t *testing.T) {
+	csv := "Time,Speed,Oil Temp,Tyre Pressure FL\n0,100,90,27.5\n0.5,110,91,27.6\n1,120,92,27.7\n"
+	result, err := FromCSV(strings.NewReader(csv), Options{Track: "monza", CarModel: "ferrari_296_gt3", Date: time.Now()})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Oil Temp", "Tyre Pressure FL"}, result.Unmapped)
+	assert.Equal(t, []string{"Lap Position", "Lap Time"}, result.Derived)
+
+	// without the position the whole file is a single lap
+	assert.Len(t, result.Laps, 1)
+	lap := result.Laps[0]
+	assert.Equal(t, int32(1000), lap.LapTimeMs)
+	assert.Equal(t, float32(0.5), lap.Frames[1].NormalizedCarPosition)
+}
+
+func TestFromCSVSplitsLaps(t *testing.T) {
+	csv := "Time,Lap Position,Speed\n" +
+		// out lap from the pits
+		"0,0.80,80\n1,0.90,90\n" +
+		"2,0.01,100\n3,0.50,100\n4,0.99,100\n" +
+		"5,0.02,110\n6,0.51,110\n7,0.98,110\n" +
+		// the session ended in the middle of the lap
+		"8,0.01,120\n9,0.40,120\n"
+	date := time.Unix(1740000000, 0)
+	result, err := FromCSV(strings.NewReader(csv), Options{Track: "monza", CarModel: "ferrari_296_gt3", Date: date})
+	assert.NoError(t, err)
+
+	assert.Len(t, result.Laps, 2)
+	assert.Equal(t, uint64(1740000002), result.Laps[0].Timestamp)
+	assert.Equal(t, int32(2000), result.Laps[0].LapTimeMs)
+	assert.Equal(t, uint64(1740000005), result.Laps[1].Timestamp)
+	assert.Equal(t, float32(110), result.Laps[1].Frames[0].SpeedKmh)
+}
+
+func TestFromCSVTimeInMilliseconds(t *testing.T) {
+	csv := "Time [ms],Speed\n0,100\n500,110\n1000,120\n"
+	result, err := FromCSV(strings.NewReader(csv), Options{Track: "monza", CarModel: "ferrari_296_gt3", Date: time.Now()})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1000), result.Laps[0].LapTimeMs)
+}
+
+func TestFromCSVErrors(t *testing.T) {
+	options := Options{Track: "monza", CarModel: "ferrari_296_gt3", Date: time.Now()}
+
+	_, err := FromCSV(strings.NewReader("Speed\n100\n"), options)
+	assert.ErrorContains(t, err, "no time column")
+
+	_, err = FromCSV(strings.NewReader("Time,Oil Temp\n0,90\n1,91\n"), options)
+	assert.ErrorContains(t, err, "no column of the file is mapped")
+
+	_, err = FromCSV(strings.NewReader("Time,Speed\n0,100\n1,fast\n"), options)
+	assert.ErrorContains(t, err, "invalid value 'fast' of 'Speed' on line 3")
+
+	_, err = FromCSV(strings.NewReader("Time,Speed\n0,100\n1,110\n"), Options{Date: time.Now()})
+	assert.ErrorContains(t, err, "the track and the car")
+
+	_, err = FromCSV(strings.NewReader("Time,Lap Position\n0,0.5\n1,0.6\n"), options)
+	assert.ErrorContains(t, err, "no complete lap")
+}
+
+func TestLoadMapping(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), MAPPING_FILE)
+	assert.NoError(t, os.WriteFile(mappingFile, []byte(`{
+		"timeColumn": "Elapsed",
+		"rules": [
+			{"source": "Ground Speed", "target": "Speed", "scale": 3.6},
+			{"source": "Pedal", "target": "Throttle Pos", "scale": 100}
+		]
+	}`), 0644))
+
+	mapping, err := LoadMapping(mappingFile)
+	assert.NoError(t, err)
+
+	csv := "Elapsed [s],Ground Speed [m/s],Pedal,Gear\n0,10,0.5,2\n1,20,1,3\n"
+	result, err := FromCSV(strings.NewReader(csv), Options{Mapping: mapping, Track: "monza", CarModel: "ferrari_296_gt3", Date: time.Now()})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Unmapped)
+
+	frame := result.Laps[0].Frames[1]
+	assert.InDelta(t, 72, frame.SpeedKmh, 0.001)
+	assert.InDelta(t, 1, frame.Gas, 0.001)
+	assert.Equal(t, int32(3), frame.Gear)
+}
+
+func TestLoadMappingMissingFile(t *testing.T) {
+	mapping, err := LoadMapping(filepath.Join(t.TempDir(), MAPPING_FILE))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMapping(), mapping)
+}
+
+func TestLoadMappingUnknownTarget(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), MAPPING_FILE)
+	assert.NoError(t, os.WriteFile(mappingFile, []byte(`{"rules": [{"source": "Oil Temp", "target": "Oil"}]}`), 0644))
+
+	_, err := LoadMapping(mappingFile)
+	assert.ErrorContains(t, err, "unknown channel 'Oil'")
+}
+
+func TestFromFile(t *testing.T) {
+	dir := t.TempDir()
+	ldFile := filepath.Join(dir, "lap.ld")
+	assert.NoError(t, export.ToFile(fullLap(11), ldFile, export.Options{Format: export.FORMAT_MOTEC}))
+	result, err := FromFile(ldFile, Options{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Laps, 1)
+
+	_, err = FromFile(filepath.Join(dir, "lap.txt"), Options{})
+	assert.ErrorContains(t, err, "unknown file type")
+}
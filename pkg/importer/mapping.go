@@ -0,0 +1,109 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sparkoo/racemate-desktop/pkg/channels"
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// MAPPING_FILE is the name of the optional mapping file in the data directory
+const MAPPING_FILE = "import_mapping.json"
+
+// Rule maps the channel of the imported file to the RaceMate channel. The value is converted
+// as value * Scale + Offset, zero Scale is 1.
+type Rule struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Scale  float64 `json:"scale,omitempty"`
+	Offset float64 `json:"offset,omitempty"`
+}
+
+// Mapping says how the channels of imported files are converted to the frames
+type Mapping struct {
+	// TimeColumn is the name of the CSV column with the time in seconds
+	TimeColumn string `json:"timeColumn,omitempty"`
+	Rules      []Rule `json:"rules"`
+}
+
+// DefaultMapping maps the RaceMate channel names and short names to themselves, so the laps exported
+// by RaceMate are imported back without any mapping file
+func DefaultMapping() *Mapping {
+	mapping := &Mapping{TimeColumn: "Time"}
+	for _, channel := range channels.ALL {
+		mapping.Rules = append(mapping.Rules,
+			Rule{Source: channel.Name, Target: channel.Name},
+			Rule{Source: channel.ShortName, Target: channel.Name})
+	}
+	return mapping
+}
+
+// LoadMapping reads the mapping file. The rules of the file take precedence over the default rules.
+// Missing file gives the default mapping.
+func LoadMapping(filename string) (*Mapping, error) {
+	mapping := DefaultMapping()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mapping, nil
+		}
+		return nil, fmt.Errorf("failed to read mapping file: %w", err)
+	}
+
+	custom := &Mapping{}
+	if err := json.Unmarshal(data, custom); err != nil {
+		return nil, fmt.Errorf("failed to parse mapping file: %w", err)
+	}
+	for _, rule := range custom.Rules {
+		if _, found := channels.Lookup(rule.Target); !found {
+			return nil, fmt.Errorf("mapping of '%s' targets unknown channel '%s', use one of %s",
+				rule.Source, rule.Target, strings.Join(channels.Names(), ", "))
+		}
+	}
+	if custom.TimeColumn != "" {
+		mapping.TimeColumn = custom.TimeColumn
+	}
+	mapping.Rules = append(custom.Rules, mapping.Rules...)
+	return mapping, nil
+}
+
+// mappedChannel is the source channel resolved by the rule
+type mappedChannel struct {
+	rule    Rule
+	channel channels.Channel
+}
+
+// apply converts the value and sets it to the frame
+func (m mappedChannel) apply(frame *message.Frame, value float64) {
+	scale := m.rule.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	m.channel.Set(frame, value*scale+m.rule.Offset)
+}
+
+// resolve finds the rule for the source channel, names are compared case insensitive without the units
+func (m *Mapping) resolve(source string) (mappedChannel, bool) {
+	name := stripUnit(source)
+	for _, rule := range m.Rules {
+		if strings.EqualFold(rule.Source, name) {
+			channel, found := channels.Lookup(rule.Target)
+			if !found {
+				continue
+			}
+			return mappedChannel{rule: rule, channel: channel}, true
+		}
+	}
+	return mappedChannel{}, false
+}
+
+// stripUnit removes the unit from the column name, e.g. "Speed [km/h]" -> "Speed"
+func stripUnit(name string) string {
+	if i := strings.LastIndex(name, " ["); i > 0 && strings.HasSuffix(name, "]") {
+		name = name[:i]
+	}
+	return strings.TrimSpace(name)
+}
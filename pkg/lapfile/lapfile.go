@@ -0,0 +1,242 @@
+package lapfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"google.golang.org/protobuf/proto"
+)
+
+// SUFFIX is the file suffix of the saved laps
+const SUFFIX = ".lap.gzip"
+
+// TEMP_SUFFIX is the file suffix of the laps being written, the file is renamed to the lap file once complete
+const TEMP_SUFFIX = ".tmp"
+
+// The lap file is the header followed by the payload, the gzip compressed protobuf of the lap.
+// All numbers are little endian. Files saved before the header was introduced are the bare payload.
+const (
+	// MAGIC are the first bytes of the lap file
+	MAGIC = "RMLP"
+	// FORMAT_VERSION is the version of the header
+	FORMAT_VERSION = 1
+	// SCHEMA_VERSION is the version of the racemate-msg Lap message, bump it with incompatible message changes
+	SCHEMA_VERSION = 1
+)
+
+// ErrCorrupted is returned for the files that are truncated, fail the checksum or can't be parsed
+var ErrCorrupted = errors.New("lap file is corrupted")
+
+// ErrUnsupportedVersion is returned for the files written in the format or the lap schema this version can't read,
+// e.g. by the newer version of the app
+var ErrUnsupportedVersion = errors.New("lap file version is not supported")
+
+// gzipMagic are the first bytes of the gzip stream, the files without the header start with them
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type header struct {
+	Magic         [4]byte
+	FormatVersion uint16
+	SchemaVersion uint16
+	PayloadLength uint64
+	Checksum      [sha256.Size]byte
+}
+
+var headerSize = binary.Size(header{})
+
+// Name returns the file name of the lap, <unix>_<track>_<car>.lap.gzip
+func Name(lap *message.Lap) string {
+	return fmt.Sprintf("%d_%s_%s%s", lap.Timestamp, lap.Track, lap.CarModel, SUFFIX)
+}
+
+// Save writes the lap as gzip compressed protobuf with the header
+func Save(filename string, lap *message.Lap) error {
+	protobufMessage, protoErr := proto.Marshal(lap)
+	if protoErr != nil {
+		return fmt.Errorf("failed to marshal lap message with protobuf: %w", protoErr)
+	}
+
+	payload, err := compress(protobufMessage)
+	if err != nil {
+		return err
+	}
+	return saveWithHeader(filename, payload)
+}
+
+// Load reads the lap saved with Save, files without the header are read too
+func Load(filename string) (*message.Lap, error) {
+	payload, err := ReadPayload(filename)
+	if err != nil {
+		return nil, err
+	}
+	return decode(payload)
+}
+
+// ReadPayload validates the lap file and returns its gzip compressed protobuf, as it is uploaded to the server.
+// Broken files give ErrCorrupted, files of the newer versions give ErrUnsupportedVersion.
+func ReadPayload(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the lap file: %w", err)
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		// there is no checksum without the header, the whole lap is decoded to check it
+		if _, err := decode(data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return parse(data)
+}
+
+// Upgrade adds the header to the file saved without it. Returns whether the file was upgraded.
+func Upgrade(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, fmt.Errorf("failed to open the lap file: %w", err)
+	}
+	start := make([]byte, len(gzipMagic))
+	_, err = io.ReadFull(f, start)
+	f.Close()
+	if err != nil || !bytes.Equal(start, gzipMagic) {
+		return false, nil
+	}
+
+	payload, err := ReadPayload(filename)
+	if err != nil {
+		return false, err
+	}
+	if err := saveWithHeader(filename, payload); err != nil {
+		return false, fmt.Errorf("failed to upgrade the lap file: %w", err)
+	}
+	return true, nil
+}
+
+// parse validates the header and returns the payload
+func parse(data []byte) ([]byte, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("%w: too short for the header", ErrCorrupted)
+	}
+	h := header{}
+	if err := binary.Read(bytes.NewReader(data[:headerSize]), binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+	if string(h.Magic[:]) != MAGIC {
+		return nil, fmt.Errorf("%w: not a lap file", ErrCorrupted)
+	}
+	if h.FormatVersion > FORMAT_VERSION {
+		return nil, fmt.Errorf("%w: format version %d is newer than the supported version %d", ErrUnsupportedVersion, h.FormatVersion, FORMAT_VERSION)
+	}
+	if h.SchemaVersion == 0 {
+		return nil, fmt.Errorf("%w: unknown lap schema version %d", ErrUnsupportedVersion, h.SchemaVersion)
+	}
+	if h.SchemaVersion > SCHEMA_VERSION {
+		return nil, fmt.Errorf("%w: lap schema version %d is newer than the supported version %d", ErrUnsupportedVersion, h.SchemaVersion, SCHEMA_VERSION)
+	}
+
+	payload := data[headerSize:]
+	if uint64(len(payload)) != h.PayloadLength {
+		return nil, fmt.Errorf("%w: payload has %d bytes, expected %d", ErrCorrupted, len(payload), h.PayloadLength)
+	}
+	if sha256.Sum256(payload) != h.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+	return payload, nil
+}
+
+// decode uncompresses and unmarshals the payload
+func decode(payload []byte) (*message.Lap, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	defer gr.Close()
+
+	uncompressedData, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to uncompress the data: %w", ErrCorrupted, err)
+	}
+
+	lap := &message.Lap{}
+	if err := proto.Unmarshal(uncompressedData, lap); err != nil {
+		return nil, fmt.Errorf("%w: failed to Unmarshal the data: %w", ErrCorrupted, err)
+	}
+	return lap, nil
+}
+
+func compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write compressed data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to flush compressed data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func saveWithHeader(filename string, payload []byte) error {
+	h := header{
+		FormatVersion: FORMAT_VERSION,
+		SchemaVersion: SCHEMA_VERSION,
+		PayloadLength: uint64(len(payload)),
+		Checksum:      sha256.Sum256(payload),
+	}
+	copy(h.Magic[:], MAGIC)
+
+	buf := bytes.NewBuffer(make([]byte, 0, headerSize+len(payload)))
+	if err := binary.Write(buf, binary.LittleEndian, h); err != nil {
+		return fmt.Errorf("failed to write the header: %w", err)
+	}
+	buf.Write(payload)
+
+	return writeAtomic(filename, buf.Bytes())
+}
+
+// writeAtomic writes the data into the temporary file and renames it when it is safely on the disk,
+// so there is never a partially written lap file after a crash
+func writeAtomic(filename string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*"+TEMP_SUFFIX)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary lap file: %w", err)
+	}
+	tempName := f.Name()
+
+	if err := writeAndSync(f, data); err != nil {
+		f.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("failed to close temporary lap file: %w", err)
+	}
+	if err := os.Rename(tempName, filename); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("failed to rename temporary lap file: %w", err)
+	}
+	return nil
+}
+
+func writeAndSync(f *os.File, data []byte) error {
+	if err := f.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set lap file permissions: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write lap file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync lap file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package lapfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func testLap() *message.Lap {
+	return &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 105000, Timestamp: 1740000000,
+		Frames: []*message.Frame{{CurrentTime: 0, SpeedKmh: 100}, {CurrentTime: 100, SpeedKmh: 110}}}
+}
+
+// Helper to save the lap the way it was saved before the header, as bare gzip compressed protobuf
+func saveLegacy(t *testing.T, filename string, lap *message.Lap) {
+	data, err := proto.Marshal(lap)
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, os.WriteFile(filename, buf.Bytes(), 0644))
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "1740000000_monza_ferrari_296_gt3.lap.gzip", Name(testLap()))
+}
+
+func TestSaveLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	assert.NoError(t, Save(filename, testLap()))
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, MAGIC, string(data[:4]))
+
+	lap, err := Load(filename)
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(testLap(), lap))
+
+	// the payload is what the server expects
+	payload, err := ReadPayload(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, data[headerSize:], payload)
+	assert.Equal(t, gzipMagic, payload[:2])
+}
+
+func TestLoadTruncated(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	assert.NoError(t, Save(filename, testLap()))
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filename, data[:len(data)-5], 0644))
+	_, err = Load(filename)
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	assert.NoError(t, os.WriteFile(filename, data[:10], 0644))
+	_, err = ReadPayload(filename)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestLoadChecksumMismatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	assert.NoError(t, Save(filename, testLap()))
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+	_, err = ReadPayload(filename)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestLoadNewerFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	assert.NoError(t, Save(filename, testLap()))
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	data[4] = FORMAT_VERSION + 1
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+	_, err = Load(filename)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.NotErrorIs(t, err, ErrCorrupted)
+	assert.ErrorContains(t, err, "newer than the supported version")
+}
+
+func TestLoadUnsupportedSchema(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	assert.NoError(t, Save(filename, testLap()))
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	data[6] = SCHEMA_VERSION + 1
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+	_, err = Load(filename)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.ErrorContains(t, err, "lap schema version 2 is newer than the supported version 1")
+
+	data[6] = 0
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+	_, err = ReadPayload(filename)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.ErrorContains(t, err, "unknown lap schema version 0")
+}
+
+func TestLoadNotLapFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "notes.lap.gzip")
+	assert.NoError(t, os.WriteFile(filename, bytes.Repeat([]byte("not a lap "), 10), 0644))
+	_, err := Load(filename)
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.lap.gzip"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadLegacy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	saveLegacy(t, filename, testLap())
+
+	lap, err := Load(filename)
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(testLap(), lap))
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filename, data[:len(data)-5], 0644))
+	_, err = ReadPayload(filename)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestUpgrade(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	saveLegacy(t, filename, testLap())
+	legacy, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	upgraded, err := Upgrade(filename)
+	assert.NoError(t, err)
+	assert.True(t, upgraded)
+
+	payload, err := ReadPayload(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy, payload)
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, MAGIC, string(data[:4]))
+
+	// the current files are left alone
+	upgraded, err = Upgrade(filename)
+	assert.NoError(t, err)
+	assert.False(t, upgraded)
+}
+
+func TestUpgradeCorrupted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), Name(testLap()))
+	assert.NoError(t, os.WriteFile(filename, []byte{0x1f, 0x8b, 0x08, 0x00}, 0644))
+
+	upgraded, err := Upgrade(filename)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	assert.False(t, upgraded)
+}
+
+func TestSaveLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, Name(testLap()))
+	assert.NoError(t, Save(filename, testLap()))
+
+	// saving again replaces the lap
+	lap := testLap()
+	lap.LapTimeMs = 104000
+	assert.NoError(t, Save(filename, lap))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, Name(testLap()), entries[0].Name())
+
+	loaded, err := Load(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(104000), loaded.LapTimeMs)
+}
+
+func TestSaveMissingDirectory(t *testing.T) {
+	err := Save(filepath.Join(t.TempDir(), "missing", Name(testLap())), testLap())
+	assert.ErrorContains(t, err, "failed to create temporary lap file")
+}
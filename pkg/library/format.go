@@ -0,0 +1,35 @@
+package library
+
+import "fmt"
+
+// ACC session types as in the shared memory graphics page
+var sessionTypes = map[int32]string{
+	-1: "unknown",
+	0:  "practice",
+	1:  "qualify",
+	2:  "race",
+	3:  "hotlap",
+	4:  "time attack",
+	5:  "drift",
+	6:  "drag",
+	7:  "hotstint",
+	8:  "superpole",
+}
+
+// SessionTypeName returns human readable name of the ACC session type
+func SessionTypeName(sessionType int32) string {
+	if name, ok := sessionTypes[sessionType]; ok {
+		return name
+	}
+	return fmt.Sprintf("session %d", sessionType)
+}
+
+// FormatLapTime formats the lap time as m:ss.mmm
+func FormatLapTime(lapTimeMs int32) string {
+	sign := ""
+	if lapTimeMs < 0 {
+		sign = "-"
+		lapTimeMs = -lapTimeMs
+	}
+	return fmt.Sprintf("%s%d:%02d.%03d", sign, lapTimeMs/60000, lapTimeMs/1000%60, lapTimeMs%1000)
+}
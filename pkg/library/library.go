@@ -0,0 +1,409 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/analysis"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	message "github.com/sparkoo/racemate-msg/dist"
+)
+
+// INDEX_FILE is the name of the index file in the data directory
+const INDEX_FILE = "library.json"
+
+// INDEX_VERSION is bumped when the entries change, older index is then rebuilt from scratch
+const INDEX_VERSION = 2
+
+// SECTORS_FILE is the name of the optional sector definitions file in the data directory
+const SECTORS_FILE = "sectors.json"
+
+// Entry is the indexed lap
+type Entry struct {
+	File     string    `json:"file"`
+	Uploaded bool      `json:"uploaded"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+
+	Track           string    `json:"track"`
+	CarModel        string    `json:"carModel"`
+	LapTimeMs       int32     `json:"lapTimeMs"`
+	LapNumber       int32     `json:"lapNumber"`
+	SessionType     int32     `json:"sessionType"`
+	AirTemp         float32   `json:"airTemp"`
+	RoadTemp        float32   `json:"roadTemp"`
+	TrackGripStatus int32     `json:"trackGripStatus"`
+	RainIntensity   int32     `json:"rainIntensity"`
+	RainTyres       int32     `json:"rainTyres"`
+	RecordedAt      time.Time `json:"recordedAt"`
+
+	// Sectors are the sector times in ms, empty when they couldn't be computed
+	Sectors []int32 `json:"sectors,omitempty"`
+	// MiniSectors are the mini-sector times in ms, empty when they couldn't be computed
+	MiniSectors []int32 `json:"miniSectors,omitempty"`
+}
+
+// index is the content of the index file
+type index struct {
+	Version int `json:"version"`
+	// SectorDefinitions is the fingerprint of the definitions the splits were computed with
+	SectorDefinitions string   `json:"sectorDefinitions"`
+	Entries           []*Entry `json:"entries"`
+}
+
+// Library indexes the saved laps in the upload and uploaded directories
+type Library struct {
+	mu          sync.RWMutex
+	indexFile   string
+	uploadDir   string
+	uploadedDir string
+	logger      *slog.Logger
+	entries     map[string]*Entry
+
+	personalBestsFile string
+	personalBests     map[string]*PersonalBest
+
+	sectors *analysis.SectorDefinitions
+}
+
+// Open loads the library index from the data directory. Missing or broken index is not an error,
+// the library is empty then until Rebuild.
+func Open(dataDir, uploadDir, uploadedDir string, logger *slog.Logger) *Library {
+	l := &Library{
+		indexFile:   filepath.Join(dataDir, INDEX_FILE),
+		uploadDir:   uploadDir,
+		uploadedDir: uploadedDir,
+		logger:      logger,
+		entries:     map[string]*Entry{},
+
+		personalBestsFile: filepath.Join(dataDir, PERSONAL_BESTS_FILE),
+		personalBests:     map[string]*PersonalBest{},
+	}
+	l.loadPersonalBests()
+
+	sectors, err := analysis.LoadSectorDefinitions(filepath.Join(dataDir, SECTORS_FILE))
+	if err != nil {
+		logger.Warn("Failed to load sector definitions, using defaults", "error", err)
+		sectors = analysis.DefaultSectorDefinitions()
+	}
+	l.sectors = sectors
+
+	data, err := os.ReadFile(l.indexFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to read library index", "error", err)
+		}
+		return l
+	}
+	idx := index{}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		logger.Warn("Failed to parse library index, it will be rebuilt", "error", err)
+		return l
+	}
+	if idx.Version != INDEX_VERSION || idx.SectorDefinitions != l.sectors.Fingerprint() {
+		return l
+	}
+	for _, entry := range idx.Entries {
+		l.entries[entry.File] = entry
+	}
+	return l
+}
+
+// Rebuild scans the lap directories and updates the index. Laps that didn't change since the last
+// rebuild are not read again.
+func (l *Library) Rebuild() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := map[string]*Entry{}
+	for _, dir := range []struct {
+		path     string
+		uploaded bool
+	}{{l.uploadDir, false}, {l.uploadedDir, true}} {
+		files, err := os.ReadDir(dir.path)
+		if err != nil {
+			return fmt.Errorf("failed to read laps directory: %w", err)
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), lapfile.SUFFIX) {
+				continue
+			}
+			path := filepath.Join(dir.path, file.Name())
+			upgraded, err := lapfile.Upgrade(path)
+			if err != nil {
+				l.logger.Warn("Failed to upgrade lap file", "file", file.Name(), "error", err)
+				continue
+			}
+			if upgraded {
+				l.logger.Info("Lap file upgraded to the current format", "file", file.Name())
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				l.logger.Warn("Failed to stat lap file", "file", file.Name(), "error", err)
+				continue
+			}
+
+			if known, ok := l.entries[file.Name()]; ok && known.Size == info.Size() && known.ModTime.Equal(info.ModTime()) {
+				known.Uploaded = dir.uploaded
+				entries[file.Name()] = known
+				continue
+			}
+
+			lap, err := lapfile.Load(path)
+			if err != nil {
+				l.logger.Warn("Failed to index lap file", "file", file.Name(), "error", err)
+				continue
+			}
+			entry := l.newEntry(file.Name(), lap)
+			entry.Uploaded = dir.uploaded
+			entry.Size = info.Size()
+			entry.ModTime = info.ModTime()
+			entries[file.Name()] = entry
+		}
+	}
+	l.entries = entries
+
+	return l.save()
+}
+
+// Add indexes just saved lap
+func (l *Library) Add(filename string, lap *message.Lap) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.newEntry(filepath.Base(filename), lap)
+	if info, err := os.Stat(filename); err == nil {
+		entry.Size = info.Size()
+		entry.ModTime = info.ModTime()
+	}
+	entry.Uploaded = filepath.Dir(filename) == filepath.Clean(l.uploadedDir)
+	l.entries[entry.File] = entry
+
+	return *entry, l.save()
+}
+
+// Get returns the entry of the lap file
+func (l *Library) Get(file string) (Entry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.entries[file]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Path returns the current path of the lap file
+func (l *Library) Path(entry Entry) string {
+	if entry.Uploaded {
+		return filepath.Join(l.uploadedDir, entry.File)
+	}
+	return filepath.Join(l.uploadDir, entry.File)
+}
+
+// Load reads the lap of the entry. The lap might have been uploaded since the last rebuild,
+// so both directories are tried.
+func (l *Library) Load(entry Entry) (*message.Lap, error) {
+	lap, err := lapfile.Load(l.Path(entry))
+	if errors.Is(err, fs.ErrNotExist) {
+		entry.Uploaded = !entry.Uploaded
+		return lapfile.Load(l.Path(entry))
+	}
+	return lap, err
+}
+
+// Query filters the laps, zero values match everything
+type Query struct {
+	Track    string
+	CarModel string
+	// From is inclusive
+	From time.Time
+	// To is exclusive
+	To time.Time
+}
+
+func (q Query) matches(entry *Entry) bool {
+	if q.Track != "" && !strings.EqualFold(q.Track, entry.Track) {
+		return false
+	}
+	if q.CarModel != "" && !strings.EqualFold(q.CarModel, entry.CarModel) {
+		return false
+	}
+	if !q.From.IsZero() && entry.RecordedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !entry.RecordedAt.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// Find returns the laps matching the query ordered by the date they were recorded
+func (l *Library) Find(q Query) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	found := []Entry{}
+	for _, entry := range l.entries {
+		if q.matches(entry) {
+			found = append(found, *entry)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].RecordedAt.Equal(found[j].RecordedAt) {
+			return found[i].File < found[j].File
+		}
+		return found[i].RecordedAt.Before(found[j].RecordedAt)
+	})
+	return found
+}
+
+// BestLap returns the fastest lap on the track with the car
+func (l *Library) BestLap(track, carModel string) (Entry, bool) {
+	best := l.BestLaps(Query{Track: track, CarModel: carModel})
+	if len(best) == 0 {
+		return Entry{}, false
+	}
+	return best[0], true
+}
+
+// BestLaps returns the fastest lap of every track and car combination matching the query,
+// ordered by track and car
+func (l *Library) BestLaps(q Query) []Entry {
+	best := map[string]Entry{}
+	for _, entry := range l.Find(q) {
+		key := entry.Track + "/" + entry.CarModel
+		if current, ok := best[key]; !ok || entry.LapTimeMs < current.LapTimeMs {
+			best[key] = entry
+		}
+	}
+
+	found := make([]Entry, 0, len(best))
+	for _, entry := range best {
+		found = append(found, entry)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Track == found[j].Track {
+			return found[i].CarModel < found[j].CarModel
+		}
+		return found[i].Track < found[j].Track
+	})
+	return found
+}
+
+// save writes the index file, caller must hold the lock
+func (l *Library) save() error {
+	idx := index{
+		Version:           INDEX_VERSION,
+		SectorDefinitions: l.sectors.Fingerprint(),
+		Entries:           make([]*Entry, 0, len(l.entries)),
+	}
+	for _, entry := range l.entries {
+		idx.Entries = append(idx.Entries, entry)
+	}
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		return idx.Entries[i].File < idx.Entries[j].File
+	})
+
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal library index: %w", err)
+	}
+
+	return writeFile(l.indexFile, data)
+}
+
+// writeFile replaces the file through the temporary file so it is never left half written
+func writeFile(filename string, data []byte) error {
+	tmpFile := filename + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", filename, err)
+	}
+	if err := os.Rename(tmpFile, filename); err != nil {
+		return fmt.Errorf("failed to replace '%s': %w", filename, err)
+	}
+	return nil
+}
+
+func (l *Library) newEntry(file string, lap *message.Lap) *Entry {
+	entry := &Entry{
+		File:            file,
+		Track:           lap.Track,
+		CarModel:        lap.CarModel,
+		LapTimeMs:       lap.LapTimeMs,
+		LapNumber:       lap.LapNumber,
+		SessionType:     lap.SessionType,
+		AirTemp:         lap.AirTemp,
+		RoadTemp:        lap.RoadTemp,
+		TrackGripStatus: lap.TrackGripStatus,
+		RainIntensity:   lap.RainIntensity,
+		RainTyres:       lap.RainTyres,
+		RecordedAt:      time.Unix(int64(lap.Timestamp), 0),
+	}
+
+	sectors, err := analysis.Splits(lap, l.sectors.Sectors(lap.Track))
+	if err != nil {
+		l.logger.Debug("Failed to compute sectors", "file", file, "error", err)
+	}
+	entry.Sectors = sectors
+	miniSectors, err := analysis.Splits(lap, analysis.EqualSectors(l.sectors.MiniSectors))
+	if err != nil {
+		l.logger.Debug("Failed to compute mini-sectors", "file", file, "error", err)
+	}
+	entry.MiniSectors = miniSectors
+	return entry
+}
+
+// MarkUploaded updates the entry after the lap file was moved to the uploaded directory
+func (l *Library) MarkUploaded(file string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[file]
+	if !ok || entry.Uploaded {
+		return nil
+	}
+	entry.Uploaded = true
+	return l.save()
+}
+
+// TheoreticalBest is the lap made of the best sectors driven on the track with the car
+type TheoreticalBest struct {
+	LapTimeMs            int32
+	Sectors              []int32
+	MiniSectorsLapTimeMs int32
+	MiniSectors          []int32
+	// Laps is the number of laps the best sectors were picked from
+	Laps int
+}
+
+// TheoreticalBest sums the best sectors and the best mini-sectors of all the laps on the track with the car
+func (l *Library) TheoreticalBest(track, carModel string) (TheoreticalBest, bool) {
+	var sectors, miniSectors [][]int32
+	for _, entry := range l.Find(Query{Track: track, CarModel: carModel}) {
+		if len(entry.Sectors) > 0 {
+			sectors = append(sectors, entry.Sectors)
+		}
+		if len(entry.MiniSectors) > 0 {
+			miniSectors = append(miniSectors, entry.MiniSectors)
+		}
+	}
+	if len(sectors) == 0 {
+		return TheoreticalBest{}, false
+	}
+
+	best := TheoreticalBest{Laps: len(sectors)}
+	best.LapTimeMs, best.Sectors = analysis.TheoreticalBest(sectors)
+	best.MiniSectorsLapTimeMs, best.MiniSectors = analysis.TheoreticalBest(miniSectors)
+	return best, true
+}
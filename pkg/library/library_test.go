@@ -0,0 +1,327 @@
+package library
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/analysis"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create library in temporary data directory
+func setupTestLibrary(t *testing.T) (*Library, string, string, string) {
+	dataDir := t.TempDir()
+	uploadDir := filepath.Join(dataDir, "upload")
+	uploadedDir := filepath.Join(dataDir, "uploaded")
+	assert.NoError(t, os.MkdirAll(uploadDir, 0755))
+	assert.NoError(t, os.MkdirAll(uploadedDir, 0755))
+	return Open(dataDir, uploadDir, uploadedDir, testLogger()), dataDir, uploadDir, uploadedDir
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Helper to save the lap file into the directory
+func saveLap(t *testing.T, dir, track, car string, lapTimeMs int32, recorded time.Time) string {
+	file := filepath.Join(dir, lapFileName(track, car, recorded))
+	assert.NoError(t, lapfile.Save(file, &message.Lap{
+		Track:     track,
+		CarModel:  car,
+		LapTimeMs: lapTimeMs,
+		Timestamp: uint64(recorded.Unix()),
+	}))
+	return file
+}
+
+func lapFileName(track, car string, recorded time.Time) string {
+	return strconv.FormatInt(recorded.Unix(), 10) + "_" + track + "_" + car + lapfile.SUFFIX
+}
+
+func TestRebuild(t *testing.T) {
+	l, dataDir, uploadDir, uploadedDir := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 107345, day)
+	saveLap(t, uploadedDir, "spa", "bmw_m4_gt3", 138002, day.Add(time.Hour))
+	assert.NoError(t, os.WriteFile(filepath.Join(uploadDir, "broken"+lapfile.SUFFIX), []byte("not a lap"), 0644))
+
+	assert.NoError(t, l.Rebuild())
+
+	laps := l.Find(Query{})
+	assert.Len(t, laps, 2)
+	assert.Equal(t, "monza", laps[0].Track)
+	assert.False(t, laps[0].Uploaded)
+	assert.Equal(t, "spa", laps[1].Track)
+	assert.True(t, laps[1].Uploaded)
+	assert.Equal(t, day, laps[0].RecordedAt.UTC())
+
+	// index is persisted and loaded without rebuild
+	reopened := Open(dataDir, uploadDir, uploadedDir, testLogger())
+	assert.Len(t, reopened.Find(Query{}), 2)
+}
+
+func TestRebuildUpgradesLegacyLaps(t *testing.T) {
+	l, _, uploadDir, _ := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// laps saved before the lap file header are bare gzip compressed protobuf
+	file := saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 107345, day)
+	payload, err := lapfile.ReadPayload(file)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(file, payload, 0644))
+
+	assert.NoError(t, l.Rebuild())
+	assert.Len(t, l.Find(Query{}), 1)
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, lapfile.MAGIC, string(data[:len(lapfile.MAGIC)]))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	entry, _ := l.Get(filepath.Base(file))
+	assert.Equal(t, info.Size(), entry.Size)
+}
+
+func TestRebuildRemovesDeletedLaps(t *testing.T) {
+	l, _, uploadDir, _ := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	deleted := saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 107345, day)
+	saveLap(t, uploadDir, "spa", "bmw_m4_gt3", 138002, day)
+	assert.NoError(t, l.Rebuild())
+	assert.Len(t, l.Find(Query{}), 2)
+
+	assert.NoError(t, os.Remove(deleted))
+	assert.NoError(t, l.Rebuild())
+
+	laps := l.Find(Query{})
+	assert.Len(t, laps, 1)
+	assert.Equal(t, "spa", laps[0].Track)
+}
+
+func TestRebuildTracksUploadedLaps(t *testing.T) {
+	l, _, uploadDir, uploadedDir := setupTestLibrary(t)
+
+	file := saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 107345, time.Now())
+	assert.NoError(t, l.Rebuild())
+	assert.False(t, l.Find(Query{})[0].Uploaded)
+
+	assert.NoError(t, os.Rename(file, filepath.Join(uploadedDir, filepath.Base(file))))
+	assert.NoError(t, l.Rebuild())
+	assert.True(t, l.Find(Query{})[0].Uploaded)
+}
+
+func TestAddAndMarkUploaded(t *testing.T) {
+	l, _, uploadDir, uploadedDir := setupTestLibrary(t)
+
+	file := saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 107345, time.Now())
+	lap, err := lapfile.Load(file)
+	assert.NoError(t, err)
+	_, err = l.Add(file, lap)
+	assert.NoError(t, err)
+
+	entry, found := l.BestLap("monza", "ferrari_296_gt3")
+	assert.True(t, found)
+	assert.False(t, entry.Uploaded)
+
+	// lap is loaded even when the file moved since
+	assert.NoError(t, os.Rename(file, filepath.Join(uploadedDir, entry.File)))
+	loaded, err := l.Load(entry)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(107345), loaded.LapTimeMs)
+
+	assert.NoError(t, l.MarkUploaded(entry.File))
+	entry, _ = l.BestLap("monza", "ferrari_296_gt3")
+	assert.True(t, entry.Uploaded)
+	assert.Equal(t, filepath.Join(uploadedDir, entry.File), l.Path(entry))
+}
+
+func TestFindByDateRange(t *testing.T) {
+	l, _, uploadDir, _ := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 107000, day.Add(-time.Second))
+	saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 108000, day)
+	saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 109000, day.Add(23*time.Hour))
+	saveLap(t, uploadDir, "monza", "ferrari_296_gt3", 110000, day.AddDate(0, 0, 1))
+	assert.NoError(t, l.Rebuild())
+
+	laps := l.Find(Query{From: day, To: day.AddDate(0, 0, 1)})
+	assert.Len(t, laps, 2)
+	assert.Equal(t, int32(108000), laps[0].LapTimeMs)
+	assert.Equal(t, int32(109000), laps[1].LapTimeMs)
+}
+
+func TestBestLaps(t *testing.T) {
+	l, _, uploadDir, uploadedDir := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	saveLap(t, uploadDir, "spa", "bmw_m4_gt3", 138000, day)
+	saveLap(t, uploadedDir, "spa", "bmw_m4_gt3", 137500, day.Add(time.Minute))
+	saveLap(t, uploadDir, "spa", "ferrari_296_gt3", 139000, day.Add(2*time.Minute))
+	saveLap(t, uploadDir, "monza", "bmw_m4_gt3", 108000, day.Add(3*time.Minute))
+	assert.NoError(t, l.Rebuild())
+
+	best := l.BestLaps(Query{})
+	assert.Len(t, best, 3)
+	assert.Equal(t, "monza", best[0].Track)
+	assert.Equal(t, "spa", best[1].Track)
+	assert.Equal(t, "bmw_m4_gt3", best[1].CarModel)
+	assert.Equal(t, int32(137500), best[1].LapTimeMs)
+	assert.Equal(t, "ferrari_296_gt3", best[2].CarModel)
+
+	entry, found := l.BestLap("SPA", "bmw_m4_gt3")
+	assert.True(t, found)
+	assert.Equal(t, int32(137500), entry.LapTimeMs)
+
+	_, found = l.BestLap("nurburgring", "bmw_m4_gt3")
+	assert.False(t, found)
+}
+
+func TestFormatLapTime(t *testing.T) {
+	assert.Equal(t, "1:47.345", FormatLapTime(107345))
+	assert.Equal(t, "0:59.009", FormatLapTime(59009))
+	assert.Equal(t, "-0:00.312", FormatLapTime(-312))
+}
+
+// Helper to save the lap in the conditions and add it to the library like the scraper does
+func addLap(t *testing.T, l *Library, dir string, lapTimeMs int32, grip, rain int32, recorded time.Time) Entry {
+	file := filepath.Join(dir, strconv.FormatInt(recorded.Unix(), 10)+"_spa_bmw_m4_gt3"+lapfile.SUFFIX)
+	lap := &message.Lap{
+		Track:           "spa",
+		CarModel:        "bmw_m4_gt3",
+		LapTimeMs:       lapTimeMs,
+		TrackGripStatus: grip,
+		RainIntensity:   rain,
+		Timestamp:       uint64(recorded.Unix()),
+	}
+	assert.NoError(t, lapfile.Save(file, lap))
+	entry, err := l.Add(file, lap)
+	assert.NoError(t, err)
+	return entry
+}
+
+func TestUpdatePersonalBest(t *testing.T) {
+	l, dataDir, uploadDir, uploadedDir := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	previous, isPB, err := l.UpdatePersonalBest(addLap(t, l, uploadDir, 138000, 2, 0, day), false)
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+	assert.True(t, isPB)
+
+	previous, isPB, err = l.UpdatePersonalBest(addLap(t, l, uploadDir, 138500, 2, 0, day.Add(time.Minute)), false)
+	assert.NoError(t, err)
+	assert.False(t, isPB)
+	assert.Equal(t, int32(138000), previous.LapTimeMs)
+
+	previous, isPB, err = l.UpdatePersonalBest(addLap(t, l, uploadDir, 137700, 2, 0, day.Add(2*time.Minute)), false)
+	assert.NoError(t, err)
+	assert.True(t, isPB)
+	assert.Equal(t, int32(138000), previous.LapTimeMs)
+
+	// personal bests are persisted
+	pbs := Open(dataDir, uploadDir, uploadedDir, testLogger()).PersonalBests()
+	assert.Len(t, pbs, 1)
+	assert.Equal(t, int32(137700), pbs[0].LapTimeMs)
+	assert.Empty(t, pbs[0].Conditions)
+}
+
+func TestUpdatePersonalBestByConditions(t *testing.T) {
+	l, _, uploadDir, _ := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	_, isPB, err := l.UpdatePersonalBest(addLap(t, l, uploadDir, 138000, 2, 0, day), true)
+	assert.NoError(t, err)
+	assert.True(t, isPB)
+
+	// much slower lap in the rain is still the personal best in the wet
+	previous, isPB, err := l.UpdatePersonalBest(addLap(t, l, uploadDir, 155000, 5, 3, day.Add(time.Minute)), true)
+	assert.NoError(t, err)
+	assert.True(t, isPB)
+	assert.Nil(t, previous)
+
+	pbs := l.PersonalBests()
+	assert.Len(t, pbs, 2)
+	assert.Equal(t, "optimum, no rain", pbs[0].Conditions)
+	assert.Equal(t, "wet, medium rain", pbs[1].Conditions)
+}
+
+func TestUpdatePersonalBestSeedsFromLibrary(t *testing.T) {
+	l, _, uploadDir, uploadedDir := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// laps recorded before the personal bests were tracked
+	saveLap(t, uploadedDir, "spa", "bmw_m4_gt3", 137000, day)
+	assert.NoError(t, l.Rebuild())
+
+	previous, isPB, err := l.UpdatePersonalBest(addLap(t, l, uploadDir, 137500, 0, 0, day.Add(time.Hour)), false)
+	assert.NoError(t, err)
+	assert.False(t, isPB)
+	assert.Equal(t, int32(137000), previous.LapTimeMs)
+	assert.Len(t, l.PersonalBests(), 1)
+}
+
+// Helper to save lap with frames driven at constant speed, slowed down in the given third of the lap
+func saveLapWithFrames(t *testing.T, dir string, lapTimeMs int32, slowThird int, recorded time.Time) {
+	lap := &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: lapTimeMs, Timestamp: uint64(recorded.Unix())}
+	sector := float32(lapTimeMs-1000) / 3
+	for i := 0; i < 300; i++ {
+		position := float32(i) / 300
+		third := i / 100
+		currentTime := sector * position * 3
+		if third > slowThird {
+			currentTime += 1000
+		} else if third == slowThird {
+			currentTime += 1000 * (position*3 - float32(third))
+		}
+		lap.Frames = append(lap.Frames, &message.Frame{NormalizedCarPosition: position, CurrentTime: int32(currentTime)})
+	}
+	file := filepath.Join(dir, strconv.FormatInt(recorded.Unix(), 10)+"_monza_ferrari_296_gt3"+lapfile.SUFFIX)
+	assert.NoError(t, lapfile.Save(file, lap))
+}
+
+func TestTheoreticalBest(t *testing.T) {
+	l, _, uploadDir, _ := setupTestLibrary(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// both laps lost a second, each in different sector
+	saveLapWithFrames(t, uploadDir, 91000, 0, day)
+	saveLapWithFrames(t, uploadDir, 91000, 2, day.Add(time.Minute))
+	assert.NoError(t, l.Rebuild())
+
+	laps := l.Find(Query{})
+	assert.Len(t, laps[0].Sectors, 3)
+	assert.Len(t, laps[0].MiniSectors, analysis.DEFAULT_MINI_SECTORS)
+
+	best, found := l.TheoreticalBest("monza", "ferrari_296_gt3")
+	assert.True(t, found)
+	assert.Equal(t, 2, best.Laps)
+	assert.InDelta(t, 90000, best.LapTimeMs, 2)
+	assert.InDelta(t, 90000, best.MiniSectorsLapTimeMs, 2)
+
+	_, found = l.TheoreticalBest("spa", "ferrari_296_gt3")
+	assert.False(t, found)
+}
+
+func TestSectorDefinitionsChangeRebuildsIndex(t *testing.T) {
+	l, dataDir, uploadDir, uploadedDir := setupTestLibrary(t)
+
+	saveLapWithFrames(t, uploadDir, 91000, 0, time.Now())
+	assert.NoError(t, l.Rebuild())
+	assert.Len(t, l.Find(Query{})[0].MiniSectors, analysis.DEFAULT_MINI_SECTORS)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dataDir, SECTORS_FILE), []byte(`{"miniSectors": 4}`), 0644))
+	l = Open(dataDir, uploadDir, uploadedDir, testLogger())
+	assert.Empty(t, l.Find(Query{}))
+	assert.NoError(t, l.Rebuild())
+	assert.Len(t, l.Find(Query{})[0].MiniSectors, 4)
+}
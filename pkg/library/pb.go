@@ -0,0 +1,161 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// PERSONAL_BESTS_FILE is the name of the personal bests file in the data directory
+const PERSONAL_BESTS_FILE = "personal_bests.json"
+
+// PersonalBest is the fastest lap on the track with the car, optionally in given conditions
+type PersonalBest struct {
+	Track    string `json:"track"`
+	CarModel string `json:"carModel"`
+	// Conditions are empty when personal bests are not tracked by the conditions
+	Conditions string    `json:"conditions,omitempty"`
+	LapTimeMs  int32     `json:"lapTimeMs"`
+	File       string    `json:"file"`
+	SetAt      time.Time `json:"setAt"`
+}
+
+// ACC track grip status as in the shared memory graphics page
+var gripStatuses = []string{"green", "fast", "optimum", "greasy", "damp", "wet", "flooded"}
+
+// ACC rain intensity as in the shared memory graphics page
+var rainIntensities = []string{"no rain", "drizzle", "light rain", "medium rain", "heavy rain", "thunderstorm"}
+
+// Conditions describes the track grip and the rain of the lap
+func Conditions(entry Entry) string {
+	grip := fmt.Sprintf("grip %d", entry.TrackGripStatus)
+	if entry.TrackGripStatus >= 0 && int(entry.TrackGripStatus) < len(gripStatuses) {
+		grip = gripStatuses[entry.TrackGripStatus]
+	}
+	rain := fmt.Sprintf("rain %d", entry.RainIntensity)
+	if entry.RainIntensity >= 0 && int(entry.RainIntensity) < len(rainIntensities) {
+		rain = rainIntensities[entry.RainIntensity]
+	}
+	return grip + ", " + rain
+}
+
+func personalBestKey(track, carModel, conditions string) string {
+	return track + "/" + carModel + "/" + conditions
+}
+
+func (pb *PersonalBest) key() string {
+	return personalBestKey(pb.Track, pb.CarModel, pb.Conditions)
+}
+
+func (l *Library) loadPersonalBests() {
+	data, err := os.ReadFile(l.personalBestsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.logger.Warn("Failed to read personal bests", "error", err)
+		}
+		return
+	}
+	var personalBests []*PersonalBest
+	if err := json.Unmarshal(data, &personalBests); err != nil {
+		l.logger.Warn("Failed to parse personal bests", "error", err)
+		return
+	}
+	for _, pb := range personalBests {
+		l.personalBests[pb.key()] = pb
+	}
+}
+
+// UpdatePersonalBest compares the lap with the personal best of its track and car, with byConditions
+// only the laps in the same track grip and rain are compared. It returns the previous personal best,
+// nil when this is the first lap of the combination, and whether the lap is the new personal best.
+func (l *Library) UpdatePersonalBest(entry Entry, byConditions bool) (*PersonalBest, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conditions := ""
+	if byConditions {
+		conditions = Conditions(entry)
+	}
+	key := personalBestKey(entry.Track, entry.CarModel, conditions)
+
+	previous, ok := l.personalBests[key]
+	if !ok {
+		// personal bests were not recorded yet, the laps in the library are the best we know
+		previous = l.bestIndexedLap(entry, conditions)
+	}
+	if previous != nil && previous.LapTimeMs <= entry.LapTimeMs {
+		if !ok {
+			l.personalBests[key] = previous
+			return previous, false, l.savePersonalBests()
+		}
+		return previous, false, nil
+	}
+
+	l.personalBests[key] = &PersonalBest{
+		Track:      entry.Track,
+		CarModel:   entry.CarModel,
+		Conditions: conditions,
+		LapTimeMs:  entry.LapTimeMs,
+		File:       entry.File,
+		SetAt:      entry.RecordedAt,
+	}
+	return previous, true, l.savePersonalBests()
+}
+
+// PersonalBests returns all the recorded personal bests ordered by track and car
+func (l *Library) PersonalBests() []PersonalBest {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	personalBests := make([]PersonalBest, 0, len(l.personalBests))
+	for _, pb := range l.personalBests {
+		personalBests = append(personalBests, *pb)
+	}
+	sort.Slice(personalBests, func(i, j int) bool {
+		return personalBests[i].key() < personalBests[j].key()
+	})
+	return personalBests
+}
+
+// bestIndexedLap finds the fastest other lap of the combination in the index, caller must hold the lock
+func (l *Library) bestIndexedLap(entry Entry, conditions string) *PersonalBest {
+	var best *PersonalBest
+	for _, indexed := range l.entries {
+		if indexed.File == entry.File || indexed.Track != entry.Track || indexed.CarModel != entry.CarModel {
+			continue
+		}
+		if conditions != "" && Conditions(*indexed) != conditions {
+			continue
+		}
+		if best == nil || indexed.LapTimeMs < best.LapTimeMs {
+			best = &PersonalBest{
+				Track:      indexed.Track,
+				CarModel:   indexed.CarModel,
+				Conditions: conditions,
+				LapTimeMs:  indexed.LapTimeMs,
+				File:       indexed.File,
+				SetAt:      indexed.RecordedAt,
+			}
+		}
+	}
+	return best
+}
+
+// savePersonalBests writes the personal bests file, caller must hold the lock
+func (l *Library) savePersonalBests() error {
+	personalBests := make([]*PersonalBest, 0, len(l.personalBests))
+	for _, pb := range l.personalBests {
+		personalBests = append(personalBests, pb)
+	}
+	sort.Slice(personalBests, func(i, j int) bool {
+		return personalBests[i].key() < personalBests[j].key()
+	})
+
+	data, err := json.MarshalIndent(personalBests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal personal bests: %w", err)
+	}
+	return writeFile(l.personalBestsFile, data)
+}
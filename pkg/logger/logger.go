@@ -14,10 +14,12 @@ import (
 type Config struct {
 	LogsDir    string
 	LogFile    string
-	MaxSize    int  // megabytes
-	MaxBackups int  // number of backups
-	MaxAge     int  // days
-	Compress   bool // compress rotated logs
+	MaxSize    int          // megabytes
+	MaxBackups int          // number of backups
+	MaxAge     int          // days
+	Compress   bool         // compress rotated logs
+	Console    io.Writer    // where to write logs besides the file, stdout by default
+	Level      slog.Leveler // minimal level of the records, info by default
 }
 
 // DefaultConfig returns a default logger configuration
@@ -44,12 +46,20 @@ func Initialize(config Config) *slog.Logger {
 		Compress:   config.Compress,
 	}
 
-	// Create a multi-writer that writes to both stdout and the log file
-	multiWriter := io.MultiWriter(os.Stdout, fileLogger)
+	// Create a multi-writer that writes to both console and the log file
+	console := config.Console
+	if console == nil {
+		console = os.Stdout
+	}
+	multiWriter := io.MultiWriter(console, fileLogger)
 
 	// Configure slog options
+	level := config.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
 	opts := &slog.HandlerOptions{
-		Level:     slog.LevelInfo,
+		Level:     level,
 		AddSource: true,
 	}
 
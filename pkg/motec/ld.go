@@ -0,0 +1,354 @@
+package motec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// The layout of the MoTeC i2 .ld file. All numbers are little endian, strings are zero padded.
+// The file starts with the header, followed by the event, venue and vehicle blocks, linked list of
+// the channel metadata and the channel data.
+
+// LD_MARKER is the first word of the .ld file
+const LD_MARKER = 0x40
+
+// data types of the channel values
+const (
+	dtypeAFloat = 0x07
+	dtypeFloat  = 4
+)
+
+type ldHeader struct {
+	Marker         uint32
+	_              [4]byte
+	ChannelMetaPtr uint32
+	ChannelDataPtr uint32
+	_              [20]byte
+	EventPtr       uint32
+	_              [24]byte
+	Unknown1       uint16
+	Unknown2       uint16
+	Unknown3       uint16
+	DeviceSerial   uint32
+	DeviceType     [8]byte
+	DeviceVersion  uint16
+	Unknown4       uint16
+	NumChannels    uint32
+	_              [4]byte
+	Date           [16]byte
+	_              [16]byte
+	Time           [16]byte
+	_              [16]byte
+	Driver         [64]byte
+	VehicleID      [64]byte
+	_              [64]byte
+	Venue          [64]byte
+	_              [64]byte
+	_              [1024]byte
+	ProLogging     uint32
+	_              [66]byte
+	ShortComment   [64]byte
+	_              [126]byte
+}
+
+type ldEvent struct {
+	Name     [64]byte
+	Session  [64]byte
+	Comment  [1024]byte
+	VenuePtr uint16
+}
+
+type ldVenue struct {
+	Name       [64]byte
+	_          [1034]byte
+	VehiclePtr uint16
+}
+
+type ldVehicle struct {
+	ID      [64]byte
+	_       [128]byte
+	Weight  uint32
+	Type    [32]byte
+	Comment [32]byte
+}
+
+type ldChannel struct {
+	PrevPtr   uint32
+	NextPtr   uint32
+	DataPtr   uint32
+	DataLen   uint32
+	Counter   uint16
+	DtypeA    uint16
+	Dtype     uint16
+	Frequency uint16
+	// value = (raw / Scale * 10^-DecPlaces + Shift) * Mul
+	Shift     int16
+	Mul       int16
+	Scale     int16
+	DecPlaces int16
+	Name      [32]byte
+	ShortName [8]byte
+	Unit      [12]byte
+	_         [40]byte
+}
+
+var (
+	headerSize  = binary.Size(ldHeader{})
+	eventSize   = binary.Size(ldEvent{})
+	venueSize   = binary.Size(ldVenue{})
+	vehicleSize = binary.Size(ldVehicle{})
+	channelSize = binary.Size(ldChannel{})
+)
+
+// File is the content of the .ld file
+type File struct {
+	Date         time.Time
+	Driver       string
+	VehicleID    string
+	Venue        string
+	ShortComment string
+	Event        string
+	Session      string
+	Comment      string
+	Channels     []Channel
+}
+
+// Channel is the channel of the .ld file, all the values are stored as float32
+type Channel struct {
+	Name      string
+	ShortName string
+	Unit      string
+	// Frequency is the number of samples per second
+	Frequency uint16
+	Data      []float32
+}
+
+// Write writes the file in the .ld format
+func Write(w io.Writer, file *File) error {
+	eventPtr := headerSize
+	venuePtr := eventPtr + eventSize
+	vehiclePtr := venuePtr + venueSize
+	metaPtr := vehiclePtr + vehicleSize
+	dataPtr := metaPtr + len(file.Channels)*channelSize
+
+	header := ldHeader{
+		Marker:         LD_MARKER,
+		ChannelMetaPtr: uint32(metaPtr),
+		ChannelDataPtr: uint32(dataPtr),
+		EventPtr:       uint32(eventPtr),
+		Unknown1:       1,
+		Unknown2:       0x4240,
+		Unknown3:       0xf,
+		DeviceSerial:   0x1f44,
+		DeviceVersion:  420,
+		Unknown4:       0xadb0,
+		NumChannels:    uint32(len(file.Channels)),
+		ProLogging:     0xc81a4,
+	}
+	copyString(header.DeviceType[:], "ADL")
+	copyString(header.Date[:], file.Date.Format("02/01/2006"))
+	copyString(header.Time[:], file.Date.Format("15:04:05"))
+	copyString(header.Driver[:], file.Driver)
+	copyString(header.VehicleID[:], file.VehicleID)
+	copyString(header.Venue[:], file.Venue)
+	copyString(header.ShortComment[:], file.ShortComment)
+
+	event := ldEvent{VenuePtr: uint16(venuePtr)}
+	copyString(event.Name[:], file.Event)
+	copyString(event.Session[:], file.Session)
+	copyString(event.Comment[:], file.Comment)
+
+	venue := ldVenue{VehiclePtr: uint16(vehiclePtr)}
+	copyString(venue.Name[:], file.Venue)
+
+	vehicle := ldVehicle{}
+	copyString(vehicle.ID[:], file.VehicleID)
+
+	for _, block := range []any{header, event, venue, vehicle} {
+		if err := binary.Write(w, binary.LittleEndian, block); err != nil {
+			return fmt.Errorf("failed to write ld header: %w", err)
+		}
+	}
+
+	channelDataPtr := dataPtr
+	for i, channel := range file.Channels {
+		meta := ldChannel{
+			DataPtr:   uint32(channelDataPtr),
+			DataLen:   uint32(len(channel.Data)),
+			Counter:   uint16(0x2ee1 + i),
+			DtypeA:    dtypeAFloat,
+			Dtype:     dtypeFloat,
+			Frequency: channel.Frequency,
+			Mul:       1,
+			Scale:     1,
+		}
+		if i > 0 {
+			meta.PrevPtr = uint32(metaPtr + (i-1)*channelSize)
+		}
+		if i < len(file.Channels)-1 {
+			meta.NextPtr = uint32(metaPtr + (i+1)*channelSize)
+		}
+		copyString(meta.Name[:], channel.Name)
+		copyString(meta.ShortName[:], channel.ShortName)
+		copyString(meta.Unit[:], channel.Unit)
+		if err := binary.Write(w, binary.LittleEndian, meta); err != nil {
+			return fmt.Errorf("failed to write ld channel '%s': %w", channel.Name, err)
+		}
+		channelDataPtr += len(channel.Data) * 4
+	}
+
+	for _, channel := range file.Channels {
+		if err := binary.Write(w, binary.LittleEndian, channel.Data); err != nil {
+			return fmt.Errorf("failed to write ld channel '%s' data: %w", channel.Name, err)
+		}
+	}
+	return nil
+}
+
+// copyString copies the string into the fixed size field, too long strings are cut
+func copyString(dst []byte, s string) {
+	copy(dst, s)
+}
+
+// Read parses the .ld file. Besides float32 channels written by Write, float16 and integer channels
+// of other loggers are read too. All the values are converted to the channel units.
+func Read(data []byte) (*File, error) {
+	header := ldHeader{}
+	if err := readBlock(data, 0, &header); err != nil {
+		return nil, fmt.Errorf("failed to read ld header: %w", err)
+	}
+	if header.Marker != LD_MARKER {
+		return nil, fmt.Errorf("not an ld file, unexpected marker 0x%x", header.Marker)
+	}
+
+	file := &File{
+		Driver:       readString(header.Driver[:]),
+		VehicleID:    readString(header.VehicleID[:]),
+		Venue:        readString(header.Venue[:]),
+		ShortComment: readString(header.ShortComment[:]),
+	}
+	if date, err := time.ParseInLocation("02/01/2006 15:04:05",
+		readString(header.Date[:])+" "+readString(header.Time[:]), time.Local); err == nil {
+		file.Date = date
+	}
+
+	if header.EventPtr > 0 {
+		event := ldEvent{}
+		if err := readBlock(data, int(header.EventPtr), &event); err != nil {
+			return nil, fmt.Errorf("failed to read ld event: %w", err)
+		}
+		file.Event = readString(event.Name[:])
+		file.Session = readString(event.Session[:])
+		file.Comment = readString(event.Comment[:])
+	}
+
+	// channels are linked list, the limit protects from the cycles in broken files
+	metaPtr := int(header.ChannelMetaPtr)
+	for metaPtr != 0 && len(file.Channels) < int(header.NumChannels) {
+		meta := ldChannel{}
+		if err := readBlock(data, metaPtr, &meta); err != nil {
+			return nil, fmt.Errorf("failed to read ld channel: %w", err)
+		}
+		channel := Channel{
+			Name:      readString(meta.Name[:]),
+			ShortName: readString(meta.ShortName[:]),
+			Unit:      readString(meta.Unit[:]),
+			Frequency: meta.Frequency,
+		}
+		values, err := readValues(data, meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ld channel '%s': %w", channel.Name, err)
+		}
+		channel.Data = values
+		file.Channels = append(file.Channels, channel)
+		metaPtr = int(meta.NextPtr)
+	}
+	return file, nil
+}
+
+// readValues reads the channel data and converts them to float32 values in the channel units
+func readValues(data []byte, meta ldChannel) ([]float32, error) {
+	var size int
+	switch {
+	case meta.DtypeA == dtypeAFloat && (meta.Dtype == 2 || meta.Dtype == 4):
+		size = int(meta.Dtype)
+	case (meta.DtypeA == 0 || meta.DtypeA == 3 || meta.DtypeA == 5) && (meta.Dtype == 2 || meta.Dtype == 4):
+		size = int(meta.Dtype)
+	default:
+		return nil, fmt.Errorf("unsupported data type %d/%d", meta.DtypeA, meta.Dtype)
+	}
+	start := int(meta.DataPtr)
+	end := start + int(meta.DataLen)*size
+	if start < 0 || end > len(data) {
+		return nil, fmt.Errorf("channel data out of the file")
+	}
+
+	scale := float64(meta.Scale)
+	if scale == 0 {
+		scale = 1
+	}
+	mul := float64(meta.Mul)
+	if mul == 0 {
+		mul = 1
+	}
+	decimals := math.Pow10(-int(meta.DecPlaces))
+
+	values := make([]float32, meta.DataLen)
+	for i := range values {
+		raw := data[start+i*size:]
+		var value float64
+		switch {
+		case meta.DtypeA == dtypeAFloat && size == 4:
+			value = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+		case meta.DtypeA == dtypeAFloat:
+			value = float16(binary.LittleEndian.Uint16(raw))
+		case size == 4:
+			value = float64(int32(binary.LittleEndian.Uint32(raw)))
+		default:
+			value = float64(int16(binary.LittleEndian.Uint16(raw)))
+		}
+		values[i] = float32((value/scale*decimals + float64(meta.Shift)) * mul)
+	}
+	return values, nil
+}
+
+// float16 converts IEEE 754 half precision number
+func float16(bits uint16) float64 {
+	sign := 1.0
+	if bits&0x8000 != 0 {
+		sign = -1
+	}
+	exponent := int(bits>>10) & 0x1f
+	fraction := float64(bits & 0x3ff)
+	switch exponent {
+	case 0:
+		return sign * fraction / 1024 * math.Pow(2, -14)
+	case 0x1f:
+		if fraction != 0 {
+			return math.NaN()
+		}
+		return sign * math.Inf(1)
+	}
+	return sign * (1 + fraction/1024) * math.Pow(2, float64(exponent-15))
+}
+
+func readBlock(data []byte, offset int, block any) error {
+	size := binary.Size(block)
+	if offset < 0 || offset+size > len(data) {
+		return fmt.Errorf("block at %d is out of the file", offset)
+	}
+	return binary.Read(bytes.NewReader(data[offset:offset+size]), binary.LittleEndian, block)
+}
+
+// readString reads the zero terminated string from the fixed size field
+func readString(field []byte) string {
+	if end := bytes.IndexByte(field, 0); end >= 0 {
+		field = field[:end]
+	}
+	return strings.TrimSpace(string(field))
+}
@@ -0,0 +1,113 @@
+package motec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockSizes(t *testing.T) {
+	assert.Equal(t, 1762, headerSize)
+	assert.Equal(t, 1154, eventSize)
+	assert.Equal(t, 1100, venueSize)
+	assert.Equal(t, 260, vehicleSize)
+	assert.Equal(t, 124, channelSize)
+}
+
+func TestWrite(t *testing.T) {
+	file := &File{
+		Date:      time.Date(2025, 3, 1, 14, 5, 9, 0, time.UTC),
+		Driver:    "Max Racer",
+		VehicleID: "ferrari_296_gt3",
+		Venue:     "monza",
+		Channels: []Channel{
+			{Name: "Speed", ShortName: "Speed", Unit: "km/h", Frequency: 10, Data: []float32{100, 150, 200}},
+			{Name: "Gear", ShortName: "Gear", Frequency: 10, Data: []float32{3, 4, 4}},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Write(buf, file))
+	data := buf.Bytes()
+
+	metaPtr := headerSize + eventSize + venueSize + vehicleSize
+	dataPtr := metaPtr + 2*channelSize
+	assert.Len(t, data, dataPtr+6*4)
+
+	header := ldHeader{}
+	assert.NoError(t, binary.Read(bytes.NewReader(data), binary.LittleEndian, &header))
+	assert.Equal(t, uint32(LD_MARKER), header.Marker)
+	assert.Equal(t, uint32(metaPtr), header.ChannelMetaPtr)
+	assert.Equal(t, uint32(dataPtr), header.ChannelDataPtr)
+	assert.Equal(t, uint32(2), header.NumChannels)
+	assert.Equal(t, "01/03/2025", string(bytes.TrimRight(header.Date[:], "\x00")))
+	assert.Equal(t, "14:05:09", string(bytes.TrimRight(header.Time[:], "\x00")))
+	assert.Equal(t, "monza", string(bytes.TrimRight(header.Venue[:], "\x00")))
+
+	second := ldChannel{}
+	assert.NoError(t, binary.Read(bytes.NewReader(data[metaPtr+channelSize:]), binary.LittleEndian, &second))
+	assert.Equal(t, uint32(metaPtr), second.PrevPtr)
+	assert.Zero(t, second.NextPtr)
+	assert.Equal(t, uint32(dataPtr+3*4), second.DataPtr)
+	assert.Equal(t, uint32(3), second.DataLen)
+	assert.Equal(t, uint16(dtypeAFloat), second.DtypeA)
+	assert.Equal(t, uint16(dtypeFloat), second.Dtype)
+	assert.Equal(t, "Gear", string(bytes.TrimRight(second.Name[:], "\x00")))
+
+	value := math.Float32frombits(binary.LittleEndian.Uint32(data[second.DataPtr+4:]))
+	assert.Equal(t, float32(4), value)
+}
+
+func TestRead(t *testing.T) {
+	file := &File{
+		Date:      time.Date(2025, 3, 1, 14, 5, 9, 0, time.Local),
+		Driver:    "Max Racer",
+		VehicleID: "ferrari_296_gt3",
+		Venue:     "monza",
+		Event:     "RaceMate",
+		Session:   "Practice",
+		Channels: []Channel{
+			{Name: "Speed", ShortName: "Speed", Unit: "km/h", Frequency: 10, Data: []float32{100, 150, 200}},
+			{Name: "Throttle Pos", ShortName: "Throttle", Unit: "%", Frequency: 20, Data: []float32{0, 50, 100, 100}},
+		},
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Write(buf, file))
+
+	read, err := Read(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, file, read)
+}
+
+func TestReadScaledIntegers(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Write(buf, &File{Channels: []Channel{{Name: "Speed", Frequency: 10, Data: []float32{0}}}}))
+	data := buf.Bytes()
+
+	// rewrite the channel as int16 values with 1 decimal place, e.g. 1234 is 123.4
+	metaPtr := headerSize + eventSize + venueSize + vehicleSize
+	meta := ldChannel{}
+	assert.NoError(t, binary.Read(bytes.NewReader(data[metaPtr:]), binary.LittleEndian, &meta))
+	meta.DtypeA, meta.Dtype, meta.DecPlaces, meta.DataLen = 3, 2, 1, 2
+	metaBuf := &bytes.Buffer{}
+	assert.NoError(t, binary.Write(metaBuf, binary.LittleEndian, meta))
+	copy(data[metaPtr:], metaBuf.Bytes())
+	binary.LittleEndian.PutUint16(data[meta.DataPtr:], 1234)
+	binary.LittleEndian.PutUint16(data[meta.DataPtr+2:], uint16(0xffff-9))
+
+	read, err := Read(data)
+	assert.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{123.4, -1}, read.Channels[0].Data, 0.001)
+}
+
+func TestReadNotLd(t *testing.T) {
+	_, err := Read([]byte("Time [s],Speed [km/h]\n"))
+	assert.Error(t, err)
+
+	_, err = Read(make([]byte, headerSize))
+	assert.ErrorContains(t, err, "not an ld file")
+}
@@ -0,0 +1,287 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	"github.com/sparkoo/racemate-desktop/pkg/webserver"
+)
+
+// CURRENT_VERSION is the version of the settings file written by this app
+const CURRENT_VERSION = 1
+
+// SETTINGS_FILE is the name of the settings file in the data directory
+const SETTINGS_FILE = "settings.json"
+
+// Settings holds user editable app settings persisted in the data directory
+type Settings struct {
+	Version         int    `json:"version"`
+	PollRateMs      int    `json:"pollRateMs"`
+	UploadURL       string `json:"uploadURL"`
+	AutoUpload      bool   `json:"autoUpload"`
+	LogLevel        string `json:"logLevel"`
+	WebServerPort   int    `json:"webServerPort"`
+	RecordTelemetry bool   `json:"recordTelemetry"`
+	// PBByConditions tracks personal bests separately for every track grip and rain
+	PBByConditions bool `json:"pbByConditions"`
+	// UploadWorkers is the number of laps uploaded at once
+	UploadWorkers int `json:"uploadWorkers"`
+	// UploadBandwidthKBps caps the upload speed of all the workers together, 0 is unlimited
+	UploadBandwidthKBps int `json:"uploadBandwidthKBps"`
+	// UploadDestinations receive every lap, empty uploads only to UploadURL
+	UploadDestinations []state.UploadDestination `json:"uploadDestinations,omitempty"`
+	// OIDCIssuer is the OpenID Connect provider to log in with instead of Firebase, empty uses Firebase
+	OIDCIssuer   string `json:"oidcIssuer,omitempty"`
+	OIDCClientID string `json:"oidcClientId,omitempty"`
+	// CredentialStore is where the login tokens are kept, "keyring" or "file", empty uses the keyring when available
+	CredentialStore string `json:"credentialStore,omitempty"`
+}
+
+// LOG_LEVELS are the log levels that can be set
+var LOG_LEVELS = []string{"debug", "info", "warn", "error"}
+
+// Default returns the settings used when there is no settings file
+func Default() *Settings {
+	return &Settings{
+		Version:         CURRENT_VERSION,
+		PollRateMs:      100,
+		UploadURL:       "https://lapupload-hwppiybqxq-ey.a.run.app",
+		AutoUpload:      true,
+		LogLevel:        "info",
+		WebServerPort:   webserver.DEFAULT_PORT,
+		RecordTelemetry: false,
+		PBByConditions:  false,
+		UploadWorkers:   4,
+	}
+}
+
+// migrations upgrade raw settings of version i to version i+1
+var migrations = []func(raw map[string]any){
+	// 0 -> 1: files written by hand before the settings were versioned, missing values take the defaults
+	func(raw map[string]any) {},
+}
+
+// Load reads the settings from the data directory. Missing file gives default settings.
+// Older versions are migrated, missing values are filled with defaults.
+func Load(dataDir string) (*Settings, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, SETTINGS_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Default(), nil
+		}
+		return nil, fmt.Errorf("failed to read settings file: %w", err)
+	}
+
+	raw := map[string]any{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse settings file: %w", err)
+	}
+
+	version := 0
+	if v, ok := raw["version"].(float64); ok {
+		version = int(v)
+	}
+	if version > CURRENT_VERSION {
+		return nil, fmt.Errorf("settings file version %d is newer than supported version %d", version, CURRENT_VERSION)
+	}
+	for ; version < CURRENT_VERSION; version++ {
+		migrations[version](raw)
+	}
+	raw["version"] = CURRENT_VERSION
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate settings: %w", err)
+	}
+	settings := Default()
+	if err := json.Unmarshal(migrated, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings file: %w", err)
+	}
+
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings file: %w", err)
+	}
+	return settings, nil
+}
+
+// Save validates and writes the settings into the data directory
+func (s *Settings) Save(dataDir string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	s.Version = CURRENT_VERSION
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+
+	// write to temporary file first so we never leave half written settings
+	settingsFile := filepath.Join(dataDir, SETTINGS_FILE)
+	tmpFile := settingsFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write settings file: %w", err)
+	}
+	if err := os.Rename(tmpFile, settingsFile); err != nil {
+		return fmt.Errorf("failed to replace settings file: %w", err)
+	}
+	return nil
+}
+
+// MoveSecrets moves the secret keys of the upload destinations into the credential store, only the references
+// to them are kept in the settings. Returns whether anything was moved, so the settings are saved.
+func (s *Settings) MoveSecrets(appState *state.AppState) (bool, error) {
+	moved := false
+	for i, destination := range s.UploadDestinations {
+		if destination.SecretAccessKey == "" {
+			continue
+		}
+		ref, err := auth.SaveSecret(appState, "upload/"+destination.DisplayName(), destination.SecretAccessKey)
+		if err != nil {
+			return moved, fmt.Errorf("failed to store the secret of upload destination '%s': %w", destination.DisplayName(), err)
+		}
+		s.UploadDestinations[i].SecretAccessKey = ""
+		s.UploadDestinations[i].SecretRef = ref
+		moved = true
+	}
+	return moved, nil
+}
+
+// Validate checks that all the values are in allowed ranges
+func (s *Settings) Validate() error {
+	if s.PollRateMs < 10 || s.PollRateMs > 1000 {
+		return fmt.Errorf("poll rate must be between 10 and 1000 ms, got %d", s.PollRateMs)
+	}
+
+	if !isHTTPURL(s.UploadURL) {
+		return fmt.Errorf("upload URL must be http or https URL, got '%s'", s.UploadURL)
+	}
+
+	if _, err := ParseLogLevel(s.LogLevel); err != nil {
+		return err
+	}
+
+	if s.WebServerPort < 1024 || s.WebServerPort > 65535 {
+		return fmt.Errorf("web server port must be between 1024 and 65535, got %d", s.WebServerPort)
+	}
+
+	if s.UploadWorkers < 1 || s.UploadWorkers > 16 {
+		return fmt.Errorf("upload workers must be between 1 and 16, got %d", s.UploadWorkers)
+	}
+
+	if s.UploadBandwidthKBps < 0 {
+		return fmt.Errorf("upload bandwidth can't be negative, got %d", s.UploadBandwidthKBps)
+	}
+
+	if s.OIDCIssuer != "" {
+		issuer, err := url.Parse(s.OIDCIssuer)
+		if err != nil || issuer.Host == "" || (issuer.Scheme != "https" && !(issuer.Scheme == "http" && isLoopback(issuer.Hostname()))) {
+			return fmt.Errorf("OpenID Connect issuer must be https URL, got '%s'", s.OIDCIssuer)
+		}
+		if s.OIDCClientID == "" {
+			return fmt.Errorf("OpenID Connect client ID is required with the issuer")
+		}
+	}
+
+	if s.CredentialStore != "" && s.CredentialStore != auth.SECRET_STORE_KEYRING && s.CredentialStore != auth.SECRET_STORE_FILE {
+		return fmt.Errorf("credential store must be '%s' or '%s', got '%s'", auth.SECRET_STORE_KEYRING, auth.SECRET_STORE_FILE, s.CredentialStore)
+	}
+
+	names := map[string]bool{}
+	for _, destination := range s.UploadDestinations {
+		if err := validateDestination(destination); err != nil {
+			return err
+		}
+		if names[destination.DisplayName()] {
+			return fmt.Errorf("upload destination '%s' is set twice, give them different names", destination.DisplayName())
+		}
+		names[destination.DisplayName()] = true
+	}
+
+	return nil
+}
+
+// validateDestination checks that the destination has all the values of its type
+func validateDestination(destination state.UploadDestination) error {
+	switch destination.Type {
+	case state.DESTINATION_RACEMATE:
+		if destination.URL != "" && !isHTTPURL(destination.URL) {
+			return fmt.Errorf("upload destination '%s': URL must be http or https URL, got '%s'", destination.DisplayName(), destination.URL)
+		}
+	case state.DESTINATION_FOLDER:
+		if destination.Path == "" {
+			return fmt.Errorf("upload destination '%s': path is missing", destination.DisplayName())
+		}
+	case state.DESTINATION_S3:
+		if !isHTTPURL(destination.Endpoint) {
+			return fmt.Errorf("upload destination '%s': endpoint must be http or https URL, got '%s'", destination.DisplayName(), destination.Endpoint)
+		}
+		if destination.Bucket == "" || destination.AccessKeyID == "" || (destination.SecretAccessKey == "" && destination.SecretRef == "") {
+			return fmt.Errorf("upload destination '%s': bucket and access keys are required", destination.DisplayName())
+		}
+	default:
+		return fmt.Errorf("unknown upload destination type '%s', use one of %s, %s, %s", destination.Type,
+			state.DESTINATION_RACEMATE, state.DESTINATION_FOLDER, state.DESTINATION_S3)
+	}
+	return nil
+}
+
+// isLoopback says whether the host is this computer, e.g. the provider running locally for development
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isHTTPURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Apply sets the settings to the app state, the running jobs pick them up
+func (s *Settings) Apply(appState *state.AppState) {
+	appState.SetSettings(state.UserSettings{
+		PollRate:           time.Duration(s.PollRateMs) * time.Millisecond,
+		UploadURL:          s.UploadURL,
+		AutoUpload:         s.AutoUpload,
+		WebServerPort:      s.WebServerPort,
+		RecordTelemetry:    s.RecordTelemetry,
+		PBByConditions:     s.PBByConditions,
+		UploadWorkers:      s.UploadWorkers,
+		UploadBandwidth:    int64(s.UploadBandwidthKBps) * 1024,
+		UploadDestinations: slices.Clone(s.UploadDestinations),
+		OIDCIssuer:         s.OIDCIssuer,
+		OIDCClientID:       s.OIDCClientID,
+		CredentialStore:    s.CredentialStore,
+	})
+	if level, err := ParseLogLevel(s.LogLevel); err == nil && appState.LogLevel != nil {
+		appState.LogLevel.Set(level)
+	}
+}
+
+// ParseLogLevel converts the log level name to slog level
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level '%s', use one of %s", name, strings.Join(LOG_LEVELS, ", "))
+}
@@ -0,0 +1,185 @@
+package settings
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadNoFile(t *testing.T) {
+	settings, err := Load(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), settings)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dataDir := t.TempDir()
+
+	settings := Default()
+	settings.PollRateMs = 50
+	settings.UploadURL = "http://localhost:8080/upload"
+	settings.AutoUpload = false
+	settings.LogLevel = "debug"
+	assert.NoError(t, settings.Save(dataDir))
+
+	loaded, err := Load(dataDir)
+	assert.NoError(t, err)
+	assert.Equal(t, settings, loaded)
+
+	// no temporary file is left behind
+	_, err = os.Stat(filepath.Join(dataDir, SETTINGS_FILE+".tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadUnversionedFile(t *testing.T) {
+	dataDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dataDir, SETTINGS_FILE), []byte(`{"pollRateMs": 200}`), 0644)
+	assert.NoError(t, err)
+
+	settings, err := Load(dataDir)
+	assert.NoError(t, err)
+	assert.Equal(t, CURRENT_VERSION, settings.Version)
+	assert.Equal(t, 200, settings.PollRateMs)
+	// missing values take defaults
+	assert.Equal(t, Default().UploadURL, settings.UploadURL)
+	assert.True(t, settings.AutoUpload)
+}
+
+func TestLoadNewerVersion(t *testing.T) {
+	dataDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dataDir, SETTINGS_FILE), []byte(`{"version": 99}`), 0644)
+	assert.NoError(t, err)
+
+	_, err = Load(dataDir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than supported")
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	dataDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dataDir, SETTINGS_FILE), []byte(`{"version": 1, "pollRateMs": 0}`), 0644)
+	assert.NoError(t, err)
+
+	_, err = Load(dataDir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "poll rate")
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+
+	tests := map[string]func(s *Settings){
+		"poll rate":  func(s *Settings) { s.PollRateMs = 5000 },
+		"upload URL": func(s *Settings) { s.UploadURL = "ftp://example.com" },
+		"log level":  func(s *Settings) { s.LogLevel = "verbose" },
+		"port":       func(s *Settings) { s.WebServerPort = 80 },
+		"workers":    func(s *Settings) { s.UploadWorkers = 0 },
+		"bandwidth":  func(s *Settings) { s.UploadBandwidthKBps = -1 },
+		"destination type": func(s *Settings) {
+			s.UploadDestinations = []state.UploadDestination{{Type: "ftp"}}
+		},
+		"folder without path": func(s *Settings) {
+			s.UploadDestinations = []state.UploadDestination{{Type: state.DESTINATION_FOLDER}}
+		},
+		"s3 without keys": func(s *Settings) {
+			s.UploadDestinations = []state.UploadDestination{{Type: state.DESTINATION_S3, Endpoint: "http://localhost:9000", Bucket: "laps"}}
+		},
+		"plain http issuer": func(s *Settings) {
+			s.OIDCIssuer = "http://login.example.com"
+			s.OIDCClientID = "racemate"
+		},
+		"issuer without client":    func(s *Settings) { s.OIDCIssuer = "https://login.example.com" },
+		"unknown credential store": func(s *Settings) { s.CredentialStore = "vault" },
+		"same destination twice": func(s *Settings) {
+			s.UploadDestinations = []state.UploadDestination{{Type: state.DESTINATION_RACEMATE}, {Type: state.DESTINATION_RACEMATE}}
+		},
+	}
+	for expected, modify := range tests {
+		settings := Default()
+		modify(settings)
+		err := settings.Validate()
+		assert.Error(t, err, expected)
+	}
+}
+
+func TestMoveSecrets(t *testing.T) {
+	dataDir := t.TempDir()
+	appState := &state.AppState{DataDir: dataDir, UserSettings: state.UserSettings{CredentialStore: auth.SECRET_STORE_FILE},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	settings := Default()
+	settings.UploadDestinations = []state.UploadDestination{
+		{Type: state.DESTINATION_FOLDER, Path: "/mnt/team/laps"},
+		{Type: state.DESTINATION_S3, Name: "minio", Endpoint: "http://localhost:9000", Bucket: "laps", AccessKeyID: "racemate", SecretAccessKey: "racemate-secret"},
+	}
+
+	moved, err := settings.MoveSecrets(appState)
+	assert.NoError(t, err)
+	assert.True(t, moved)
+	assert.Empty(t, settings.UploadDestinations[1].SecretAccessKey)
+	secret, err := auth.LoadSecret(appState, settings.UploadDestinations[1].SecretRef)
+	assert.NoError(t, err)
+	assert.Equal(t, "racemate-secret", secret)
+
+	// only the reference is written
+	assert.NoError(t, settings.Save(dataDir))
+	data, err := os.ReadFile(filepath.Join(dataDir, SETTINGS_FILE))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "racemate-secret")
+
+	moved, err = settings.MoveSecrets(appState)
+	assert.NoError(t, err)
+	assert.False(t, moved)
+}
+
+func TestSaveInvalid(t *testing.T) {
+	dataDir := t.TempDir()
+	settings := Default()
+	settings.UploadURL = ""
+
+	assert.Error(t, settings.Save(dataDir))
+	_, err := os.Stat(filepath.Join(dataDir, SETTINGS_FILE))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApply(t *testing.T) {
+	appState := &state.AppState{LogLevel: &slog.LevelVar{}}
+	settings := Default()
+	settings.PollRateMs = 250
+	settings.LogLevel = "warn"
+	settings.RecordTelemetry = true
+	settings.PBByConditions = true
+	settings.UploadWorkers = 2
+	settings.UploadBandwidthKBps = 512
+	settings.UploadDestinations = []state.UploadDestination{
+		{Type: state.DESTINATION_RACEMATE},
+		{Type: state.DESTINATION_FOLDER, Name: "nas", Path: "/mnt/team/laps"},
+	}
+
+	settings.OIDCIssuer = "https://login.example.com"
+	settings.OIDCClientID = "racemate-desktop"
+	settings.CredentialStore = "file"
+	assert.NoError(t, settings.Validate())
+
+	settings.Apply(appState)
+
+	assert.Equal(t, 250*time.Millisecond, appState.PollRate)
+	assert.Equal(t, settings.UploadURL, appState.UploadURL)
+	assert.True(t, appState.AutoUpload)
+	assert.True(t, appState.RecordTelemetry)
+	assert.True(t, appState.PBByConditions)
+	assert.Equal(t, 2, appState.UploadWorkers)
+	assert.Equal(t, int64(512*1024), appState.UploadBandwidth)
+	assert.Equal(t, settings.UploadDestinations, appState.UploadDestinations)
+	assert.Equal(t, "https://login.example.com", appState.OIDCIssuer)
+	assert.Equal(t, "racemate-desktop", appState.OIDCClientID)
+	assert.Equal(t, "file", appState.CredentialStore)
+	assert.Equal(t, settings.WebServerPort, appState.WebServerPort)
+	assert.Equal(t, slog.LevelWarn, appState.LogLevel.Level())
+}
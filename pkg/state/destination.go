@@ -0,0 +1,40 @@
+package state
+
+// Types of the upload destinations
+const (
+	// DESTINATION_RACEMATE is the RaceMate upload endpoint
+	DESTINATION_RACEMATE = "racemate"
+	// DESTINATION_FOLDER is the local or network folder, e.g. the team NAS
+	DESTINATION_FOLDER = "folder"
+	// DESTINATION_S3 is the S3 compatible object store
+	DESTINATION_S3 = "s3"
+)
+
+// UploadDestination is where the laps are uploaded. Only the fields of its type are used.
+type UploadDestination struct {
+	Type string `json:"type"`
+	// Name identifies the destination in the logs, defaults to the type
+	Name string `json:"name,omitempty"`
+	// URL of the RaceMate endpoint, defaults to the upload URL
+	URL string `json:"url,omitempty"`
+	// Path of the folder
+	Path string `json:"path,omitempty"`
+	// Endpoint of the object store, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint    string `json:"endpoint,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Bucket      string `json:"bucket,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	// SecretAccessKey is set by the user, on the start it is moved into the credential store and SecretRef
+	// refers to it then
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SecretRef       string `json:"secretRef,omitempty"`
+}
+
+// DisplayName returns the name of the destination
+func (d UploadDestination) DisplayName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.Type
+}
@@ -0,0 +1,60 @@
+package state
+
+// LiveDelta is the difference of the lap being driven to the best lap at the current position
+type LiveDelta struct {
+	Track    string
+	CarModel string
+	// Valid is false when there is no best lap to compare with or the session is over
+	Valid              bool
+	Position           float32
+	DeltaMs            int32
+	ReferenceLapTimeMs int32
+}
+
+// SetLiveDelta publishes the delta to all the subscribers. Subscribers that are not keeping up get only the latest delta.
+func (a *AppState) SetLiveDelta(delta LiveDelta) {
+	a.liveDeltaMu.Lock()
+	defer a.liveDeltaMu.Unlock()
+
+	a.liveDelta = delta
+	for _, subscriber := range a.liveDeltaSubscribers {
+		select {
+		case subscriber <- delta:
+		default:
+			// replace the delta the subscriber didn't read yet
+			select {
+			case <-subscriber:
+			default:
+			}
+			subscriber <- delta
+		}
+	}
+}
+
+// LiveDelta returns the last published delta
+func (a *AppState) LiveDelta() LiveDelta {
+	a.liveDeltaMu.Lock()
+	defer a.liveDeltaMu.Unlock()
+	return a.liveDelta
+}
+
+// SubscribeLiveDelta returns the channel receiving the deltas as they are published and the function
+// to cancel the subscription
+func (a *AppState) SubscribeLiveDelta() (<-chan LiveDelta, func()) {
+	a.liveDeltaMu.Lock()
+	defer a.liveDeltaMu.Unlock()
+
+	subscriber := make(chan LiveDelta, 1)
+	a.liveDeltaSubscribers = append(a.liveDeltaSubscribers, subscriber)
+	return subscriber, func() {
+		a.liveDeltaMu.Lock()
+		defer a.liveDeltaMu.Unlock()
+		for i, s := range a.liveDeltaSubscribers {
+			if s == subscriber {
+				a.liveDeltaSubscribers = append(a.liveDeltaSubscribers[:i], a.liveDeltaSubscribers[i+1:]...)
+				close(subscriber)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveDeltaSubscribe(t *testing.T) {
+	appState := &AppState{}
+	deltas, cancel := appState.SubscribeLiveDelta()
+
+	appState.SetLiveDelta(LiveDelta{Valid: true, DeltaMs: 100})
+	assert.Equal(t, int32(100), (<-deltas).DeltaMs)
+
+	// slow subscriber gets only the latest delta
+	appState.SetLiveDelta(LiveDelta{Valid: true, DeltaMs: 200})
+	appState.SetLiveDelta(LiveDelta{Valid: true, DeltaMs: 300})
+	assert.Equal(t, int32(300), (<-deltas).DeltaMs)
+	assert.Equal(t, int32(300), appState.LiveDelta().DeltaMs)
+
+	cancel()
+	_, open := <-deltas
+	assert.False(t, open)
+	// publishing without subscribers works
+	appState.SetLiveDelta(LiveDelta{})
+	assert.False(t, appState.LiveDelta().Valid)
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/library"
 )
 
 type raceMateContextKey string
@@ -18,10 +22,65 @@ type AppState struct {
 	UploadDir       string
 	UploadedDir     string
 	LogsDir         string
+	RecordingsDir   string
+	QuarantineDir   string
+	RejectedDir     string
 	Error           error
-	PollRate        time.Duration
 	Logger          *slog.Logger
+	LogLevel        *slog.LevelVar
+	Library         *library.Library
+	// UserSettings change when the user saves the settings, the running jobs read them with Settings
+	UserSettings
+	// Notifier shows the message to the user, e.g. as the tray notification. Can be nil when running headless.
+	Notifier func(title, content string)
+
+	settingsMu           sync.RWMutex
+	liveDeltaMu          sync.Mutex
+	liveDelta            LiveDelta
+	liveDeltaSubscribers []chan LiveDelta
+}
+
+// UserSettings are the fields of the app state set by the user
+type UserSettings struct {
+	PollRate        time.Duration
 	UploadURL       string
+	RecordTelemetry bool
+	AutoUpload      bool
+	WebServerPort   int
+	PBByConditions  bool
+	UploadWorkers   int
+	// UploadBandwidth is the upload speed cap in bytes per second, 0 is unlimited
+	UploadBandwidth int64
+	// UploadDestinations receive every lap, the RaceMate endpoint at UploadURL when empty
+	UploadDestinations []UploadDestination
+	// OIDCIssuer and OIDCClientID set the OpenID Connect provider to log in with instead of Firebase
+	OIDCIssuer   string
+	OIDCClientID string
+	// CredentialStore is where the tokens are kept, "keyring", "file" or empty for the keyring when available
+	CredentialStore string
+}
+
+// Settings returns the copy of the user settings, safe to read while the user changes them
+func (a *AppState) Settings() UserSettings {
+	a.settingsMu.RLock()
+	defer a.settingsMu.RUnlock()
+	settings := a.UserSettings
+	settings.UploadDestinations = slices.Clone(settings.UploadDestinations)
+	return settings
+}
+
+// SetSettings replaces the user settings while the jobs are running
+func (a *AppState) SetSettings(settings UserSettings) {
+	a.settingsMu.Lock()
+	defer a.settingsMu.Unlock()
+	a.UserSettings = settings
+}
+
+// Notify shows the message to the user if there is a way to do it
+func (a *AppState) Notify(title, content string) {
+	if a.Notifier != nil {
+		a.Notifier(title, content)
+	}
 }
 
 func GetAppState(ctx context.Context) (*AppState, error) {
@@ -0,0 +1,35 @@
+package state
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings(t *testing.T) {
+	appState := &AppState{}
+	appState.SetSettings(UserSettings{PollRate: time.Second, UploadDestinations: []UploadDestination{{Type: DESTINATION_FOLDER}}})
+
+	settings := appState.Settings()
+	assert.Equal(t, time.Second, settings.PollRate)
+	// the copy doesn't change the settings
+	settings.UploadDestinations[0].Path = "/mnt/laps"
+	assert.Empty(t, appState.Settings().UploadDestinations[0].Path)
+
+	// the settings are changed while the jobs read them
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			appState.SetSettings(UserSettings{PollRate: time.Duration(i) * time.Millisecond})
+		}()
+		go func() {
+			defer wg.Done()
+			appState.Settings()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,177 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// BatchOptions of the batch upload
+type BatchOptions struct {
+	// Workers is the number of laps uploaded at once
+	Workers int
+	// BytesPerSecond caps the upload speed of all the workers together, 0 is unlimited
+	BytesPerSecond int64
+	// Force uploads also the laps waiting for the retry
+	Force bool
+}
+
+// batchOptions returns the options set by the user
+func batchOptions(appState *state.AppState, force bool) BatchOptions {
+	settings := appState.Settings()
+	return BatchOptions{Workers: settings.UploadWorkers, BytesPerSecond: settings.UploadBandwidth, Force: force}
+}
+
+// UploadBatch uploads the waiting laps concurrently. Each lap is moved to the uploaded directory once its upload
+// succeeds. After the first failure no more laps are started, the laps in flight are finished and the failure
+// is returned. Only one batch runs at a time, the next one waits. Returns number of uploaded laps.
+func UploadBatch(ctx context.Context, appState *state.AppState, options BatchOptions) (int, error) {
+	queue.running.Lock()
+	defer queue.running.Unlock()
+
+	entries, err := os.ReadDir(appState.UploadDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read upload directory: %w", err)
+	}
+
+	quarantineTempFiles(appState, entries)
+
+	retries := loadRetries(appState)
+	retries.prune(entries)
+	defer func() {
+		if err := retries.save(); err != nil {
+			appState.Logger.Warn("Failed to save upload retries", "error", err)
+		}
+	}()
+
+	now := time.Now()
+	var laps []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), lapfile.SUFFIX) {
+			continue
+		}
+		if options.Force || retries.ready(entry.Name(), now) {
+			laps = append(laps, entry.Name())
+		}
+	}
+	if len(laps) == 0 {
+		return 0, nil
+	}
+
+	// refresh the expired login once, not in every worker
+	if NeedsLogin(appState) {
+		auth.NewAuthManager(appState).IsLoggedIn()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	limiter := newBandwidthLimiter(options.BytesPerSecond)
+	names := make(chan string)
+	var (
+		mu       sync.Mutex
+		uploaded int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < max(options.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				// the lap might have been dispatched together with the failure
+				if ctx.Err() != nil {
+					continue
+				}
+				lapUploaded, err := uploadLap(appState, retries, name, limiter)
+				mu.Lock()
+				if lapUploaded {
+					uploaded++
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, name := range laps {
+		select {
+		case names <- name:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(names)
+	wg.Wait()
+
+	appState.Logger.Info("Batch upload finished", "uploaded", uploaded, "laps", len(laps))
+	return uploaded, firstErr
+}
+
+// bandwidthLimiter spreads the writes of all the uploads in time so they don't exceed the speed
+type bandwidthLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	// next is when the next write may start
+	next time.Time
+}
+
+// newBandwidthLimiter returns nil for the unlimited speed
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until n bytes can be sent
+func (l *bandwidthLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.bytesPerSecond))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// reader limits the speed of reading the body of the request
+func (l *bandwidthLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: l}
+}
+
+// limitedReader reads in small chunks, so the concurrent uploads share the bandwidth evenly
+type limitedReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+const limitedChunkSize = 16 * 1024
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedChunkSize {
+		p = p[:limitedChunkSize]
+	}
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,149 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadBatch(t *testing.T) {
+	appState, _ := setupTestUpload(t)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight, requests := 0, 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			return
+		}
+		mu.Lock()
+		inFlight++
+		requests++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+	appState.UploadURL = server.URL
+
+	var files []string
+	for i := 0; i < 9; i++ {
+		files = append(files, saveTestLap(t, appState, uint64(1740000000+i)))
+	}
+
+	uploaded, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, uploaded)
+	assert.Equal(t, 9, requests)
+	assert.Equal(t, 3, maxInFlight)
+	for _, file := range files {
+		assert.FileExists(t, filepath.Join(appState.UploadedDir, file))
+	}
+	assert.False(t, hasLapsToUpload(appState))
+}
+
+func TestUploadBatchStopsAfterFailure(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	for i := 0; i < 4; i++ {
+		saveTestLap(t, appState, uint64(1740000000+i))
+	}
+	server.statuses = []int{http.StatusOK, http.StatusServiceUnavailable}
+
+	uploaded, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 1})
+	var failure *Failure
+	assert.ErrorAs(t, err, &failure)
+	assert.Equal(t, 1, uploaded)
+	assert.Equal(t, 2, server.requests)
+
+	// only the successful upload is moved
+	entries, err := os.ReadDir(appState.UploadedDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.True(t, hasLapsToUpload(appState))
+}
+
+func TestUploadBatchSkipsWaitingLaps(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	waiting := saveTestLap(t, appState, 1740000000)
+	saveTestLap(t, appState, 1740000100)
+	server.statuses = []int{http.StatusServiceUnavailable}
+	assert.Error(t, runBatch(appState))
+
+	uploaded, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, uploaded)
+	assert.FileExists(t, filepath.Join(appState.UploadDir, waiting))
+
+	uploaded, err = UploadBatch(context.Background(), appState, BatchOptions{Workers: 2, Force: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, uploaded)
+	assert.NoFileExists(t, filepath.Join(appState.UploadDir, waiting))
+}
+
+func TestUploadBatchSkipsNewerVersion(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	newer := saveTestLap(t, appState, 1740000000)
+	data, err := os.ReadFile(filepath.Join(appState.UploadDir, newer))
+	assert.NoError(t, err)
+	data[6] = lapfile.SCHEMA_VERSION + 1
+	assert.NoError(t, os.WriteFile(filepath.Join(appState.UploadDir, newer), data, 0644))
+	saveTestLap(t, appState, 1740000100)
+	saveTestLap(t, appState, 1740000200)
+
+	uploaded, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, uploaded)
+	assert.Equal(t, 2, server.requests)
+
+	// the lap waits for the update of the app, it is not tried on every run
+	assert.FileExists(t, filepath.Join(appState.UploadDir, newer))
+	retry := loadRetries(appState).Laps[newer]
+	assert.Equal(t, FAILURE_UNSUPPORTED, retry.LastKind)
+	assert.Zero(t, retry.Attempts)
+	assert.True(t, retry.NextAttempt.After(time.Now().Add(59*time.Minute)))
+
+	uploaded, err = UploadBatch(context.Background(), appState, BatchOptions{Workers: 1})
+	assert.NoError(t, err)
+	assert.Zero(t, uploaded)
+	assert.Equal(t, 2, server.requests)
+}
+
+func TestUploadBatchBandwidth(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	payload, err := lapfile.ReadPayload(filepath.Join(appState.UploadDir, file))
+	assert.NoError(t, err)
+
+	uploaded, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 2, BytesPerSecond: 1024})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, uploaded)
+	assert.Equal(t, [][]byte{payload}, server.uploads)
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	limiter := newBandwidthLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.wait(100)
+	}
+	// the first write starts immediately, the others wait for the previous ones
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	unlimited := newBandwidthLimiter(0)
+	assert.Nil(t, unlimited)
+	data, err := io.ReadAll(unlimited.reader(bytes.NewReader([]byte("lap"))))
+	assert.NoError(t, err)
+	assert.Equal(t, "lap", string(data))
+}
@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// Uploader sends the laps to one destination
+type Uploader interface {
+	// Name identifies the destination in the logs and in the retries
+	Name() string
+	// Upload sends the lap. The errors are *Failure, so the upload is retried or the lap rejected by its kind.
+	Upload(lap *LapUpload) error
+}
+
+// LapUpload is the lap being uploaded
+type LapUpload struct {
+	// Name is the name of the lap file
+	Name string
+	// Payload is the gzip compressed protobuf of the lap
+	Payload []byte
+
+	limiter *bandwidthLimiter
+	retries *retries
+}
+
+// body returns the reader of the payload limited to the upload speed
+func (l *LapUpload) body() io.Reader {
+	return l.limiter.reader(bytes.NewReader(l.Payload))
+}
+
+// NeedsLogin reports whether the laps go to the RaceMate endpoint, which accepts them only from the logged in user.
+// The folder and S3 destinations upload without the login.
+func NeedsLogin(appState *state.AppState) bool {
+	destinations := appState.Settings().UploadDestinations
+	if len(destinations) == 0 {
+		return true
+	}
+	for _, destination := range destinations {
+		if destination.Type == state.DESTINATION_RACEMATE {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUploaders creates the uploaders of the destinations set by the user. Without any destination the laps are
+// uploaded to the RaceMate endpoint at the upload URL.
+func NewUploaders(appState *state.AppState) ([]Uploader, error) {
+	settings := appState.Settings()
+	destinations := settings.UploadDestinations
+	if len(destinations) == 0 {
+		destinations = []state.UploadDestination{{Type: state.DESTINATION_RACEMATE}}
+	}
+
+	uploaders := make([]Uploader, 0, len(destinations))
+	for _, destination := range destinations {
+		switch destination.Type {
+		case state.DESTINATION_RACEMATE:
+			url := destination.URL
+			if url == "" {
+				url = settings.UploadURL
+			}
+			uploaders = append(uploaders, &httpUploader{name: destination.DisplayName(), url: url, appState: appState})
+		case state.DESTINATION_FOLDER:
+			uploaders = append(uploaders, &folderUploader{name: destination.DisplayName(), path: destination.Path})
+		case state.DESTINATION_S3:
+			if destination.SecretAccessKey == "" {
+				secret, err := auth.LoadSecret(appState, destination.SecretRef)
+				if err != nil {
+					return nil, fmt.Errorf("failed to load the secret key of upload destination '%s': %w", destination.DisplayName(), err)
+				}
+				destination.SecretAccessKey = secret
+			}
+			uploaders = append(uploaders, newS3Uploader(destination, &http.Client{}))
+		default:
+			return nil, fmt.Errorf("unknown upload destination type '%s'", destination.Type)
+		}
+	}
+	return uploaders, nil
+}
@@ -0,0 +1,123 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// FailureKind classifies why the upload failed
+type FailureKind string
+
+const (
+	// FAILURE_NETWORK is the server that can't be reached
+	FAILURE_NETWORK FailureKind = "network"
+	// FAILURE_AUTH is the missing or expired login, 401 and 403
+	FAILURE_AUTH FailureKind = "auth"
+	// FAILURE_REJECTED is the lap the server refused as invalid, other 4xx. Uploading it again won't help.
+	FAILURE_REJECTED FailureKind = "rejected"
+	// FAILURE_SERVER is the server error, 5xx, timeouts and rate limiting
+	FAILURE_SERVER FailureKind = "server"
+	// FAILURE_CONFIG is the destination set up wrong by the user, e.g. the missing bucket or the wrong region.
+	// It is retried until the user fixes the settings.
+	FAILURE_CONFIG FailureKind = "config"
+	// FAILURE_UNSUPPORTED is the lap file written by the newer version of the app, it waits for the update
+	FAILURE_UNSUPPORTED FailureKind = "unsupported"
+)
+
+// REJECTED_SIDECAR_SUFFIX is the suffix of the file explaining why the lap was rejected
+const REJECTED_SIDECAR_SUFFIX = ".rejected.json"
+
+// maxReasonLength limits how much of the server response is kept
+const maxReasonLength = 1024
+
+// Failure is the classified upload error
+type Failure struct {
+	Kind       FailureKind
+	StatusCode int
+	// Reason is the response of the server
+	Reason string
+	// RetryAfter is the delay the server asked for with Retry-After header
+	RetryAfter time.Duration
+	// NextAttempt is when the lap is tried again
+	NextAttempt time.Time
+	Err         error
+}
+
+func (f *Failure) Error() string {
+	if f.StatusCode != 0 {
+		return fmt.Sprintf("upload failed (%s) with status code %d: %s", f.Kind, f.StatusCode, f.Reason)
+	}
+	return fmt.Sprintf("upload failed (%s): %v", f.Kind, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Permanent says whether the upload fails the same way when it is retried
+func (f *Failure) Permanent() bool {
+	return f.Kind == FAILURE_REJECTED
+}
+
+// classifyStatus returns the failure of the response status code
+func classifyStatus(statusCode int, body []byte) *Failure {
+	reason := string(body)
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+	failure := &Failure{StatusCode: statusCode, Reason: reason}
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		failure.Kind = FAILURE_AUTH
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests:
+		failure.Kind = FAILURE_SERVER
+	case statusCode >= 400 && statusCode < 500:
+		failure.Kind = FAILURE_REJECTED
+	default:
+		failure.Kind = FAILURE_SERVER
+	}
+	return failure
+}
+
+// Rejection is the sidecar file stored next to the rejected lap
+type Rejection struct {
+	File       string      `json:"file"`
+	RejectedAt time.Time   `json:"rejectedAt"`
+	Kind       FailureKind `json:"kind"`
+	StatusCode int         `json:"statusCode,omitempty"`
+	Reason     string      `json:"reason"`
+}
+
+// reject moves the lap file to the rejected directory and writes the sidecar with the reason
+func reject(appState *state.AppState, filename string, failure *Failure) error {
+	if err := os.MkdirAll(appState.RejectedDir, 0755); err != nil {
+		return fmt.Errorf("failed to create rejected directory: %w", err)
+	}
+
+	name := filepath.Base(filename)
+	rejection := Rejection{
+		File:       name,
+		RejectedAt: time.Now(),
+		Kind:       failure.Kind,
+		StatusCode: failure.StatusCode,
+		Reason:     failure.Reason,
+	}
+	data, err := json.MarshalIndent(rejection, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal the rejection: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(appState.RejectedDir, name+REJECTED_SIDECAR_SUFFIX), data, 0644); err != nil {
+		return fmt.Errorf("failed to write the rejection: %w", err)
+	}
+
+	if err := os.Rename(filename, filepath.Join(appState.RejectedDir, name)); err != nil {
+		return fmt.Errorf("failed to move the file '%s' to rejected directory: %w", name, err)
+	}
+	return nil
+}
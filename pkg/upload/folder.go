@@ -0,0 +1,48 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+)
+
+// folderUploader copies the laps into the local or network folder, e.g. the team NAS. The folder that can't
+// be written is treated as the unreachable server, the lap is retried until it is back.
+type folderUploader struct {
+	name string
+	path string
+}
+
+func (u *folderUploader) Name() string {
+	return u.name
+}
+
+func (u *folderUploader) Upload(lap *LapUpload) error {
+	if err := os.MkdirAll(u.path, 0755); err != nil {
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("failed to create the folder: %w", err)}
+	}
+
+	// the others reading the folder never see the half copied lap
+	tmp, err := os.CreateTemp(u.path, lap.Name+".*"+lapfile.TEMP_SUFFIX)
+	if err != nil {
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("failed to create the file: %w", err)}
+	}
+	defer os.Remove(tmp.Name())
+	// readable by the team, some network shares don't support it
+	_ = tmp.Chmod(0644)
+
+	if _, err := io.Copy(tmp, lap.body()); err != nil {
+		tmp.Close()
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("failed to write the lap: %w", err)}
+	}
+	if err := tmp.Close(); err != nil {
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("failed to write the lap: %w", err)}
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(u.path, lap.Name)); err != nil {
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("failed to move the lap into the folder: %w", err)}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The resumable upload is the subset of the tus protocol (https://tus.io/protocols/resumable-upload).
+// The upload is created with POST, the server returns its location. The payload is sent in chunks with PATCH,
+// every chunk starts at the offset the server has. After the failure HEAD tells the offset to continue from.
+
+// TUS_VERSION is the version of the tus protocol
+const TUS_VERSION = "1.0.0"
+
+// CHUNK_RETRIES is the number of times the failed chunk is sent again before the upload fails
+const CHUNK_RETRIES = 3
+
+// chunkSize is the size of the chunks of the resumable upload
+var chunkSize = 256 * 1024
+
+// resumableSupport caches whether the upload URL supports the resumable uploads
+var resumableSupport sync.Map
+
+// resumableUpload uploads the payloads to the server supporting the resumable uploads
+type resumableUpload struct {
+	client        *http.Client
+	url           string
+	authorization string
+	limiter       *bandwidthLimiter
+}
+
+// supportsResumable asks the server with OPTIONS whether it supports the resumable uploads. Only the clear answer
+// is cached, the server failing to answer is asked again the next time.
+func supportsResumable(client *http.Client, uploadURL string) (bool, error) {
+	if supported, ok := resumableSupport.Load(uploadURL); ok {
+		return supported.(bool), nil
+	}
+
+	req, err := http.NewRequest(http.MethodOptions, uploadURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("Error creating upload request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("Error sending upload request: %w", err)}
+	}
+	resp.Body.Close()
+
+	supported := resp.StatusCode/100 == 2 && resp.Header.Get("Tus-Resumable") != "" &&
+		strings.Contains(resp.Header.Get("Tus-Version"), TUS_VERSION)
+	if resp.StatusCode/100 == 2 || resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		resumableSupport.Store(uploadURL, supported)
+	}
+	return supported, nil
+}
+
+// upload sends the payload. The upload of the previous attempt at the location is continued, the location
+// of the new upload is passed to onCreated so it can be continued after the failure.
+func (u *resumableUpload) upload(name string, payload []byte, location string, onCreated func(location string)) error {
+	offset := 0
+	if location != "" {
+		var err error
+		offset, err = u.offset(location)
+		if err == nil && offset > len(payload) {
+			err = invalidOffset(offset, len(payload))
+		}
+		if expired(err) {
+			// the server forgot the upload, it starts over
+			location = ""
+		} else if err != nil {
+			return err
+		}
+	}
+	if location == "" {
+		var err error
+		location, err = u.create(name, len(payload))
+		if err != nil {
+			return err
+		}
+		onCreated(location)
+		offset = 0
+	}
+
+	retries := 0
+	for offset < len(payload) {
+		next, err := u.patch(location, offset, payload[offset:min(offset+chunkSize, len(payload))])
+		var failure *Failure
+		if errors.As(err, &failure) && expired(err) {
+			// not the lap's fault, the next attempt starts over
+			failure.Kind = FAILURE_SERVER
+			return failure
+		}
+		if err != nil {
+			if failure == nil || (failure.Kind != FAILURE_NETWORK && failure.StatusCode != http.StatusConflict) || retries >= CHUNK_RETRIES {
+				return err
+			}
+			// the chunk might have been received partially, the server says where to continue
+			retries++
+			if next, err = u.offset(location); err != nil {
+				return err
+			}
+		} else if next <= offset {
+			// the server that doesn't take the chunk would be sent it forever
+			return &Failure{Kind: FAILURE_SERVER, Err: fmt.Errorf("upload offset did not advance past %d", offset)}
+		} else {
+			retries = 0
+		}
+		if next > len(payload) {
+			return invalidOffset(next, len(payload))
+		}
+		offset = next
+	}
+	return nil
+}
+
+// invalidOffset is the failure of the server claiming to have more than the payload
+func invalidOffset(offset, length int) *Failure {
+	return &Failure{Kind: FAILURE_SERVER, Err: fmt.Errorf("upload offset %d is past the end of the payload of %d bytes", offset, length)}
+}
+
+// create starts the new upload and returns its location
+func (u *resumableUpload) create(name string, length int) (string, error) {
+	req, err := u.newRequest(http.MethodPost, u.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name))+
+		",encoding "+base64.StdEncoding.EncodeToString([]byte("gzip")))
+
+	resp, err := u.do(req, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return "", &Failure{Kind: FAILURE_SERVER, StatusCode: resp.StatusCode, Reason: "upload created without location"}
+	}
+	base, err := url.Parse(u.url)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload URL: %w", err)
+	}
+	return base.ResolveReference(location).String(), nil
+}
+
+// offset returns how much of the upload the server has
+func (u *resumableUpload) offset(location string) (int, error) {
+	req, err := u.newRequest(http.MethodHead, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := u.do(req, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return 0, err
+	}
+	return parseOffset(resp)
+}
+
+// patch sends the chunk at the offset and returns the offset the server has after it
+func (u *resumableUpload) patch(location string, offset int, chunk []byte) (int, error) {
+	req, err := u.newRequest(http.MethodPatch, location, u.limiter.reader(bytes.NewReader(chunk)))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+
+	resp, err := u.do(req, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	return parseOffset(resp)
+}
+
+func (u *resumableUpload) newRequest(method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating upload request: %w", err)
+	}
+	req.Header.Set("Tus-Resumable", TUS_VERSION)
+	if u.authorization != "" {
+		req.Header.Set("Authorization", u.authorization)
+	}
+	return req, nil
+}
+
+// do sends the request and returns the failure unless the response has one of the expected statuses.
+// The body of the successful response is closed.
+func (u *resumableUpload) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("Error sending upload request: %w", err)}
+	}
+	defer resp.Body.Close()
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	return nil, failureOf(resp)
+}
+
+// failureOf classifies the failed response
+func failureOf(resp *http.Response) *Failure {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxReasonLength))
+	failure := classifyStatus(resp.StatusCode, body)
+	failure.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return failure
+}
+
+// expired says whether the server doesn't know the upload anymore
+func expired(err error) bool {
+	var failure *Failure
+	return errors.As(err, &failure) && (failure.StatusCode == http.StatusNotFound || failure.StatusCode == http.StatusGone)
+}
+
+func parseOffset(resp *http.Response) (int, error) {
+	offset, err := strconv.Atoi(resp.Header.Get("Upload-Offset"))
+	if err != nil || offset < 0 {
+		return 0, &Failure{Kind: FAILURE_SERVER, StatusCode: resp.StatusCode,
+			Reason: fmt.Sprintf("invalid Upload-Offset '%s'", resp.Header.Get("Upload-Offset"))}
+	}
+	return offset, nil
+}
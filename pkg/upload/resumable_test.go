@@ -0,0 +1,285 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// tusServer is the stand-in for the server supporting the resumable uploads
+type tusServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	uploads   map[string]*tusServerUpload
+	creates   int
+	patches   int
+	received  int
+	completed [][]byte
+	// failPatches fails the patch with the number, 0 drops the connection after half of the chunk
+	failPatches map[int]int
+	// stalled accepts the patches without taking the chunks
+	stalled bool
+}
+
+type tusServerUpload struct {
+	length int
+	data   []byte
+}
+
+// Helper to create the tus stand-in server and use it for the uploads
+func setupTusServer(t *testing.T, appState *state.AppState) *tusServer {
+	server := &tusServer{uploads: map[string]*tusServerUpload{}, failPatches: map[int]int{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+	appState.UploadURL = server.URL + "/files"
+	return server
+}
+
+func (s *tusServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Tus-Resumable", TUS_VERSION)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", TUS_VERSION)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != TUS_VERSION {
+		http.Error(w, "not a tus request", http.StatusPreconditionFailed)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		length, err := strconv.Atoi(r.Header.Get("Upload-Length"))
+		if err != nil {
+			http.Error(w, "missing length", http.StatusBadRequest)
+			return
+		}
+		s.creates++
+		id := strconv.Itoa(s.creates)
+		s.uploads[id] = &tusServerUpload{length: length}
+		w.Header().Set("Location", "/files/"+id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	upload, ok := s.uploads[strings.TrimPrefix(r.URL.Path, "/files/")]
+	if !ok {
+		http.Error(w, "unknown upload", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.data)))
+	case http.MethodPatch:
+		s.patches++
+		if status, fail := s.failPatches[s.patches]; fail {
+			if status == 0 {
+				half := make([]byte, r.ContentLength/2)
+				n, _ := io.ReadFull(r.Body, half)
+				upload.data = append(upload.data, half[:n]...)
+				s.received += n
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, "lap has no frames", status)
+			return
+		}
+		if r.Header.Get("Upload-Offset") != strconv.Itoa(len(upload.data)) {
+			http.Error(w, "wrong offset", http.StatusConflict)
+			return
+		}
+		chunk, _ := io.ReadAll(r.Body)
+		if s.stalled {
+			chunk = nil
+		}
+		upload.data = append(upload.data, chunk...)
+		s.received += len(chunk)
+		if len(upload.data) == upload.length {
+			s.completed = append(s.completed, upload.data)
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.data)))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// Helper to upload in small chunks
+func setChunkSize(t *testing.T, size int) {
+	original := chunkSize
+	chunkSize = size
+	t.Cleanup(func() {
+		chunkSize = original
+	})
+}
+
+// Helper to save a lap with frames, so it takes several chunks
+func saveLargeLap(t *testing.T, appState *state.AppState) (string, []byte) {
+	lap := &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 105000, Timestamp: 1740000000}
+	for i := 0; i < 100; i++ {
+		lap.Frames = append(lap.Frames, &message.Frame{CurrentTime: int32(i * 1050), SpeedKmh: float32(i * 3), Gear: int32(i % 6)})
+	}
+	file := filepath.Join(appState.UploadDir, lapfile.Name(lap))
+	assert.NoError(t, lapfile.Save(file, lap))
+	payload, err := lapfile.ReadPayload(file)
+	assert.NoError(t, err)
+	return lapfile.Name(lap), payload
+}
+
+func TestUploadResumable(t *testing.T) {
+	setChunkSize(t, 100)
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	file, payload := saveLargeLap(t, appState)
+	assert.Greater(t, len(payload), 2*chunkSize)
+
+	assert.NoError(t, runBatch(appState))
+
+	assert.Equal(t, [][]byte{payload}, server.completed)
+	assert.Equal(t, (len(payload)+chunkSize-1)/chunkSize, server.patches)
+	assert.FileExists(t, filepath.Join(appState.UploadedDir, file))
+	assert.Empty(t, loadRetries(appState).Laps)
+}
+
+func TestUploadResumableRecoversDroppedConnection(t *testing.T) {
+	setChunkSize(t, 100)
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	_, payload := saveLargeLap(t, appState)
+	server.failPatches[2] = 0
+
+	assert.NoError(t, runBatch(appState))
+
+	// the rest of the chunk is sent again, nothing twice
+	assert.Equal(t, [][]byte{payload}, server.completed)
+	assert.Equal(t, len(payload), server.received)
+}
+
+func TestUploadResumableContinuesNextAttempt(t *testing.T) {
+	setChunkSize(t, 100)
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	file, payload := saveLargeLap(t, appState)
+	server.failPatches[2] = http.StatusServiceUnavailable
+
+	assert.Error(t, runBatch(appState))
+	location := loadRetries(appState).Laps[file].Locations[state.DESTINATION_RACEMATE]
+	assert.Equal(t, server.URL+"/files/1", location)
+
+	uploaded, err := UploadAll(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, uploaded)
+	assert.Equal(t, 1, server.creates)
+	assert.Equal(t, len(payload), server.received)
+	assert.Equal(t, [][]byte{payload}, server.completed)
+}
+
+func TestUploadResumableStartsOverExpiredUpload(t *testing.T) {
+	setChunkSize(t, 100)
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	_, payload := saveLargeLap(t, appState)
+	server.failPatches[2] = http.StatusServiceUnavailable
+
+	assert.Error(t, runBatch(appState))
+	server.uploads = map[string]*tusServerUpload{}
+
+	_, err := UploadAll(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.creates)
+	assert.Equal(t, [][]byte{payload}, server.completed)
+}
+
+func TestUploadResumableRejected(t *testing.T) {
+	setChunkSize(t, 100)
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	file, _ := saveLargeLap(t, appState)
+	server.failPatches[1] = http.StatusBadRequest
+
+	assert.NoError(t, runBatch(appState))
+	assert.FileExists(t, filepath.Join(appState.RejectedDir, file))
+	assert.Empty(t, server.completed)
+}
+
+func TestUploadResumableStalledOffset(t *testing.T) {
+	setChunkSize(t, 100)
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	file, _ := saveLargeLap(t, appState)
+	server.stalled = true
+
+	_, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 1})
+	var failure *Failure
+	assert.ErrorAs(t, err, &failure)
+	assert.Equal(t, FAILURE_SERVER, failure.Kind)
+	assert.ErrorContains(t, err, "upload offset did not advance past 0")
+	assert.Equal(t, 1, server.patches)
+	assert.FileExists(t, filepath.Join(appState.UploadDir, file))
+}
+
+func TestSupportsResumable(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	supported, err := supportsResumable(plain.Client(), plain.URL)
+	assert.NoError(t, err)
+	assert.False(t, supported)
+
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	supported, err = supportsResumable(server.Client(), appState.UploadURL)
+	assert.NoError(t, err)
+	assert.True(t, supported)
+
+	// the answer is cached
+	server.Close()
+	supported, err = supportsResumable(server.Client(), appState.UploadURL)
+	assert.NoError(t, err)
+	assert.True(t, supported)
+}
+
+func TestSupportsResumableServerError(t *testing.T) {
+	appState, _ := setupTestUpload(t)
+	server := setupTusServer(t, appState)
+	failing := true
+	tus := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		tus.ServeHTTP(w, r)
+	})
+
+	// the server error is not the answer, the server is asked again
+	supported, err := supportsResumable(server.Client(), appState.UploadURL)
+	assert.NoError(t, err)
+	assert.False(t, supported)
+	failing = false
+	supported, err = supportsResumable(server.Client(), appState.UploadURL)
+	assert.NoError(t, err)
+	assert.True(t, supported)
+
+	// the server without the method says clearly it is not supported
+	notAllowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}))
+	defer notAllowed.Close()
+	supported, err = supportsResumable(notAllowed.Client(), notAllowed.URL)
+	assert.NoError(t, err)
+	assert.False(t, supported)
+	_, cached := resumableSupport.Load(notAllowed.URL)
+	assert.True(t, cached)
+}
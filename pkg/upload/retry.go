@@ -0,0 +1,263 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// RETRIES_FILE is the name of the file in the data directory with the failed attempts of the waiting laps
+const RETRIES_FILE = "upload_retries.json"
+
+// RetryPolicy says when the failed upload of the lap is tried again
+type RetryPolicy struct {
+	// BaseDelay is the delay after the first failure, it doubles with every next failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAttempts is the number of server errors after which the lap is given up and moved to the rejected
+	// directory. Network and login failures are retried forever, they are not the lap's fault.
+	MaxAttempts int
+}
+
+// DefaultRetryPolicy retries after 30 seconds, then up to once an hour, and gives up after 10 server errors
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    time.Hour,
+		MaxAttempts: 10,
+	}
+}
+
+var retryPolicy = DefaultRetryPolicy()
+
+// Delay returns the delay after the given number of failed attempts. The delay is randomized between half
+// and the full exponential delay, so the laps don't retry all at once.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	return delay/2 + time.Duration(rand.Int64N(int64(delay/2)+1))
+}
+
+// lapRetry is the record of the failed attempts of the lap
+type lapRetry struct {
+	Attempts    int         `json:"attempts"`
+	LastKind    FailureKind `json:"lastKind"`
+	LastError   string      `json:"lastError"`
+	NextAttempt time.Time   `json:"nextAttempt"`
+	// Locations are the resumable uploads started by the previous attempt by the destination
+	Locations map[string]string `json:"locations,omitempty"`
+	// Delivered are the destinations that already have the lap
+	Delivered []string `json:"delivered,omitempty"`
+}
+
+// retries holds the failed attempts of the waiting laps, persisted so the backoff survives the restart
+type retries struct {
+	mu      sync.Mutex
+	file    string
+	logger  *slog.Logger
+	changed bool
+	Laps    map[string]*lapRetry `json:"laps"`
+}
+
+// loadRetries reads the retries file, missing or broken file gives no retries
+func loadRetries(appState *state.AppState) *retries {
+	r := &retries{file: filepath.Join(appState.DataDir, RETRIES_FILE), logger: appState.Logger, Laps: map[string]*lapRetry{}}
+	data, err := os.ReadFile(r.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			appState.Logger.Warn("Failed to read upload retries", "error", err)
+		}
+		return r
+	}
+	if err := json.Unmarshal(data, r); err != nil || r.Laps == nil {
+		appState.Logger.Warn("Failed to parse upload retries, starting over", "error", err)
+		r.Laps = map[string]*lapRetry{}
+	}
+	return r
+}
+
+// ready says whether the lap can be uploaded now
+func (r *retries) ready(file string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	retry, ok := r.Laps[file]
+	return !ok || !now.Before(retry.NextAttempt)
+}
+
+// failed records the failed attempt and schedules the next one, at least after the time the server asked for
+func (r *retries) failed(file string, failure *Failure, now time.Time) lapRetry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	retry := r.retry(file)
+	retry.Attempts++
+	retry.LastKind = failure.Kind
+	retry.LastError = failure.Error()
+	retry.NextAttempt = now.Add(max(retryPolicy.Delay(retry.Attempts), failure.RetryAfter))
+	r.changed = true
+	return *retry
+}
+
+// park puts the lap aside until the next attempt after the longest delay, the error is not the lap's failure to
+// count. Returns the next attempt.
+func (r *retries) park(file string, err error, now time.Time) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	retry := r.retry(file)
+	retry.LastKind = FAILURE_UNSUPPORTED
+	retry.LastError = err.Error()
+	retry.NextAttempt = now.Add(retryPolicy.MaxDelay)
+	r.changed = true
+	return retry.NextAttempt
+}
+
+// location returns the resumable upload of the lap to the destination started before
+func (r *retries) location(file, destination string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if retry, ok := r.Laps[file]; ok {
+		return retry.Locations[destination]
+	}
+	return ""
+}
+
+// started records the resumable upload of the lap to the destination. It is saved right away, so the upload
+// continues also after the crash.
+func (r *retries) started(file, destination, location string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	retry := r.retry(file)
+	if retry.Locations == nil {
+		retry.Locations = map[string]string{}
+	}
+	retry.Locations[destination] = location
+	r.changed = true
+	r.mu.Unlock()
+
+	if err := r.save(); err != nil {
+		r.logger.Warn("Failed to save upload retries", "error", err)
+	}
+}
+
+// delivered says whether the destination already has the lap
+func (r *retries) delivered(file, destination string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if retry, ok := r.Laps[file]; ok {
+		return slices.Contains(retry.Delivered, destination)
+	}
+	return false
+}
+
+// markDelivered records that the destination has the lap, the next attempt skips it
+func (r *retries) markDelivered(file, destination string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	retry := r.retry(file)
+	retry.Delivered = append(retry.Delivered, destination)
+	delete(retry.Locations, destination)
+	r.changed = true
+}
+
+// retry returns the record of the lap, creating it when missing
+func (r *retries) retry(file string) *lapRetry {
+	retry, ok := r.Laps[file]
+	if !ok {
+		retry = &lapRetry{}
+		r.Laps[file] = retry
+	}
+	return retry
+}
+
+// forget removes the record of the lap that left the queue
+func (r *retries) forget(file string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(file)
+}
+
+func (r *retries) remove(file string) {
+	if _, ok := r.Laps[file]; ok {
+		delete(r.Laps, file)
+		r.changed = true
+	}
+}
+
+// prune forgets the laps that are not waiting anymore, e.g. deleted by the user
+func (r *retries) prune(entries []os.DirEntry) {
+	waiting := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), lapfile.SUFFIX) {
+			waiting[entry.Name()] = true
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for file := range r.Laps {
+		if !waiting[file] {
+			r.remove(file)
+		}
+	}
+}
+
+// save writes the retries file if anything changed
+func (r *retries) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload retries: %w", err)
+	}
+	tmpFile := r.file + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload retries: %w", err)
+	}
+	if err := os.Rename(tmpFile, r.file); err != nil {
+		return fmt.Errorf("failed to write upload retries: %w", err)
+	}
+	r.changed = false
+	return nil
+}
+
+// parseRetryAfter parses the Retry-After header, it is either the number of seconds or the HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package upload
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to use the retry policy in the test
+func setRetryPolicy(t *testing.T, policy RetryPolicy) {
+	original := retryPolicy
+	retryPolicy = policy
+	t.Cleanup(func() {
+		retryPolicy = original
+	})
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: time.Hour}
+	for _, test := range []struct {
+		attempts int
+		full     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{8, time.Hour},
+		{1000, time.Hour},
+	} {
+		for i := 0; i < 20; i++ {
+			delay := policy.Delay(test.attempts)
+			assert.GreaterOrEqual(t, delay, test.full/2)
+			assert.LessOrEqual(t, delay, test.full)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Sat, 01 Mar 2025 10:01:30 GMT", now))
+	assert.Zero(t, parseRetryAfter("Sat, 01 Mar 2025 09:00:00 GMT", now))
+	assert.Zero(t, parseRetryAfter("-5", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter("", now))
+}
+
+func TestUploadBacksOff(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	server.statuses = []int{http.StatusServiceUnavailable}
+
+	assert.Error(t, runBatch(appState))
+	assert.Equal(t, 1, server.requests)
+
+	// the lap waits for its next attempt, also after the restart as the attempts are persisted
+	assert.FileExists(t, filepath.Join(appState.DataDir, RETRIES_FILE))
+	assert.NoError(t, runBatch(appState))
+	assert.Equal(t, 1, server.requests)
+	retries := loadRetries(appState)
+	assert.Equal(t, 1, retries.Laps[file].Attempts)
+	assert.Equal(t, FAILURE_SERVER, retries.Laps[file].LastKind)
+	assert.True(t, retries.Laps[file].NextAttempt.After(time.Now()))
+
+	// explicit upload doesn't wait
+	uploaded, err := UploadAll(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, uploaded)
+	assert.Empty(t, loadRetries(appState).Laps)
+}
+
+func TestUploadHonorsRetryAfter(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	server.statuses = []int{http.StatusTooManyRequests}
+	server.retryAfter = "7200"
+
+	err := runBatch(appState)
+	var failure *Failure
+	assert.ErrorAs(t, err, &failure)
+	assert.Equal(t, 2*time.Hour, failure.RetryAfter)
+	assert.True(t, failure.NextAttempt.After(time.Now().Add(119*time.Minute)))
+	assert.Equal(t, failure.NextAttempt.Unix(), loadRetries(appState).Laps[file].NextAttempt.Unix())
+}
+
+func TestUploadGivesUp(t *testing.T) {
+	setRetryPolicy(t, RetryPolicy{MaxAttempts: 2})
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	server.statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError}
+
+	assert.Error(t, runBatch(appState))
+	assert.FileExists(t, filepath.Join(appState.UploadDir, file))
+
+	assert.NoError(t, runBatch(appState))
+	assert.FileExists(t, filepath.Join(appState.RejectedDir, file))
+	sidecar, err := os.ReadFile(filepath.Join(appState.RejectedDir, file+REJECTED_SIDECAR_SUFFIX))
+	assert.NoError(t, err)
+	assert.Contains(t, string(sidecar), "gave up after 2 attempts")
+	assert.Empty(t, loadRetries(appState).Laps)
+}
+
+func TestUploadRetriesNetworkFailuresForever(t *testing.T) {
+	setRetryPolicy(t, RetryPolicy{MaxAttempts: 1})
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	server.Close()
+
+	assert.Error(t, runBatch(appState))
+	assert.Error(t, runBatch(appState))
+	assert.FileExists(t, filepath.Join(appState.UploadDir, file))
+	assert.Equal(t, 2, loadRetries(appState).Laps[file].Attempts)
+}
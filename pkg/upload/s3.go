@@ -0,0 +1,163 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// S3_DEFAULT_REGION is the region used when the destination doesn't set one, MinIO and most of the S3
+// compatible stores accept it
+const S3_DEFAULT_REGION = "us-east-1"
+
+// s3Uploader puts the laps into the bucket of the S3 compatible object store. The objects are addressed by
+// the path (endpoint/bucket/key), which works with AWS as well as with MinIO and others.
+type s3Uploader struct {
+	name            string
+	endpoint        string
+	region          string
+	bucket          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+}
+
+func newS3Uploader(destination state.UploadDestination, client *http.Client) *s3Uploader {
+	region := destination.Region
+	if region == "" {
+		region = S3_DEFAULT_REGION
+	}
+	return &s3Uploader{
+		name:            destination.DisplayName(),
+		endpoint:        strings.TrimSuffix(destination.Endpoint, "/"),
+		region:          region,
+		bucket:          destination.Bucket,
+		prefix:          destination.Prefix,
+		accessKeyID:     destination.AccessKeyID,
+		secretAccessKey: destination.SecretAccessKey,
+		client:          client,
+	}
+}
+
+func (u *s3Uploader) Name() string {
+	return u.name
+}
+
+func (u *s3Uploader) Upload(lap *LapUpload) error {
+	target := u.endpoint + "/" + url.PathEscape(u.bucket) + "/" + escapeKey(u.prefix+lap.Name)
+	req, err := http.NewRequest(http.MethodPut, target, lap.body())
+	if err != nil {
+		return fmt.Errorf("Error creating upload request: %w", err)
+	}
+	req.ContentLength = int64(len(lap.Payload))
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	payloadHash := sha256.Sum256(lap.Payload)
+	signV4(req, hex.EncodeToString(payloadHash[:]), u.accessKeyID, u.secretAccessKey, u.region, time.Now())
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("Error sending upload request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		failure := failureOf(resp)
+		// the object store takes any lap, the refusal is the missing bucket, the wrong region and alike
+		if failure.Kind == FAILURE_REJECTED {
+			failure.Kind = FAILURE_CONFIG
+		}
+		return failure
+	}
+	return nil
+}
+
+// escapeKey escapes the object key, the slashes separate the "folders" and stay
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// signV4 signs the request with AWS Signature Version 4 (https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html).
+// The host and all the headers set on the request are signed.
+func signV4(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host"}
+	for name := range req.Header {
+		signedHeaders = append(signedHeaders, strings.ToLower(name))
+	}
+	sort.Strings(signedHeaders)
+	signature := v4Signature(req, signedHeaders, payloadHash, secretAccessKey, region, amzDate)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, v4Scope(amzDate, region), strings.Join(signedHeaders, ";"), signature))
+}
+
+// v4Signature computes the signature of the request over the sorted signed headers
+func v4Signature(req *http.Request, signedHeaders []string, payloadHash, secretAccessKey, region, amzDate string) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := strings.TrimSpace(strings.Join(req.Header.Values(name), ","))
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + v4Scope(amzDate, region) + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func v4Scope(amzDate, region string) string {
+	return amzDate[:8] + "/" + region + "/s3/aws4_request"
+}
+
+// canonicalQuery sorts the query parameters by the name and the value
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, url.QueryEscape(name)+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
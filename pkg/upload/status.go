@@ -0,0 +1,160 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// Status is the state of the upload queue
+type Status struct {
+	// Pending is the number of laps waiting for the upload, PendingBytes is their size on the disk
+	Pending      int
+	PendingBytes int64
+	// InFlight are the laps being uploaded right now
+	InFlight    []string
+	LastSuccess time.Time
+	LastError   string
+	LastErrorAt time.Time
+	// NextRetry is the earliest next attempt of the laps that failed, zero when no lap is waiting for the retry
+	NextRetry time.Time
+	// Paused is set when the user paused the automatic upload
+	Paused bool
+}
+
+// queueState tracks what the uploads in this process are doing
+type queueState struct {
+	mu          sync.Mutex
+	inFlight    map[string]bool
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+	paused      bool
+	// running lets only one batch upload at a time, so the lap is never uploaded twice
+	running sync.Mutex
+}
+
+var queue = &queueState{inFlight: map[string]bool{}}
+
+func (q *queueState) started(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight[name] = true
+}
+
+// finished records the outcome of the lap upload
+func (q *queueState) finished(name string, uploaded bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, name)
+	now := time.Now()
+	if err != nil {
+		q.lastError = err.Error()
+		q.lastErrorAt = now
+	} else if uploaded {
+		q.lastSuccess = now
+		q.lastError = ""
+		q.lastErrorAt = time.Time{}
+	}
+}
+
+// Pause stops the automatic upload until Resume. Uploads started by the user still run.
+func Pause() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.paused = true
+}
+
+// Resume continues the automatic upload
+func Resume() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.paused = false
+}
+
+// Paused says whether the user paused the automatic upload
+func Paused() bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.paused
+}
+
+// QueueStatus returns the state of the upload queue
+func QueueStatus(appState *state.AppState) (Status, error) {
+	status := Status{}
+	queue.mu.Lock()
+	for name := range queue.inFlight {
+		status.InFlight = append(status.InFlight, name)
+	}
+	status.LastSuccess = queue.lastSuccess
+	status.LastError = queue.lastError
+	status.LastErrorAt = queue.lastErrorAt
+	status.Paused = queue.paused
+	queue.mu.Unlock()
+	sort.Strings(status.InFlight)
+
+	entries, err := os.ReadDir(appState.UploadDir)
+	if err != nil {
+		return status, fmt.Errorf("failed to read upload directory: %w", err)
+	}
+	retries := loadRetries(appState)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), lapfile.SUFFIX) {
+			continue
+		}
+		status.Pending++
+		if info, err := entry.Info(); err == nil {
+			status.PendingBytes += info.Size()
+		}
+		if retry, ok := retries.Laps[entry.Name()]; ok && !retry.NextAttempt.IsZero() {
+			if status.NextRetry.IsZero() || retry.NextAttempt.Before(status.NextRetry) {
+				status.NextRetry = retry.NextAttempt
+			}
+			// the failure of the previous run of the app, nothing was uploaded since
+			if status.LastErrorAt.IsZero() && status.LastSuccess.IsZero() && retry.LastError != "" {
+				status.LastError = retry.LastError
+			}
+		}
+	}
+	return status, nil
+}
+
+// Summary describes the status in one line, e.g. for the tray tooltip
+func (s Status) Summary() string {
+	var summary string
+	switch s.Pending {
+	case 0:
+		summary = "All laps uploaded"
+	case 1:
+		summary = fmt.Sprintf("1 lap waiting (%s)", FormatBytes(s.PendingBytes))
+	default:
+		summary = fmt.Sprintf("%d laps waiting (%s)", s.Pending, FormatBytes(s.PendingBytes))
+	}
+	switch {
+	case len(s.InFlight) > 0:
+		summary += fmt.Sprintf(", uploading %d", len(s.InFlight))
+	case s.Paused:
+		summary += ", paused"
+	case s.Pending > 0 && s.NextRetry.After(time.Now()):
+		summary += ", next retry at " + s.NextRetry.Local().Format(time.TimeOnly)
+	}
+	return summary
+}
+
+// FormatBytes formats the size for the user, e.g. 1.5 MB
+func FormatBytes(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
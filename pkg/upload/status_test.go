@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueStatus(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	saveTestLap(t, appState, 1740000100)
+	info, err := os.Stat(filepath.Join(appState.UploadDir, file))
+	assert.NoError(t, err)
+
+	status, err := QueueStatus(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Pending)
+	assert.Equal(t, 2*info.Size(), status.PendingBytes)
+	assert.Empty(t, status.InFlight)
+	assert.True(t, status.NextRetry.IsZero())
+
+	server.statuses = []int{http.StatusServiceUnavailable}
+	assert.Error(t, runBatch(appState))
+
+	status, err = QueueStatus(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Pending)
+	assert.Contains(t, status.LastError, "503")
+	assert.True(t, status.NextRetry.After(time.Now()))
+	assert.True(t, status.LastSuccess.IsZero())
+
+	assert.NoError(t, runBatch(appState))
+
+	status, err = QueueStatus(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.Pending)
+	assert.Empty(t, status.LastError)
+	assert.False(t, status.LastSuccess.IsZero())
+	// the failed lap still waits for the retry
+	assert.True(t, status.NextRetry.After(time.Now()))
+}
+
+func TestQueueStatusInFlight(t *testing.T) {
+	appState, _ := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+
+	inFlight := make(chan []string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			return
+		}
+		status, _ := QueueStatus(appState)
+		inFlight <- status.InFlight
+	}))
+	defer server.Close()
+	appState.UploadURL = server.URL
+
+	assert.NoError(t, runBatch(appState))
+	assert.Equal(t, []string{file}, <-inFlight)
+
+	status, err := QueueStatus(appState)
+	assert.NoError(t, err)
+	assert.Empty(t, status.InFlight)
+}
+
+func TestPause(t *testing.T) {
+	setupTestUpload(t)
+	assert.False(t, Paused())
+	Pause()
+	assert.True(t, Paused())
+	Resume()
+	assert.False(t, Paused())
+}
+
+func TestStatusSummary(t *testing.T) {
+	assert.Equal(t, "All laps uploaded", Status{}.Summary())
+	assert.Equal(t, "1 lap waiting (512 B)", Status{Pending: 1, PendingBytes: 512}.Summary())
+	assert.Equal(t, "3 laps waiting (1.5 MB), uploading 2",
+		Status{Pending: 3, PendingBytes: 1536 * 1024, InFlight: []string{"a", "b"}}.Summary())
+	assert.Equal(t, "3 laps waiting (2.0 KB), paused", Status{Pending: 3, PendingBytes: 2048, Paused: true}.Summary())
+	next := time.Now().Add(time.Minute)
+	assert.Equal(t, "1 lap waiting (10 B), next retry at "+next.Local().Format(time.TimeOnly),
+		Status{Pending: 1, PendingBytes: 10, NextRetry: next}.Summary())
+}
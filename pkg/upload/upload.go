@@ -1,18 +1,23 @@
 package upload
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
 	"github.com/sparkoo/racemate-desktop/pkg/state"
 )
 
+// TEMP_FILE_MAX_AGE is the age of the temporary lap file after which it is considered left by a crash
+const TEMP_FILE_MAX_AGE = 10 * time.Minute
+
 func UploadJob(ctx context.Context) error {
 	appState, err := state.GetAppState(ctx)
 	if err != nil {
@@ -23,9 +28,18 @@ func UploadJob(ctx context.Context) error {
 	authManager := auth.NewAuthManager(appState)
 
 	ticker := time.NewTicker(5 * time.Second)
-	for range ticker.C {
-		// Skip upload if telemetry is online (we're racing)
-		if appState.TelemetryOnline {
+	defer ticker.Stop()
+	// after the failure the whole queue waits for the failed lap, the other laps would likely fail the same way
+	var pausedUntil time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Skip upload if telemetry is online (we're racing) or user turned the automatic upload off or paused it
+		if appState.TelemetryOnline || !appState.Settings().AutoUpload || Paused() || time.Now().Before(pausedUntil) {
 			continue
 		}
 
@@ -35,8 +49,8 @@ func UploadJob(ctx context.Context) error {
 			continue
 		}
 
-		// Check if user is authenticated before attempting upload
-		if !authManager.IsLoggedIn() {
+		// Check if user is authenticated before attempting upload to RaceMate
+		if NeedsLogin(appState) && !authManager.IsLoggedIn() {
 			// Log this only occasionally to avoid spamming the log
 			if time.Now().Second()%30 == 0 {
 				appState.Logger.Info("Skipping upload: user not logged in but has laps to upload")
@@ -44,13 +58,21 @@ func UploadJob(ctx context.Context) error {
 			continue
 		}
 
-		// Proceed with upload since user is authenticated and has laps
-		if uploadErr := UploadSingleLap(appState); uploadErr != nil {
-			appState.Logger.Error("Failed to upload a single lap", "error", uploadErr)
+		// Proceed with upload since user is authenticated or doesn't need to be and has laps
+		if _, uploadErr := UploadBatch(ctx, appState, batchOptions(appState, false)); uploadErr != nil {
+			appState.Logger.Error("Failed to upload the laps", "error", uploadErr)
+			var failure *Failure
+			if errors.As(uploadErr, &failure) {
+				pausedUntil = failure.NextAttempt
+			}
 		}
 	}
+}
 
-	return nil
+// UploadAll uploads all waiting laps, including the laps waiting for the retry. Laps rejected
+// by the server are skipped, it stops at the first other failure. Returns number of uploaded laps.
+func UploadAll(appState *state.AppState) (int, error) {
+	return UploadBatch(context.Background(), appState, batchOptions(appState, true))
 }
 
 // hasLapsToUpload checks if there are any lap files waiting to be uploaded
@@ -62,83 +84,224 @@ func hasLapsToUpload(appState *state.AppState) bool {
 	}
 
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".lap.gzip") {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), lapfile.SUFFIX) {
 			return true
 		}
 	}
 	return false
 }
 
-func UploadSingleLap(appState *state.AppState) error {
-	entries, err := os.ReadDir(appState.UploadDir)
-	if err != nil {
-		appState.Logger.Error("Failed to read upload directory", "error", err)
-		return fmt.Errorf("failed to read upload directory: %w", err)
+// uploadLap uploads the lap and moves it to the directory by the outcome. Returns false when the lap
+// was moved away or put aside without the upload.
+func uploadLap(appState *state.AppState, retries *retries, name string, limiter *bandwidthLimiter) (uploaded bool, err error) {
+	queue.started(name)
+	defer func() {
+		queue.finished(name, uploaded, err)
+	}()
+
+	appState.Logger.Info("Uploading lap file", "filename", name)
+	lapFile := filepath.Join(appState.UploadDir, name)
+	uploadErr := uploadFile(lapFile, appState, limiter, retries)
+	if errors.Is(uploadErr, lapfile.ErrCorrupted) {
+		// broken file would block the other laps forever
+		appState.Logger.Warn("Lap file is corrupted, moving it to quarantine", "filename", name, "error", uploadErr)
+		retries.forget(name)
+		return false, quarantine(appState, lapFile)
+	}
+	if errors.Is(uploadErr, lapfile.ErrUnsupportedVersion) {
+		// the lap can be uploaded after the update of the app, meanwhile it doesn't block the other laps
+		nextAttempt := retries.park(name, uploadErr, time.Now())
+		appState.Logger.Warn("Lap file is of the newer version, skipping it", "filename", name,
+			"nextAttempt", nextAttempt, "error", uploadErr)
+		return false, nil
 	}
 
+	var failure *Failure
+	if errors.As(uploadErr, &failure) && !failure.Permanent() {
+		retry := retries.failed(name, failure, time.Now())
+		if failure.Kind != FAILURE_SERVER || retry.Attempts < retryPolicy.MaxAttempts {
+			failure.NextAttempt = retry.NextAttempt
+			return false, fmt.Errorf("Failed to upload the file, next attempt at %s: %w", retry.NextAttempt.Format(time.TimeOnly), uploadErr)
+		}
+		failure.Reason = fmt.Sprintf("gave up after %d attempts: %s", retry.Attempts, failure.Reason)
+	}
+	if failure != nil {
+		appState.Logger.Warn("Lap was rejected by the server, moving it to rejected directory",
+			"filename", name, "status", failure.StatusCode, "reason", failure.Reason)
+		retries.forget(name)
+		return false, reject(appState, lapFile, failure)
+	}
+	if uploadErr != nil {
+		return false, fmt.Errorf("Failed to upload the file: %w", uploadErr)
+	}
+
+	appState.Logger.Info("File uploaded successfully")
+	retries.forget(name)
+	if err := os.Rename(lapFile, filepath.Join(appState.UploadedDir, name)); err != nil {
+		return false, fmt.Errorf("failed to move the file '%s' to uploaded directory: %w", name, err)
+	}
+	appState.Logger.Info("File moved to uploaded directory")
+	if appState.Library != nil {
+		if err := appState.Library.MarkUploaded(name); err != nil {
+			appState.Logger.Warn("Failed to update the lap library", "error", err)
+		}
+	}
+	return true, nil
+}
+
+// quarantineTempFiles moves away the temporary lap files left by a crash while the lap was being saved.
+// Fresh temporary files are still being written, they are left alone.
+func quarantineTempFiles(appState *state.AppState, entries []os.DirEntry) {
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".lap.gzip") {
-			appState.Logger.Info("Uploading lap file", "filename", entry.Name())
-			lapFile := fmt.Sprintf("%s/%s", appState.UploadDir, entry.Name())
-			uploadErr := UploadFile(lapFile, appState)
-			if uploadErr != nil {
-				return fmt.Errorf("Failed to upload the file: %w", uploadErr)
-			}
-			appState.Logger.Info("File uploaded successfully")
-			err := os.Rename(lapFile, fmt.Sprintf("%s/%s", appState.UploadedDir, entry.Name()))
-			if err != nil {
-				return fmt.Errorf("failed to move the file '%s' to uploaded directory: %w", entry.Name(), err)
-			}
-			appState.Logger.Info("File moved to uploaded directory")
-			break
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), lapfile.TEMP_SUFFIX) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < TEMP_FILE_MAX_AGE {
+			continue
+		}
+		appState.Logger.Warn("Moving partially written lap file to quarantine", "filename", entry.Name())
+		if err := quarantine(appState, filepath.Join(appState.UploadDir, entry.Name())); err != nil {
+			appState.Logger.Error("Failed to quarantine the file", "error", err)
 		}
 	}
+}
+
+// quarantine moves the file to the quarantine directory
+func quarantine(appState *state.AppState, filename string) error {
+	if err := os.MkdirAll(appState.QuarantineDir, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(filename, filepath.Join(appState.QuarantineDir, filepath.Base(filename))); err != nil {
+		return fmt.Errorf("failed to move the file '%s' to quarantine: %w", filepath.Base(filename), err)
+	}
 	return nil
 }
 
+// UploadFile uploads the lap file to all the destinations
 func UploadFile(filename string, appState *state.AppState) error {
-	fileBytes, readFileErr := os.ReadFile(filename)
+	return uploadFile(filename, appState, nil, nil)
+}
+
+// uploadFile uploads the lap file to all the destinations. The destinations that got the lap and the locations
+// of the resumable uploads are kept in the retries, so the failed upload continues where it stopped. The failure
+// that is retried wins, the lap is rejected only when no destination has it.
+func uploadFile(filename string, appState *state.AppState, limiter *bandwidthLimiter, retries *retries) error {
+	// the file is validated, so the broken laps are not sent to the server
+	fileBytes, readFileErr := lapfile.ReadPayload(filename)
 	if readFileErr != nil {
 		return fmt.Errorf("failed to read the file for the upload: %w", readFileErr)
 	}
 
+	uploaders, err := NewUploaders(appState)
+	if err != nil {
+		return err
+	}
+	lap := &LapUpload{Name: filepath.Base(filename), Payload: fileBytes, limiter: limiter, retries: retries}
+	var uploadErr, rejection error
+	delivered := false
+	for _, uploader := range uploaders {
+		if retries.delivered(lap.Name, uploader.Name()) {
+			delivered = true
+			continue
+		}
+		if err := uploader.Upload(lap); err != nil {
+			appState.Logger.Warn("Failed to upload the lap", "filename", lap.Name, "destination", uploader.Name(), "error", err)
+			if !isPermanent(err) && uploadErr == nil {
+				uploadErr = err
+			} else if isPermanent(err) && rejection == nil {
+				rejection = err
+			}
+			continue
+		}
+		delivered = true
+		appState.Logger.Info("Upload completed", "filename", lap.Name, "destination", uploader.Name())
+		if len(uploaders) > 1 {
+			retries.markDelivered(lap.Name, uploader.Name())
+		}
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+	if rejection != nil && delivered {
+		// retrying won't help the destination that refused it, the others keep the lap
+		appState.Logger.Warn("Lap was rejected by some of the destinations, the others have it", "filename", lap.Name, "error", rejection)
+		return nil
+	}
+	return rejection
+}
+
+// isPermanent says whether the error is the failure that repeats when retried
+func isPermanent(err error) bool {
+	var failure *Failure
+	return errors.As(err, &failure) && failure.Permanent()
+}
+
+// httpUploader uploads to the RaceMate endpoint, resumable when the server supports it
+type httpUploader struct {
+	name     string
+	url      string
+	appState *state.AppState
+}
+
+func (u *httpUploader) Name() string {
+	return u.name
+}
+
+func (u *httpUploader) Upload(lap *LapUpload) error {
+	authorization := authorization(u.appState)
+	client := &http.Client{}
+
+	resumable, err := supportsResumable(client, u.url)
+	if err != nil {
+		return err
+	}
+	if resumable {
+		upload := &resumableUpload{client: client, url: u.url, authorization: authorization, limiter: lap.limiter}
+		return upload.upload(lap.Name, lap.Payload, lap.retries.location(lap.Name, u.name), func(location string) {
+			lap.retries.started(lap.Name, u.name, location)
+		})
+	}
+
 	// Create HTTP request
-	url := appState.UploadURL
-	req, err := http.NewRequest("POST", url, bytes.NewReader(fileBytes))
+	req, err := http.NewRequest("POST", u.url, lap.body())
 	if err != nil {
 		return fmt.Errorf("Error creating upload request: %w", err)
 	}
+	req.ContentLength = int64(len(lap.Payload))
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("content-encoding", "gzip")
-
-	// Add authorization token if user is logged in
-	authManager := auth.NewAuthManager(appState)
-	if authManager.IsLoggedIn() {
-		user, err := authManager.GetCurrentUser()
-		if err == nil && user != nil {
-			req.Header.Set("Authorization", "Bearer "+user.IDToken)
-			appState.Logger.Debug("Added auth token to upload request")
-		} else {
-			appState.Logger.Error("Failed to get user for auth token", "error", err)
-		}
-	} else {
-		appState.Logger.Info("User not logged in, upload will be unauthorized")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
 	}
 
 	// Send request using http.Client
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Error sending upload request: %w", err)
+		return &Failure{Kind: FAILURE_NETWORK, Err: fmt.Errorf("Error sending upload request: %w", err)}
 	}
 	defer resp.Body.Close()
 
-	// Print response
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Upload failed with status code: %d %s", resp.StatusCode, resp.Status)
+		return failureOf(resp)
 	}
-	appState.Logger.Info("Upload completed", "status", resp.Status)
 	return nil
 }
+
+// authorization returns the Authorization header with the token of the logged in user
+func authorization(appState *state.AppState) string {
+	authManager := auth.NewAuthManager(appState)
+	if !authManager.IsLoggedIn() {
+		appState.Logger.Info("User not logged in, upload will be unauthorized")
+		return ""
+	}
+	user, err := authManager.GetCurrentUser()
+	if err != nil || user == nil {
+		appState.Logger.Error("Failed to get user for auth token", "error", err)
+		return ""
+	}
+	appState.Logger.Debug("Added auth token to upload request")
+	return "Bearer " + user.IDToken
+}
@@ -0,0 +1,221 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/lapfile"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	message "github.com/sparkoo/racemate-msg/dist"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer records the bodies of the accepted single POST uploads. It responds with the statuses in order, then with 200.
+type testServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	requests   int
+	uploads    [][]byte
+	statuses   []int
+	retryAfter string
+}
+
+// Helper to create the app state uploading to the test server
+func setupTestUpload(t *testing.T) (*state.AppState, *testServer) {
+	server := &testServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server doesn't support the resumable uploads
+		if r.Method == http.MethodOptions {
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.requests++
+		if len(server.statuses) > 0 {
+			status := server.statuses[0]
+			server.statuses = server.statuses[1:]
+			if status != http.StatusOK {
+				if server.retryAfter != "" {
+					w.Header().Set("Retry-After", server.retryAfter)
+				}
+				http.Error(w, "lap has no frames", status)
+				return
+			}
+		}
+		server.uploads = append(server.uploads, body)
+	}))
+	t.Cleanup(server.Close)
+	// the queue state is shared by the whole package
+	queue = &queueState{inFlight: map[string]bool{}}
+
+	dataDir := t.TempDir()
+	appState := &state.AppState{
+		DataDir:       dataDir,
+		UploadDir:     filepath.Join(dataDir, "upload"),
+		UploadedDir:   filepath.Join(dataDir, "uploaded"),
+		QuarantineDir: filepath.Join(dataDir, "quarantine"),
+		RejectedDir:   filepath.Join(dataDir, "rejected"),
+		UserSettings:  state.UserSettings{UploadURL: server.URL},
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})),
+	}
+	assert.NoError(t, os.MkdirAll(appState.UploadDir, 0755))
+	assert.NoError(t, os.MkdirAll(appState.UploadedDir, 0755))
+	return appState, server
+}
+
+// Helper to save a valid lap into the upload directory
+func saveTestLap(t *testing.T, appState *state.AppState, timestamp uint64) string {
+	lap := &message.Lap{Track: "monza", CarModel: "ferrari_296_gt3", LapTimeMs: 105000, Timestamp: timestamp}
+	assert.NoError(t, lapfile.Save(filepath.Join(appState.UploadDir, lapfile.Name(lap)), lap))
+	return lapfile.Name(lap)
+}
+
+// Helper to run the batch upload the way the upload job does with a single worker
+func runBatch(appState *state.AppState) error {
+	_, err := UploadBatch(context.Background(), appState, BatchOptions{Workers: 1})
+	return err
+}
+
+func TestUploadLap(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	file := saveTestLap(t, appState, 1740000000)
+	payload, err := lapfile.ReadPayload(filepath.Join(appState.UploadDir, file))
+	assert.NoError(t, err)
+
+	assert.NoError(t, runBatch(appState))
+
+	// the server gets the gzip compressed lap without the header
+	assert.Equal(t, [][]byte{payload}, server.uploads)
+	assert.FileExists(t, filepath.Join(appState.UploadedDir, file))
+	assert.False(t, hasLapsToUpload(appState))
+}
+
+func TestUploadIgnoresTempFiles(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	tempFile := filepath.Join(appState.UploadDir, "1740000000_monza_ferrari_296_gt3"+lapfile.SUFFIX+".123"+lapfile.TEMP_SUFFIX)
+	assert.NoError(t, os.WriteFile(tempFile, []byte{0x1f, 0x8b}, 0644))
+
+	assert.False(t, hasLapsToUpload(appState))
+	assert.NoError(t, runBatch(appState))
+
+	// the file might be still written
+	assert.Empty(t, server.uploads)
+	assert.FileExists(t, tempFile)
+}
+
+func TestUploadQuarantinesStaleTempFiles(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	name := "1740000000_monza_ferrari_296_gt3" + lapfile.SUFFIX + ".123" + lapfile.TEMP_SUFFIX
+	tempFile := filepath.Join(appState.UploadDir, name)
+	assert.NoError(t, os.WriteFile(tempFile, []byte{0x1f, 0x8b}, 0644))
+	stale := time.Now().Add(-2 * TEMP_FILE_MAX_AGE)
+	assert.NoError(t, os.Chtimes(tempFile, stale, stale))
+
+	assert.NoError(t, runBatch(appState))
+
+	assert.Empty(t, server.uploads)
+	assert.NoFileExists(t, tempFile)
+	assert.FileExists(t, filepath.Join(appState.QuarantineDir, name))
+}
+
+func TestUploadQuarantinesCorruptedLaps(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	corrupted := saveTestLap(t, appState, 1740000000)
+	data, err := os.ReadFile(filepath.Join(appState.UploadDir, corrupted))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(appState.UploadDir, corrupted), data[:len(data)/2], 0644))
+	valid := saveTestLap(t, appState, 1740000100)
+
+	assert.NoError(t, runBatch(appState))
+
+	assert.Len(t, server.uploads, 1)
+	assert.FileExists(t, filepath.Join(appState.QuarantineDir, corrupted))
+	assert.FileExists(t, filepath.Join(appState.UploadedDir, valid))
+}
+
+func TestUploadRejected(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	rejected := saveTestLap(t, appState, 1740000000)
+	valid := saveTestLap(t, appState, 1740000100)
+	server.statuses = []int{http.StatusBadRequest}
+
+	assert.NoError(t, runBatch(appState))
+
+	// the rejected lap doesn't block the others
+	assert.Len(t, server.uploads, 1)
+	assert.FileExists(t, filepath.Join(appState.UploadedDir, valid))
+	assert.FileExists(t, filepath.Join(appState.RejectedDir, rejected))
+
+	data, err := os.ReadFile(filepath.Join(appState.RejectedDir, rejected+REJECTED_SIDECAR_SUFFIX))
+	assert.NoError(t, err)
+	rejection := Rejection{}
+	assert.NoError(t, json.Unmarshal(data, &rejection))
+	assert.Equal(t, rejected, rejection.File)
+	assert.Equal(t, FAILURE_REJECTED, rejection.Kind)
+	assert.Equal(t, http.StatusBadRequest, rejection.StatusCode)
+	assert.Equal(t, "lap has no frames\n", rejection.Reason)
+}
+
+func TestUploadTransientFailures(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		kind   FailureKind
+	}{
+		{http.StatusUnauthorized, FAILURE_AUTH},
+		{http.StatusForbidden, FAILURE_AUTH},
+		{http.StatusTooManyRequests, FAILURE_SERVER},
+		{http.StatusInternalServerError, FAILURE_SERVER},
+		{http.StatusServiceUnavailable, FAILURE_SERVER},
+	} {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			appState, server := setupTestUpload(t)
+			file := saveTestLap(t, appState, 1740000000)
+			saveTestLap(t, appState, 1740000100)
+			server.statuses = []int{test.status}
+
+			err := runBatch(appState)
+			var failure *Failure
+			assert.ErrorAs(t, err, &failure)
+			assert.Equal(t, test.kind, failure.Kind)
+			assert.False(t, failure.Permanent())
+
+			// the lap stays in the queue to be retried
+			assert.FileExists(t, filepath.Join(appState.UploadDir, file))
+			assert.Empty(t, server.uploads)
+		})
+	}
+}
+
+func TestUploadNetworkFailure(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	saveTestLap(t, appState, 1740000000)
+	server.Close()
+
+	err := runBatch(appState)
+	var failure *Failure
+	assert.ErrorAs(t, err, &failure)
+	assert.Equal(t, FAILURE_NETWORK, failure.Kind)
+}
+
+func TestUploadAllSkipsRejected(t *testing.T) {
+	appState, server := setupTestUpload(t)
+	saveTestLap(t, appState, 1740000000)
+	saveTestLap(t, appState, 1740000100)
+	saveTestLap(t, appState, 1740000200)
+	server.statuses = []int{http.StatusOK, http.StatusUnprocessableEntity}
+
+	uploaded, err := UploadAll(appState)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, uploaded)
+	assert.False(t, hasLapsToUpload(appState))
+}
@@ -2,6 +2,9 @@ package webserver
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -9,29 +12,49 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
-	"fyne.io/fyne/v2"
 	"github.com/sparkoo/racemate-desktop/pkg/auth"
 	"github.com/sparkoo/racemate-desktop/pkg/config"
 	"github.com/sparkoo/racemate-desktop/pkg/state"
 )
 
+// DEFAULT_PORT is the port of the local login server
+const DEFAULT_PORT = 12123
+
+// NONCE_HEADER carries the nonce of the login page in the login request
+const NONCE_HEADER = "X-RaceMate-Nonce"
+
+// URLOpener opens the URL in a browser, fyne.App is one
+type URLOpener interface {
+	OpenURL(url *url.URL) error
+}
+
 // Server represents the web server
 type Server struct {
 	server         *http.Server
 	port           int
 	isActive       bool
-	app            fyne.App
+	app            URLOpener
 	firebaseConfig *config.FirebaseConfig
 	embeddedConfig *FirebaseConfigJSON
 	timeoutTimer   *time.Timer
 	timeoutDone    chan bool
 	authManager    *auth.AuthManager
+	verifier       IDTokenVerifier
+	// nonce is embedded into the login page and required by the login, it is used once
+	nonceMu sync.Mutex
+	nonce   string
+}
+
+// IDTokenVerifier verifies the ID token posted by the login page, auth.TokenVerifier is one
+type IDTokenVerifier interface {
+	VerifyIDToken(idToken, uid string) (*auth.IDTokenClaims, error)
 }
 
 // NewServer creates a new web server instance
-func NewServer(port int, app fyne.App) *Server {
+func NewServer(port int, app URLOpener) *Server {
 	// Load Firebase config from environment variables for backward compatibility
 	firebaseConfig := config.NewFirebaseConfig(
 		os.Getenv("FIREBASE_API_KEY"),
@@ -58,9 +81,27 @@ func NewServer(port int, app fyne.App) *Server {
 	}
 }
 
-// SetAuthManager sets the auth manager for the server
+// SetAuthManager sets the auth manager for the server and the verifier of the tokens of the Firebase project
 func (s *Server) SetAuthManager(appState *state.AppState) {
 	s.authManager = auth.NewAuthManager(appState)
+	if projectID := s.projectID(); projectID != "" {
+		s.verifier = auth.NewTokenVerifier(appState, projectID)
+	} else {
+		slog.Warn("Firebase project ID is not configured, logins can't be verified")
+	}
+}
+
+// SetTokenVerifier replaces the verifier of the ID tokens
+func (s *Server) SetTokenVerifier(verifier IDTokenVerifier) {
+	s.verifier = verifier
+}
+
+// projectID returns the Firebase project ID, the embedded config is preferred
+func (s *Server) projectID() string {
+	if s.embeddedConfig != nil && s.embeddedConfig.ProjectID != "" {
+		return s.embeddedConfig.ProjectID
+	}
+	return s.firebaseConfig.ProjectID
 }
 
 // Start starts the web server
@@ -72,14 +113,19 @@ func (s *Server) Start() error {
 	// Initialize timeout channels
 	s.timeoutDone = make(chan bool)
 
+	if err := s.newNonce(); err != nil {
+		return err
+	}
+
 	mux := http.NewServeMux()
 
 	// Register routes
 	mux.HandleFunc("/", s.handleLogin)
 	mux.HandleFunc("/login", s.handleLoginSubmit)
 
+	// only this computer can reach the login
 	s.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.port),
+		Addr:    fmt.Sprintf("127.0.0.1:%d", s.port),
 		Handler: mux,
 	}
 
@@ -128,6 +174,9 @@ func (s *Server) Stop() error {
 	}
 
 	s.isActive = false
+	s.nonceMu.Lock()
+	s.nonce = ""
+	s.nonceMu.Unlock()
 	return nil
 }
 
@@ -138,6 +187,13 @@ func (s *Server) IsActive() bool {
 
 // handleLogin serves the login page
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	// the page holds the nonce, it must not be readable by other sites through DNS rebinding
+	if !s.isLocalHost(r.Host) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		slog.Warn("Rejecting login page request for unexpected host", "host", r.Host)
+		return
+	}
+
 	// Parse the template from the embedded filesystem
 	tmplContent, err := templateFS.ReadFile("templates/login.html")
 	if err != nil {
@@ -176,7 +232,12 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		slog.Debug("Rendering template with environment Firebase config")
 	}
 
+	s.nonceMu.Lock()
+	templateData["LoginNonce"] = s.nonce
+	s.nonceMu.Unlock()
+
 	// Pass Firebase configuration to the template
+	w.Header().Set("Cache-Control", "no-store")
 	err = tmpl.Execute(w, templateData)
 	if err != nil {
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
@@ -191,6 +252,13 @@ func (s *Server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// only the login page served by this server can log in
+	if err := s.checkRequestOrigin(r); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		slog.Warn("Rejecting login request", "error", err)
+		return
+	}
+
 	// Parse JSON request body with full user data
 	var userData struct {
 		IDToken       string                   `json:"idToken"`
@@ -212,9 +280,18 @@ func (s *Server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Verify the Firebase ID token
-	// In a production environment, you should verify the token with Firebase Admin SDK
-	// For now, we'll just log the user information and create a session
+	// Any local process can post here, only the token signed by Google for this user is trusted
+	if s.verifier == nil {
+		http.Error(w, "Login can't be verified", http.StatusInternalServerError)
+		slog.Error("Rejecting login, no token verifier")
+		return
+	}
+	claims, err := s.verifier.VerifyIDToken(userData.IDToken, userData.UID)
+	if err != nil {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		slog.Warn("Rejecting login with invalid ID token", "uid", userData.UID, "error", err)
+		return
+	}
 
 	// Log user information (safely handling sensitive data)
 	slog.Info("Login attempt", 
@@ -229,23 +306,24 @@ func (s *Server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 	}
 	slog.Debug("Firebase token received", "token", tokenPreview)
 
+	// the page can't be used to log in again
+	if !s.useNonce(r.Header.Get(NONCE_HEADER)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		slog.Warn("Rejecting login request, the nonce was already used")
+		return
+	}
+
 	// Save user data if auth manager is available
 	if s.authManager != nil {
-		// Set expiration time
-		expiresInSeconds := 3600 // Default to 1 hour if not provided
-		if userData.ExpiresIn > 0 {
-			expiresInSeconds = int(userData.ExpiresIn) // Convert float to int
-		}
-
-		// Create user data object
+		// Create user data object, the token expires when it says
 		authData := &auth.UserData{
-			UID:          userData.UID,
+			UID:          claims.Subject,
 			Email:        userData.Email,
 			DisplayName:  userData.DisplayName,
 			PhotoURL:     userData.PhotoURL,
 			IDToken:      userData.IDToken,
 			RefreshToken: userData.RefreshToken,
-			ExpiresAt:    time.Now().Add(time.Duration(expiresInSeconds) * time.Second),
+			ExpiresAt:    time.Unix(claims.ExpiresAt, 0),
 		}
 
 		// Save to persistent storage
@@ -258,16 +336,6 @@ func (s *Server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 		slog.Warn("Auth manager not set, user data not saved")
 	}
 
-	// Set a cookie or session to maintain login state
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "authenticated", // In production, use a secure session ID
-		Path:     "/",
-		MaxAge:   3600, // 1 hour
-		HttpOnly: true,
-		Secure:   r.TLS != nil, // Set to true in production with HTTPS
-	})
-
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -288,7 +356,59 @@ func (s *Server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
-// openBrowser opens the default browser to the login page using Fyne
+// newNonce generates the nonce of the login page
+func (s *Server) newNonce() error {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate login nonce: %w", err)
+	}
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	s.nonce = base64.RawURLEncoding.EncodeToString(nonce)
+	return nil
+}
+
+// checkRequestOrigin checks that the request was sent to this server by the login page served by it
+func (s *Server) checkRequestOrigin(r *http.Request) error {
+	if !s.isLocalHost(r.Host) {
+		return fmt.Errorf("unexpected host '%s'", r.Host)
+	}
+	// browsers send the origin with every POST, other local programs might not
+	if origin := r.Header.Get("Origin"); origin != "" {
+		originURL, err := url.Parse(origin)
+		if err != nil || originURL.Scheme != "http" || !s.isLocalHost(originURL.Host) {
+			return fmt.Errorf("unexpected origin '%s'", origin)
+		}
+	}
+	if !s.validNonce(r.Header.Get(NONCE_HEADER)) {
+		return fmt.Errorf("invalid nonce")
+	}
+	return nil
+}
+
+// isLocalHost says whether the host is this server on the loopback
+func (s *Server) isLocalHost(host string) bool {
+	return host == fmt.Sprintf("localhost:%d", s.port) || host == fmt.Sprintf("127.0.0.1:%d", s.port)
+}
+
+func (s *Server) validNonce(nonce string) bool {
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	return s.nonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(s.nonce)) == 1
+}
+
+// useNonce invalidates the nonce if it is still valid, so it can't be used again
+func (s *Server) useNonce(nonce string) bool {
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	if s.nonce == "" || subtle.ConstantTimeCompare([]byte(nonce), []byte(s.nonce)) != 1 {
+		return false
+	}
+	s.nonce = ""
+	return true
+}
+
+// openBrowser opens the default browser to the login page
 func (s *Server) openBrowser() {
 	urlStr := fmt.Sprintf("http://localhost:%d", s.port)
 	parsedURL, err := url.Parse(urlStr)
@@ -297,9 +417,8 @@ func (s *Server) openBrowser() {
 		return
 	}
 
-	// Use Fyne's OpenURL function to open the browser
 	err = s.app.OpenURL(parsedURL)
 	if err != nil {
-		slog.Error("Error opening browser with Fyne", "error", err)
+		slog.Error("Error opening browser", "error", err)
 	}
 }
@@ -0,0 +1,167 @@
+package webserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sparkoo/racemate-desktop/pkg/auth"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVerifier accepts only the token "valid" of the user "user-1"
+type fakeVerifier struct {
+	expiresAt time.Time
+}
+
+func (v *fakeVerifier) VerifyIDToken(idToken, uid string) (*auth.IDTokenClaims, error) {
+	if idToken != "valid" || uid != "user-1" {
+		return nil, fmt.Errorf("%w: signature doesn't match", auth.ErrInvalidToken)
+	}
+	return &auth.IDTokenClaims{Subject: uid, ExpiresAt: v.expiresAt.Unix()}, nil
+}
+
+// Helper to create the login server saving the user into the temporary data directory
+func setupTestServer(t *testing.T) (*Server, *state.AppState) {
+	appState := &state.AppState{
+		DataDir:      t.TempDir(),
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		UserSettings: state.UserSettings{CredentialStore: auth.SECRET_STORE_FILE},
+	}
+	server := NewServer(DEFAULT_PORT, nil)
+	server.SetAuthManager(appState)
+	assert.NoError(t, server.newNonce())
+	return server, appState
+}
+
+// Helper to create the login request as the login page sends it
+func newLoginRequest(server *Server, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(body))
+	req.Host = fmt.Sprintf("localhost:%d", DEFAULT_PORT)
+	req.Header.Set("Origin", fmt.Sprintf("http://localhost:%d", DEFAULT_PORT))
+	req.Header.Set(NONCE_HEADER, server.nonce)
+	return req
+}
+
+// Helper to send the login request
+func sendLogin(server *Server, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.handleLoginSubmit(recorder, req)
+	return recorder
+}
+
+// Helper to post the login as the login page does
+func postLogin(server *Server, body string) *httptest.ResponseRecorder {
+	return sendLogin(server, newLoginRequest(server, body))
+}
+
+func TestLoginSubmit(t *testing.T) {
+	server, appState := setupTestServer(t)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	server.SetTokenVerifier(&fakeVerifier{expiresAt: expiresAt})
+
+	response := postLogin(server, `{"idToken": "valid", "uid": "user-1", "displayName": "Test User", "expiresIn": 99999}`)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	user, err := auth.NewAuthManager(appState).GetCurrentUser()
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", user.UID)
+	assert.Equal(t, "Test User", user.DisplayName)
+	// the expiration comes from the verified token
+	assert.True(t, expiresAt.Equal(user.ExpiresAt))
+}
+
+func TestLoginSubmitInvalidToken(t *testing.T) {
+	server, appState := setupTestServer(t)
+	server.SetTokenVerifier(&fakeVerifier{expiresAt: time.Now().Add(time.Hour)})
+
+	for _, body := range []string{
+		`{"idToken": "forged", "uid": "user-1"}`,
+		`{"idToken": "valid", "uid": "user-2"}`,
+	} {
+		response := postLogin(server, body)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	}
+
+	user, err := auth.NewAuthManager(appState).GetCurrentUser()
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+func TestLoginSubmitWithoutVerifier(t *testing.T) {
+	server, appState := setupTestServer(t)
+	server.SetTokenVerifier(nil)
+
+	response := postLogin(server, `{"idToken": "valid", "uid": "user-1"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	user, err := auth.NewAuthManager(appState).GetCurrentUser()
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+func TestLoginSubmitNonceUsedOnce(t *testing.T) {
+	server, _ := setupTestServer(t)
+	server.SetTokenVerifier(&fakeVerifier{expiresAt: time.Now().Add(time.Hour)})
+	body := `{"idToken": "valid", "uid": "user-1"}`
+	replay := newLoginRequest(server, body)
+
+	assert.Equal(t, http.StatusOK, postLogin(server, body).Code)
+	assert.Equal(t, http.StatusForbidden, sendLogin(server, replay).Code)
+}
+
+func TestLoginSubmitForeignRequests(t *testing.T) {
+	body := `{"idToken": "valid", "uid": "user-1"}`
+	tests := map[string]func(req *http.Request){
+		"other origin":  func(req *http.Request) { req.Header.Set("Origin", "https://evil.example.com") },
+		"https origin":  func(req *http.Request) { req.Header.Set("Origin", fmt.Sprintf("https://localhost:%d", DEFAULT_PORT)) },
+		"rebinded host": func(req *http.Request) { req.Host = fmt.Sprintf("evil.example.com:%d", DEFAULT_PORT) },
+		"LAN host":      func(req *http.Request) { req.Host = fmt.Sprintf("192.168.1.10:%d", DEFAULT_PORT) },
+		"missing nonce": func(req *http.Request) { req.Header.Del(NONCE_HEADER) },
+		"wrong nonce":   func(req *http.Request) { req.Header.Set(NONCE_HEADER, "guess") },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, appState := setupTestServer(t)
+			server.SetTokenVerifier(&fakeVerifier{expiresAt: time.Now().Add(time.Hour)})
+			req := newLoginRequest(server, body)
+			modify(req)
+
+			assert.Equal(t, http.StatusForbidden, sendLogin(server, req).Code)
+			user, err := auth.NewAuthManager(appState).GetCurrentUser()
+			assert.NoError(t, err)
+			assert.Nil(t, user)
+		})
+	}
+
+	// local programs don't send the origin
+	server, _ := setupTestServer(t)
+	server.SetTokenVerifier(&fakeVerifier{expiresAt: time.Now().Add(time.Hour)})
+	req := newLoginRequest(server, body)
+	req.Header.Del("Origin")
+	req.Host = fmt.Sprintf("127.0.0.1:%d", DEFAULT_PORT)
+	assert.Equal(t, http.StatusOK, sendLogin(server, req).Code)
+}
+
+func TestLoginPage(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = fmt.Sprintf("localhost:%d", DEFAULT_PORT)
+	recorder := httptest.NewRecorder()
+	server.handleLogin(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), server.nonce)
+
+	req.Host = fmt.Sprintf("evil.example.com:%d", DEFAULT_PORT)
+	recorder = httptest.NewRecorder()
+	server.handleLogin(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), server.nonce)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sparkoo/racemate-desktop/pkg/settings"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+)
+
+// showSettingsWindow opens the window to edit the user settings
+func showSettingsWindow(myApp fyne.App, appState *state.AppState) {
+	current, err := settings.Load(appState.DataDir)
+	if err != nil {
+		appState.Logger.Warn("Failed to load settings, editing defaults", "error", err)
+		current = settings.Default()
+	}
+
+	settingsWindow := myApp.NewWindow("RaceMate Settings")
+
+	pollRateEntry := widget.NewEntry()
+	pollRateEntry.SetText(strconv.Itoa(current.PollRateMs))
+	uploadURLEntry := widget.NewEntry()
+	uploadURLEntry.SetText(current.UploadURL)
+	autoUploadCheck := widget.NewCheck("Upload laps automatically", nil)
+	autoUploadCheck.SetChecked(current.AutoUpload)
+	recordCheck := widget.NewCheck("Record raw telemetry sessions", nil)
+	recordCheck.SetChecked(current.RecordTelemetry)
+	pbCheck := widget.NewCheck("Track separately for every grip and rain", nil)
+	pbCheck.SetChecked(current.PBByConditions)
+	logLevelSelect := widget.NewSelect(settings.LOG_LEVELS, nil)
+	logLevelSelect.SetSelected(current.LogLevel)
+	portEntry := widget.NewEntry()
+	portEntry.SetText(strconv.Itoa(current.WebServerPort))
+	workersEntry := widget.NewEntry()
+	workersEntry.SetText(strconv.Itoa(current.UploadWorkers))
+	bandwidthEntry := widget.NewEntry()
+	bandwidthEntry.SetText(strconv.Itoa(current.UploadBandwidthKBps))
+
+	form := &widget.Form{
+		Items: []*widget.FormItem{
+			{Text: "Poll rate (ms)", Widget: pollRateEntry, HintText: "Applies to the next session"},
+			{Text: "Upload URL", Widget: uploadURLEntry},
+			{Text: "Upload", Widget: autoUploadCheck},
+			{Text: "Parallel uploads", Widget: workersEntry},
+			{Text: "Upload speed (KB/s)", Widget: bandwidthEntry, HintText: "0 is unlimited"},
+			{Text: "Recording", Widget: recordCheck},
+			{Text: "Personal bests", Widget: pbCheck},
+			{Text: "Log level", Widget: logLevelSelect},
+			{Text: "Login port", Widget: portEntry, HintText: "Applies after restart"},
+		},
+		SubmitText: "Save",
+		OnSubmit: func() {
+			updated := *current
+			pollRate, err := strconv.Atoi(pollRateEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("poll rate must be a number"), settingsWindow)
+				return
+			}
+			port, err := strconv.Atoi(portEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("login port must be a number"), settingsWindow)
+				return
+			}
+			workers, err := strconv.Atoi(workersEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("parallel uploads must be a number"), settingsWindow)
+				return
+			}
+			bandwidth, err := strconv.Atoi(bandwidthEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("upload speed must be a number"), settingsWindow)
+				return
+			}
+			updated.PollRateMs = pollRate
+			updated.UploadURL = uploadURLEntry.Text
+			updated.AutoUpload = autoUploadCheck.Checked
+			updated.RecordTelemetry = recordCheck.Checked
+			updated.PBByConditions = pbCheck.Checked
+			updated.LogLevel = logLevelSelect.Selected
+			updated.WebServerPort = port
+			updated.UploadWorkers = workers
+			updated.UploadBandwidthKBps = bandwidth
+
+			if err := updated.Save(appState.DataDir); err != nil {
+				dialog.ShowError(err, settingsWindow)
+				return
+			}
+			updated.Apply(appState)
+			appState.Logger.Info("Settings saved")
+			settingsWindow.Close()
+		},
+		OnCancel: settingsWindow.Close,
+	}
+
+	settingsWindow.SetContent(form)
+	settingsWindow.Resize(fyne.NewSize(450, 0))
+	settingsWindow.Show()
+}
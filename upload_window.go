@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sparkoo/racemate-desktop/pkg/state"
+	"github.com/sparkoo/racemate-desktop/pkg/upload"
+)
+
+// showUploadWindow opens the window with the state of the upload queue
+func showUploadWindow(myApp fyne.App, appState *state.AppState) {
+	uploadWindow := myApp.NewWindow("RaceMate Uploads")
+
+	pendingLabel := widget.NewLabel("")
+	inFlightLabel := widget.NewLabel("")
+	lastSuccessLabel := widget.NewLabel("")
+	lastErrorLabel := widget.NewLabel("")
+	lastErrorLabel.Wrapping = fyne.TextWrapWord
+	nextRetryLabel := widget.NewLabel("")
+
+	var pauseButton *widget.Button
+	refresh := func() {
+		status, err := upload.QueueStatus(appState)
+		if err != nil {
+			appState.Logger.Warn("Failed to read the upload queue", "error", err)
+		}
+		fyne.Do(func() {
+			pendingLabel.SetText(fmt.Sprintf("%d (%s)", status.Pending, upload.FormatBytes(status.PendingBytes)))
+			inFlightLabel.SetText(orNone(strings.Join(status.InFlight, "\n")))
+			lastSuccessLabel.SetText(formatStatusTime(status.LastSuccess))
+			lastErrorLabel.SetText(orNone(status.LastError))
+			nextRetryLabel.SetText(formatStatusTime(status.NextRetry))
+			if status.Paused {
+				pauseButton.SetText("Resume uploads")
+			} else {
+				pauseButton.SetText("Pause uploads")
+			}
+		})
+	}
+
+	uploadButton := widget.NewButton("Upload now", func() {
+		go uploadNow(appState)
+	})
+	pauseButton = widget.NewButton("Pause uploads", func() {
+		toggleUploadPause()
+		go refresh()
+	})
+
+	form := widget.NewForm(
+		widget.NewFormItem("Waiting laps", pendingLabel),
+		widget.NewFormItem("Uploading", inFlightLabel),
+		widget.NewFormItem("Last upload", lastSuccessLabel),
+		widget.NewFormItem("Last error", lastErrorLabel),
+		widget.NewFormItem("Next retry", nextRetryLabel),
+	)
+
+	stop := make(chan struct{})
+	uploadWindow.SetOnClosed(func() {
+		close(stop)
+	})
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			refresh()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	uploadWindow.SetContent(container.NewVBox(form, container.NewGridWithColumns(2, uploadButton, pauseButton)))
+	uploadWindow.Resize(fyne.NewSize(450, 0))
+	uploadWindow.Show()
+}
+
+// uploadNow uploads all waiting laps, also the laps waiting for the retry and when the uploads are paused
+func uploadNow(appState *state.AppState) {
+	uploaded, err := upload.UploadAll(appState)
+	if err != nil {
+		appState.Logger.Error("Failed to upload the laps", "error", err)
+		appState.Notify("Upload failed", err.Error())
+		return
+	}
+	appState.Logger.Info("Laps uploaded", "uploaded", uploaded)
+}
+
+// toggleUploadPause pauses or resumes the automatic upload
+func toggleUploadPause() {
+	if upload.Paused() {
+		upload.Resume()
+	} else {
+		upload.Pause()
+	}
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func orNone(text string) string {
+	if text == "" {
+		return "-"
+	}
+	return text
+}